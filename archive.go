@@ -0,0 +1,316 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+type ExtractRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// Path is a zip inside the volume, e.g. one sent with /file/upload/chunk.
+	Path string `json:"path"`
+	// Destination is the directory to unpack into, created if missing. Empty
+	// means the directory holding the archive.
+	Destination string `json:"destination"`
+	Overwrite   bool   `json:"overwrite"`
+}
+
+type ExtractResponse struct {
+	Status  string   `json:"status"`
+	Message string   `json:"message"`
+	Entries []string `json:"entries"`
+}
+
+// archiveDirHandler streams a directory of a server's volume as a zip, with
+// paths relative to that directory. Symlinks are skipped.
+func archiveDirHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	dir, err := resolveServerPath(containerId, r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := os.Lstat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "Directory not found")
+		return
+	}
+	if err != nil || !info.IsDir() {
+		writeError(w, http.StatusBadRequest, "Path is not a directory")
+		return
+	}
+
+	name := info.Name()
+	if dir == getServerDataDir(containerId) {
+		name = containerId
+	}
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": name + ".zip"}))
+
+	zw := zip.NewWriter(w)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || !d.Type().IsRegular() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return addFileToZip(zw, path, filepath.ToSlash(rel), fi)
+	})
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		// The status line is already sent; the client sees a truncated zip.
+		requestLogger(r).Error("Archive failed", "server", containerId, "path", dir, "err", err)
+	}
+}
+
+// extractHandler unpacks a zip from a server's volume into a directory of it.
+// Every entry must stay under the destination ("zip slip"); links and other
+// special entries are skipped, and existing files are only replaced with
+// overwrite. What it unpacks counts against MAX_FILE_SIZE and the disk quota.
+func extractHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req ExtractRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.Path == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and path are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	archive, err := resolveServerPath(containerId, req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid path: "+err.Error())
+		return
+	}
+	destRel := req.Destination
+	if destRel == "" {
+		destRel = filepath.ToSlash(filepath.Dir(filepath.FromSlash(req.Path)))
+	}
+	dest, err := resolveServerPath(containerId, destRel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid destination: "+err.Error())
+		return
+	}
+	if info, err := os.Lstat(archive); err != nil || !info.Mode().IsRegular() {
+		writeError(w, http.StatusNotFound, "Archive not found")
+		return
+	}
+	if info, err := os.Lstat(dest); err == nil && !info.IsDir() {
+		writeError(w, http.StatusConflict, "Destination is not a directory")
+		return
+	}
+	unlock, ok := lockServer(w, containerId, "extract")
+	if !ok {
+		return
+	}
+	defer unlock()
+	defer diskCache.forget(containerId)
+
+	zr, err := zip.OpenReader(archive)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "Not a valid zip archive: "+err.Error())
+		return
+	}
+	defer zr.Close()
+
+	room, err := quotaRoom(containerId)
+	if err != nil {
+		writeFileOpError(w, err)
+		return
+	}
+
+	// Check every entry before writing anything, so a malicious or
+	// conflicting archive is refused as a whole. The sizes in the archive
+	// are only claims, so they are checked again as the files are written.
+	var declared int64
+	for _, f := range zr.File {
+		target, err := zipEntryTarget(dest, f.Name)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid archive entry "+f.Name+": "+err.Error())
+			return
+		}
+		if linkedParent(getServerDataDir(containerId), target) {
+			writeError(w, http.StatusBadRequest, "Invalid archive entry "+f.Name+": it would be written through a symlink")
+			return
+		}
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		if f.UncompressedSize64 > uint64(maxFileSize()) {
+			writeFileOpError(w, errFileTooLarge())
+			return
+		}
+		declared += int64(f.UncompressedSize64)
+		if info, err := os.Lstat(target); err == nil {
+			if info.IsDir() {
+				writeError(w, http.StatusConflict, "Archive entry "+f.Name+" would replace a directory")
+				return
+			}
+			if !req.Overwrite {
+				writeError(w, http.StatusConflict, "Archive entry "+f.Name+" already exists; set overwrite to replace it")
+				return
+			}
+			if room >= 0 && info.Mode().IsRegular() {
+				room += info.Size()
+			}
+		}
+	}
+	if room >= 0 && declared > room {
+		writeFileOpError(w, errQuotaExceeded(containerId))
+		return
+	}
+
+	entries := []string{}
+	for _, f := range zr.File {
+		target, _ := zipEntryTarget(dest, f.Name)
+		mode := f.FileInfo().Mode()
+		if mode.IsDir() {
+			if err := os.MkdirAll(target, 0755); err != nil {
+				writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to extract %s after %d entries: %v", f.Name, len(entries), err))
+				return
+			}
+			continue
+		}
+		if !mode.IsRegular() {
+			continue
+		}
+		limit, tooLarge := maxFileSize(), errFileTooLarge()
+		if room >= 0 && room < limit {
+			limit, tooLarge = room, errQuotaExceeded(containerId)
+		}
+		written, err := extractZipFile(f, target, limit, tooLarge)
+		if err != nil {
+			code := http.StatusInternalServerError
+			var opErr *fileOpError
+			if errors.As(err, &opErr) {
+				code = opErr.code
+			}
+			writeError(w, code, fmt.Sprintf("Failed to extract %s after %d entries: %v", f.Name, len(entries), err))
+			return
+		}
+		if room >= 0 {
+			room -= written
+		}
+		rel, _ := filepath.Rel(getServerDataDir(containerId), target)
+		entries = append(entries, filepath.ToSlash(rel))
+	}
+	writeJSON(w, http.StatusOK, ExtractResponse{
+		Status:  "ok",
+		Message: fmt.Sprintf("Extracted %d files from %s", len(entries), req.Path),
+		Entries: entries,
+	})
+}
+
+// zipEntryTarget is where an archive entry lands under dest, or
+// errUnsafeArchiveEntry if its name would leave dest.
+func zipEntryTarget(dest, name string) (string, error) {
+	clean := filepath.FromSlash(name)
+	if filepath.IsAbs(clean) || filepath.VolumeName(clean) != "" {
+		return "", errUnsafeArchiveEntry
+	}
+	target := filepath.Join(dest, clean)
+	rel, err := filepath.Rel(dest, target)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", errUnsafeArchiveEntry
+	}
+	return target, nil
+}
+
+// linkedParent reports whether any existing directory between base and path
+// is a symlink, which could make a write to path land outside the volume.
+func linkedParent(base, path string) bool {
+	rel, err := filepath.Rel(base, filepath.Dir(path))
+	if err != nil || rel == "." {
+		return false
+	}
+	dir := base
+	for _, part := range strings.Split(rel, string(filepath.Separator)) {
+		dir = filepath.Join(dir, part)
+		info, err := os.Lstat(dir)
+		if err != nil {
+			return false
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// extractZipFile writes the entry to target and returns its size, failing
+// with tooLarge, and removing the file, once more than limit bytes come out
+// of it. A symlink at target is replaced rather than written through.
+func extractZipFile(f *zip.File, target string, limit int64, tooLarge error) (int64, error) {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return 0, err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return 0, err
+	}
+	defer rc.Close()
+	perm := f.FileInfo().Mode().Perm()
+	if perm == 0 {
+		perm = 0644
+	}
+	if info, err := os.Lstat(target); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(target); err != nil {
+			return 0, err
+		}
+	}
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, perm)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, io.LimitReader(rc, limit+1))
+	if err == nil && n > limit {
+		err = tooLarge
+	}
+	if err != nil {
+		out.Close()
+		os.Remove(target)
+		return n, err
+	}
+	return n, out.Close()
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// auditLog records every console command run through the agent. It is nil,
+// and nothing is recorded, unless AUDIT_LOG_FILE is set: commands can carry
+// player names and other personal data, so the trail is opt-in.
+var auditLog *slog.Logger
+
+// configureAuditLog opens AUDIT_LOG_FILE for appending JSON lines, or writes
+// them to stdout when it is "-".
+func configureAuditLog() {
+	file := os.Getenv("AUDIT_LOG_FILE")
+	if file == "" {
+		return
+	}
+	out := os.Stdout
+	if file != "-" {
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+		if err != nil {
+			fatal("Failed to open AUDIT_LOG_FILE", "err", err)
+		}
+		out = f
+	}
+	auditLog = slog.New(slog.NewJSONHandler(out, nil))
+}
+
+// Outcomes of an audited command: run, failed in RCON, or refused by the
+// command policy.
+const (
+	auditOK       = "ok"
+	auditFailed   = "failed"
+	auditRejected = "rejected"
+)
+
+// auditCommand records that a command was sent to serverName through via
+// (command, console, dashboard, or an endpoint such as ops that runs one on
+// the caller's behalf), with its outcome and the first line of
+// its output or error.
+func auditCommand(r *http.Request, via, serverName, userEmail, command, outcome, result string) {
+	if auditLog == nil {
+		return
+	}
+	summary, _, _ := strings.Cut(strings.TrimSpace(result), "\n")
+	id := identityFrom(r.Context())
+	auditLog.Info("console command",
+		"userEmail", userEmail,
+		"serverName", serverName,
+		"command", command,
+		"outcome", outcome,
+		"resultSummary", truncateLine(summary),
+		"via", via,
+		"role", id.Role,
+		"sharedToken", id.Email == "",
+		"remote", clientIP(r),
+		"requestId", requestId(r.Context()),
+	)
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	handshakeToken string
+	// previousToken is the token being rotated out. It is accepted until
+	// previousTokenExpires so clients can migrate without downtime.
+	previousToken        string
+	previousTokenExpires time.Time
+	lastDeprecatedLog    time.Time
+	deprecatedLogMu      sync.Mutex
+	// adminToken grants access to /admin endpoints. Admin endpoints are
+	// disabled when it is unset.
+	adminToken string
+)
+
+// loadToken reads HANDSHAKE_TOKEN and the other tokens from the environment,
+// after loadDotEnv has merged in .env.
+func loadToken() {
+	handshakeToken = os.Getenv("HANDSHAKE_TOKEN")
+	if handshakeToken == "" && jwtParser == nil {
+		fatal("HANDSHAKE_TOKEN is not set")
+	}
+	adminToken = os.Getenv("ADMIN_TOKEN")
+
+	previousToken = os.Getenv("HANDSHAKE_TOKEN_PREVIOUS")
+	if previousToken == "" {
+		return
+	}
+	if v := os.Getenv("HANDSHAKE_TOKEN_PREVIOUS_EXPIRES"); v != "" {
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			fatal("Invalid HANDSHAKE_TOKEN_PREVIOUS_EXPIRES: use RFC3339, e.g. 2024-06-01T00:00:00Z", "value", v)
+		}
+		previousTokenExpires = t
+	} else {
+		previousTokenExpires = time.Now().Add(envDuration("HANDSHAKE_TOKEN_GRACE", 24*time.Hour))
+	}
+	slog.Info("Previous handshake token accepted", "until", previousTokenExpires.UTC().Format(time.RFC3339))
+}
+
+// checkHandshakeToken accepts the current token, or the previous one while its
+// grace period lasts.
+func checkHandshakeToken(token string, r *http.Request) bool {
+	if token == "" {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(handshakeToken)) == 1 {
+		return true
+	}
+	if previousToken == "" || time.Now().After(previousTokenExpires) {
+		return false
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(previousToken)) != 1 {
+		return false
+	}
+	logDeprecatedToken(r)
+	return true
+}
+
+// logDeprecatedToken notes clients still on the old token, at most once a
+// minute so a polling panel doesn't flood the log.
+func logDeprecatedToken(r *http.Request) {
+	deprecatedLogMu.Lock()
+	defer deprecatedLogMu.Unlock()
+	if time.Since(lastDeprecatedLog) < time.Minute {
+		return
+	}
+	lastDeprecatedLog = time.Now()
+	requestLogger(r).Warn("Deprecated handshake token used",
+		"remote", r.RemoteAddr, "path", r.URL.Path, "expires", previousTokenExpires.UTC().Format(time.RFC3339))
+}
+
+// Identity is who a request was authenticated as. Email is empty for the
+// shared handshake token, which the panel uses to act for any user.
+type Identity struct {
+	Email string
+	// Role is the token's role claim, which selects its rule in the
+	// console command policy.
+	Role string
+}
+
+type identityKey struct{}
+
+// identityFrom returns the identity tokenMiddleware authenticated r as.
+func identityFrom(ctx context.Context) Identity {
+	id, _ := ctx.Value(identityKey{}).(Identity)
+	return id
+}
+
+// ownerEmail is the user a request acts for. A request authenticated as a
+// user always acts as that user, whatever userEmail it sent; the field is
+// only honoured under the shared handshake token. A userEmail naming someone
+// else is logged, as it points at a confused or malicious client.
+func ownerEmail(r *http.Request, userEmail string) string {
+	id := identityFrom(r.Context())
+	if id.Email == "" {
+		return userEmail
+	}
+	if userEmail != "" && extractUserId(userEmail) != extractUserId(id.Email) {
+		requestLogger(r).Warn("Ignoring userEmail that doesn't match the token",
+			"userEmail", userEmail, "token", id.Email, "path", r.URL.Path)
+	}
+	return id.Email
+}
+
+// authorizeOwner checks that the caller may act on userEmail's servers: a
+// request authenticated as a user may only touch that user's own servers.
+// It writes a 403 and returns false otherwise. Handlers pass userEmail
+// through ownerEmail first, so this only catches one that forgot to.
+func authorizeOwner(w http.ResponseWriter, r *http.Request, userEmail string) bool {
+	id := identityFrom(r.Context())
+	if id.Email == "" || extractUserId(id.Email) == extractUserId(userEmail) {
+		return true
+	}
+	writeError(w, http.StatusForbidden, "Forbidden: server belongs to another user")
+	return false
+}
+
+// bearerToken returns the Bearer credential of r, or "" if there is none.
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if token == auth {
+		return ""
+	}
+	return token
+}
+
+// tokenMiddleware rejects any request that doesn't carry a valid Bearer
+// credential: a per-user JWT when JWT verification is configured, otherwise
+// the handshake token (or the previous one during rotation).
+func tokenMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var id Identity
+		if jwtParser != nil {
+			var err error
+			if id, err = verifyJWT(bearerToken(r)); err != nil {
+				writeError(w, http.StatusUnauthorized, "Unauthorized: "+err.Error())
+				return
+			}
+		} else if !checkHandshakeToken(bearerToken(r), r) {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		ctx := context.WithValue(r.Context(), identityKey{}, id)
+		next(w, r.WithContext(ctx))
+	}
+}
+
+// adminMiddleware only lets requests carrying ADMIN_TOKEN through.
+func adminMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if adminToken == "" {
+			writeError(w, http.StatusForbidden, "Admin endpoints are disabled")
+			return
+		}
+		token := bearerToken(r)
+		if token == "" {
+			writeError(w, http.StatusUnauthorized, "Unauthorized")
+			return
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			writeError(w, http.StatusForbidden, "Forbidden")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func handshakeHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok"})
+}
+
+type WhoamiResponse struct {
+	Status string `json:"status"`
+	Email  string `json:"email,omitempty"`
+	UserID string `json:"userId,omitempty"`
+	Role   string `json:"role,omitempty"`
+	// Shared is true under the shared handshake token, which isn't tied to
+	// a user and may act for any of them.
+	Shared bool `json:"shared"`
+}
+
+// whoamiHandler reports the user the request's token was issued to.
+func whoamiHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	id := identityFrom(r.Context())
+	if id.Email == "" {
+		writeJSON(w, http.StatusOK, WhoamiResponse{Status: "ok", Shared: true})
+		return
+	}
+	writeJSON(w, http.StatusOK, WhoamiResponse{Status: "ok", Email: id.Email, UserID: extractUserId(id.Email), Role: id.Role, Shared: false})
+}
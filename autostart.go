@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+type AutostartRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Enabled    *bool  `json:"enabled"`
+}
+
+type AutostartResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// Enabled reports whether Docker brings the server back after a host
+	// reboot or daemon restart.
+	Enabled       bool   `json:"enabled"`
+	RestartPolicy string `json:"restartPolicy"`
+}
+
+// autostarts reports whether Docker starts a container with policy p again
+// when the daemon starts. on-failure only restarts containers that exited
+// with an error, so it doesn't count.
+func autostarts(p container.RestartPolicy) bool {
+	return p.Name == container.RestartPolicyAlways || p.Name == container.RestartPolicyUnlessStopped
+}
+
+func autostartResponse(message string, p container.RestartPolicy) AutostartResponse {
+	if p.Name == "" {
+		p.Name = container.RestartPolicyDisabled
+	}
+	return AutostartResponse{Status: "ok", Message: message, Enabled: autostarts(p), RestartPolicy: formatRestartPolicy(p)}
+}
+
+// autostartHandler shows (GET) or changes (POST) whether a server starts with
+// the host. Enabling switches the restart policy to unless-stopped, keeping
+// always if that was set; disabling switches it to no.
+func autostartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		containerId, ok := containerIdFromQuery(w, r)
+		if !ok {
+			return
+		}
+		info, ok := inspectServer(w, r, containerId)
+		if !ok {
+			return
+		}
+		writeJSON(w, http.StatusOK, autostartResponse("", info.HostConfig.RestartPolicy))
+		return
+	}
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req AutostartRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.Enabled == nil {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and enabled are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "autostart")
+	if !ok {
+		return
+	}
+	defer unlock()
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	current := info.HostConfig.RestartPolicy
+	if autostarts(current) == *req.Enabled {
+		msg := "Autostart is already disabled"
+		if *req.Enabled {
+			msg = "Autostart is already enabled"
+		}
+		writeJSON(w, http.StatusOK, autostartResponse(msg, current))
+		return
+	}
+	policy := container.RestartPolicy{Name: container.RestartPolicyDisabled}
+	msg := "Autostart disabled"
+	if *req.Enabled {
+		policy.Name = container.RestartPolicyUnlessStopped
+		msg = "Autostart enabled"
+	}
+	if _, err := dockerClient.ContainerUpdate(r.Context(), containerId, container.UpdateConfig{RestartPolicy: policy}); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update restart policy: "+err.Error())
+		return
+	}
+	if *req.Enabled {
+		crashes.reset(containerId)
+	}
+	writeJSON(w, http.StatusOK, autostartResponse(msg, policy))
+}
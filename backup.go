@@ -0,0 +1,446 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// BackupRequest asks for an archive of a server's volume.
+type BackupRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// SkipSave archives a running server without flushing the world first.
+	SkipSave bool `json:"skipSave,omitempty"`
+}
+
+type BackupResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	BackupInfo
+}
+
+// BackupInfo describes one archive in the backups directory.
+type BackupInfo struct {
+	ID      string    `json:"id"`
+	File    string    `json:"file"`
+	Size    int64     `json:"size"`
+	Created time.Time `json:"created"`
+	Label   string    `json:"label,omitempty"`
+}
+
+const backupExt = ".tar.gz"
+
+// backupTimeFormat sorts lexically in creation order.
+const backupTimeFormat = "20060102T150405Z"
+
+// backupRootDir is the absolute directory server archives are written to. It
+// is set once at startup by configureBackupRoot.
+var backupRootDir = mustAbs("backups")
+
+// getBackupsDir is where server archives are written, outside every volume.
+func getBackupsDir() string {
+	return backupRootDir
+}
+
+// configureBackupRoot reads BACKUP_ROOT (default ./backups) as an absolute
+// path and creates it. It must not be inside the volume root, where the file
+// endpoints of a server could reach other servers' archives.
+func configureBackupRoot() {
+	backupRootDir = mustAbs(envOr("BACKUP_ROOT", "backups"))
+	if withinDir(volumeRoot(), backupRootDir) {
+		fatal("BACKUP_ROOT must not be inside VOLUME_ROOT", "backupRoot", backupRootDir, "volumeRoot", volumeRoot())
+	}
+	if err := os.MkdirAll(backupRootDir, 0755); err != nil {
+		fatal("Failed to create backup root", "path", backupRootDir, "err", err)
+	}
+}
+
+// autoBackupEnabled reports whether destructive operations take a backup
+// first unless the request opts out. Controlled by AUTO_BACKUP (default on).
+func autoBackupEnabled() bool {
+	return envBool("AUTO_BACKUP", true)
+}
+
+// backupBeforeDestructive archives a server's volume ahead of an operation that
+// may lose data. It returns the backup ID, or "" if auto backups are disabled
+// or the caller asked to skip them.
+func backupBeforeDestructive(containerId, operation string, skip bool) (string, error) {
+	if skip || !autoBackupEnabled() {
+		return "", nil
+	}
+	info, err := createBackup(containerId, "pre-"+operation)
+	if err != nil {
+		return "", err
+	}
+	return info.ID, nil
+}
+
+// backupServerHandler archives a server's volume into the backups directory.
+// A running server is told to flush the world and stop autosaving while the
+// archive is written, so the backup is consistent without stopping it.
+func backupServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req BackupRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	if info, err := os.Stat(getServerDataDir(containerId)); err != nil || !info.IsDir() {
+		writeError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+	unlock, ok := lockServer(w, containerId, "backup")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	info, err := backupServer(containerId, "", req.SkipSave)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, BackupResponse{Status: "ok", Message: "Backup created", BackupInfo: info})
+}
+
+// backupServer archives the server's volume with createBackup. Unless
+// skipSave is set, a running server is told to flush the world and stop
+// autosaving while the archive is written. The caller holds the server lock.
+func backupServer(containerId, label string, skipSave bool) (BackupInfo, error) {
+	if !skipSave {
+		resume, err := pauseSaving(containerId, "Backup")
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		defer resume()
+	}
+	info, err := createBackup(containerId, label)
+	if err != nil {
+		return BackupInfo{}, errors.New("Failed to create backup: " + err.Error())
+	}
+	return info, nil
+}
+
+// pauseSaving tells a running server to stop autosaving and flush the world
+// to disk, so its files can be copied consistently, and returns the function
+// that turns autosave back on. op names the caller in the log. A stopped
+// server is left alone.
+func pauseSaving(containerId, op string) (func(), error) {
+	if !isRunning(containerId) {
+		return func() {}, nil
+	}
+	if out, err := runRcon(containerId, "save-off"); err != nil {
+		return nil, errors.New("Failed to pause autosave: " + out)
+	}
+	resume := func() {
+		if out, err := runRcon(containerId, "save-on"); err != nil {
+			slog.Warn(op+": failed to re-enable autosave", "server", containerId, "output", out)
+		}
+	}
+	if out, err := runRcon(containerId, "save-all flush"); err != nil {
+		resume()
+		return nil, errors.New("Failed to save world: " + out)
+	}
+	return resume, nil
+}
+
+// listBackups returns the server's backups, newest first. Label is what
+// follows the timestamp, e.g. "pre-restore" or "scheduled".
+func listBackups(containerId string) ([]BackupInfo, error) {
+	entries, err := os.ReadDir(getBackupsDir())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var backups []BackupInfo
+	for _, e := range entries {
+		if e.IsDir() || !backupBelongsTo(e.Name(), containerId) {
+			continue
+		}
+		fi, err := e.Info()
+		if err != nil {
+			continue
+		}
+		id := strings.TrimSuffix(e.Name(), backupExt)
+		rest := strings.TrimPrefix(id, containerId+"-")
+		created, _ := time.Parse(backupTimeFormat, rest[:len(backupTimeFormat)])
+		backups = append(backups, BackupInfo{
+			ID:      id,
+			File:    e.Name(),
+			Size:    fi.Size(),
+			Created: created,
+			Label:   strings.TrimPrefix(rest[len(backupTimeFormat):], "-"),
+		})
+	}
+	sort.Slice(backups, func(i, j int) bool { return backups[i].ID > backups[j].ID })
+	return backups, nil
+}
+
+// pruneBackups deletes the server's backups selected by match, except the
+// newest keep of them. With olderThan set, only backups created before it
+// are deleted. It returns the deleted backups and the bytes freed.
+func pruneBackups(containerId string, keep int, match func(BackupInfo) bool, olderThan time.Time) ([]BackupInfo, int64, error) {
+	backups, err := listBackups(containerId)
+	if err != nil {
+		return nil, 0, err
+	}
+	deleted := []BackupInfo{}
+	var freed int64
+	kept := 0
+	for _, bi := range backups {
+		if !match(bi) {
+			continue
+		}
+		if kept < keep {
+			kept++
+			continue
+		}
+		if !olderThan.IsZero() && !bi.Created.Before(olderThan) {
+			continue
+		}
+		if err := os.Remove(filepath.Join(getBackupsDir(), bi.File)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, freed, err
+		}
+		deleted = append(deleted, bi)
+		freed += bi.Size
+	}
+	return deleted, freed, nil
+}
+
+type PruneBackupsRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// Keep is how many of the newest backups are always kept.
+	Keep *int `json:"keep,omitempty"`
+	// MaxAgeDays deletes backups older than this many days.
+	MaxAgeDays int `json:"maxAgeDays,omitempty"`
+}
+
+type PruneBackupsResponse struct {
+	Status  string       `json:"status"`
+	Message string       `json:"message"`
+	Deleted []BackupInfo `json:"deleted"`
+	Freed   int64        `json:"freed"`
+}
+
+// pruneBackupsHandler deletes old backups of a server: all but the newest
+// keep, and with maxAgeDays only those older than that. Backups already gone
+// are not an error, so the same request can be repeated safely.
+func pruneBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req PruneBackupsRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Keep == nil && req.MaxAgeDays == 0 {
+		writeError(w, http.StatusBadRequest, "keep or maxAgeDays is required")
+		return
+	}
+	keep := 0
+	if req.Keep != nil {
+		keep = *req.Keep
+	}
+	if keep < 0 || req.MaxAgeDays < 0 {
+		writeError(w, http.StatusBadRequest, "keep and maxAgeDays must not be negative")
+		return
+	}
+	if keep == 0 && req.MaxAgeDays == 0 {
+		writeError(w, http.StatusBadRequest, "keep must be at least 1 unless maxAgeDays is set")
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "backup prune")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	var olderThan time.Time
+	if req.MaxAgeDays > 0 {
+		olderThan = time.Now().AddDate(0, 0, -req.MaxAgeDays)
+	}
+	deleted, freed, err := pruneBackups(containerId, keep, func(BackupInfo) bool { return true }, olderThan)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, fmt.Sprintf("Failed to delete backups (%d deleted so far): %v", len(deleted), err))
+		return
+	}
+	writeJSON(w, http.StatusOK, PruneBackupsResponse{
+		Status:  "ok",
+		Message: fmt.Sprintf("Deleted %d backups, freed %s", len(deleted), humanBytes(freed)),
+		Deleted: deleted,
+		Freed:   freed,
+	})
+}
+
+// backupDownloadHandler sends one of a server's backups, as named by
+// /server/backup, as an attachment. Ranges are supported so an interrupted
+// download of a large world can be resumed.
+func backupDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	file := r.URL.Query().Get("file")
+	if file == "" {
+		writeError(w, http.StatusBadRequest, "file is required")
+		return
+	}
+	if filepath.Base(file) != file || !backupBelongsTo(file, containerId) {
+		writeError(w, http.StatusBadRequest, "file must be one of this server's backups")
+		return
+	}
+	serveAttachment(w, r, filepath.Join(getBackupsDir(), file))
+}
+
+// createBackup writes the server's volume to
+// backups/<containerId>-<timestamp>[-<label>].tar.gz. The archive is streamed
+// to a temporary file and linked into place, so a failed backup never leaves
+// a truncated archive behind. IDs are to the second: when a backup with the
+// same label already has this one's, the next free second is taken instead,
+// so two quick backups never overwrite each other.
+func createBackup(containerId, label string) (BackupInfo, error) {
+	if err := os.MkdirAll(getBackupsDir(), 0755); err != nil {
+		return BackupInfo{}, err
+	}
+	tmp, err := os.CreateTemp(getBackupsDir(), ".tmp-"+containerId+"-*")
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := writeTarGz(tmp, getServerDataDir(containerId)); err != nil {
+		tmp.Close()
+		return BackupInfo{}, err
+	}
+	if err := tmp.Close(); err != nil {
+		return BackupInfo{}, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	for attempt := 0; ; attempt++ {
+		id := containerId + "-" + now.Format(backupTimeFormat)
+		if label != "" {
+			id += "-" + label
+		}
+		dest := filepath.Join(getBackupsDir(), id+backupExt)
+		// Unlike a rename, a link never replaces an existing archive.
+		err := os.Link(tmp.Name(), dest)
+		if errors.Is(err, os.ErrExist) && attempt < maxBackupIdAttempts {
+			now = now.Add(time.Second)
+			continue
+		}
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		st, err := os.Stat(dest)
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		return BackupInfo{ID: id, File: id + backupExt, Size: st.Size(), Created: now, Label: label}, nil
+	}
+}
+
+// maxBackupIdAttempts bounds how many seconds createBackup moves on looking
+// for a free backup ID.
+const maxBackupIdAttempts = 60
+
+// writeTarGz archives the regular files and directories under root with paths
+// relative to it. Symlinks and special files are skipped.
+func writeTarGz(w io.Writer, root string) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !d.IsDir() && !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, _, err := openRegular(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
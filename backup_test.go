@@ -0,0 +1,53 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateBackupSameSecond(t *testing.T) {
+	root := useVolumeRoot(t)
+	savedBackups := backupRootDir
+	backupRootDir = t.TempDir()
+	defer func() { backupRootDir = savedBackups }()
+	if err := os.MkdirAll(filepath.Join(root, "lobby--alice"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ids := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		if err := os.WriteFile(filepath.Join(root, "lobby--alice", "level.dat"), []byte{byte(i)}, 0644); err != nil {
+			t.Fatal(err)
+		}
+		for _, label := range []string{"", "pre-restore"} {
+			info, err := createBackup("lobby--alice", label)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ids[info.ID] {
+				t.Fatalf("backup ID %s handed out twice", info.ID)
+			}
+			ids[info.ID] = true
+			if !backupBelongsTo(info.File, "lobby--alice") {
+				t.Errorf("backup file %s doesn't belong to its server", info.File)
+			}
+		}
+	}
+
+	backups, err := listBackups("lobby--alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != len(ids) {
+		t.Errorf("%d backups on disk, want %d", len(backups), len(ids))
+	}
+	for _, b := range backups {
+		if !ids[b.ID] {
+			t.Errorf("unexpected backup %s", b.ID)
+		}
+	}
+	if entries, _ := filepath.Glob(filepath.Join(backupRootDir, ".tmp-*")); len(entries) != 0 {
+		t.Errorf("temporary files left behind: %v", entries)
+	}
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Bandwidth limits are stored as container labels so they survive agent
+// restarts and are re-applied every time the container starts.
+const (
+	bandwidthIngressLabel = "mcnode.bandwidth.ingress"
+	bandwidthEgressLabel  = "mcnode.bandwidth.egress"
+)
+
+// BandwidthStatus reports the configured limits and whether they were applied
+// the last time the container started while this agent was watching.
+type BandwidthStatus struct {
+	Ingress string `json:"ingress,omitempty"`
+	Egress  string `json:"egress,omitempty"`
+	Applied bool   `json:"applied"`
+	Error   string `json:"error,omitempty"`
+}
+
+var bandwidthPattern = regexp.MustCompile(`^([0-9]+(?:\.[0-9]+)?)(kbit|mbit|gbit)$`)
+
+var bandwidthUnits = map[string]float64{"kbit": 1e3, "mbit": 1e6, "gbit": 1e9}
+
+var (
+	bandwidthMu sync.Mutex
+	// bandwidthResults holds the outcome of the last apply per container: ""
+	// on success, otherwise the error.
+	bandwidthResults = map[string]string{}
+)
+
+// parseBandwidth validates a tc rate such as "10mbit" and returns it in bits
+// per second.
+func parseBandwidth(s string) (float64, error) {
+	m := bandwidthPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(s)))
+	if m == nil {
+		return 0, fmt.Errorf("invalid bandwidth %q: use a rate like 500kbit, 10mbit or 1gbit", s)
+	}
+	n, _ := strconv.ParseFloat(m[1], 64)
+	if n <= 0 {
+		return 0, fmt.Errorf("invalid bandwidth %q: must be positive", s)
+	}
+	return n * bandwidthUnits[m[2]], nil
+}
+
+// tcBurst sizes the token bucket at 100ms worth of traffic, with a floor that
+// keeps low rates from stalling on full-size packets.
+func tcBurst(bps float64) string {
+	burst := int64(bps / 8 / 10)
+	if burst < 16*1024 {
+		burst = 16 * 1024
+	}
+	return strconv.FormatInt(burst, 10)
+}
+
+// applyBandwidthLimits shapes the container's eth0 with tc inside its network
+// namespace: a token bucket for egress and a policer for ingress. This is best
+// effort and needs the agent to run as root with nsenter and tc available;
+// failures are logged and reported through the status endpoint.
+func applyBandwidthLimits(containerId, ingress, egress string) {
+	if containerId == "" || (ingress == "" && egress == "") {
+		return
+	}
+	err := shapeContainer(containerId, ingress, egress)
+
+	bandwidthMu.Lock()
+	defer bandwidthMu.Unlock()
+	if err != nil {
+		slog.Error("Failed to apply bandwidth limits", "server", containerId, "err", err)
+		bandwidthResults[containerId] = err.Error()
+		return
+	}
+	bandwidthResults[containerId] = ""
+}
+
+func shapeContainer(containerId, ingress, egress string) error {
+	info, err := inspectContainer(context.Background(), containerId)
+	if err != nil || info.State.Pid == 0 {
+		return fmt.Errorf("container is not running")
+	}
+	pid := strconv.Itoa(info.State.Pid)
+	tc := func(args ...string) error {
+		full := append([]string{"-t", pid, "-n", "tc"}, args...)
+		out, err := exec.Command("nsenter", full...).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("tc %s: %s", strings.Join(args, " "), strings.TrimSpace(string(out)))
+		}
+		return nil
+	}
+
+	if egress != "" {
+		bps, err := parseBandwidth(egress)
+		if err != nil {
+			return err
+		}
+		if err := tc("qdisc", "replace", "dev", "eth0", "root", "tbf",
+			"rate", egress, "burst", tcBurst(bps), "latency", "50ms"); err != nil {
+			return err
+		}
+	}
+	if ingress != "" {
+		bps, err := parseBandwidth(ingress)
+		if err != nil {
+			return err
+		}
+		if err := tc("qdisc", "replace", "dev", "eth0", "handle", "ffff:", "ingress"); err != nil {
+			return err
+		}
+		if err := tc("filter", "replace", "dev", "eth0", "parent", "ffff:", "protocol", "all",
+			"prio", "1", "u32", "match", "u32", "0", "0",
+			"police", "rate", ingress, "burst", tcBurst(bps), "drop"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// bandwidthStatus returns the limits configured through the container labels
+// and whether they are in effect.
+func bandwidthStatus(containerId, ingress, egress string) *BandwidthStatus {
+	if ingress == "" && egress == "" {
+		return nil
+	}
+	bandwidthMu.Lock()
+	defer bandwidthMu.Unlock()
+	errMsg, attempted := bandwidthResults[containerId]
+	return &BandwidthStatus{Ingress: ingress, Egress: egress, Applied: attempted && errMsg == "", Error: errMsg}
+}
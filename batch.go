@@ -0,0 +1,160 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// maxBatchOperations caps the operations in one /file/batch request.
+const maxBatchOperations = 500
+
+// BatchOperation is one step of a /file/batch request. Path is the file or
+// directory acted on; To is the destination of a rename or copy.
+type BatchOperation struct {
+	Op        string `json:"op"`
+	Path      string `json:"path"`
+	To        string `json:"to,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+type BatchFileRequest struct {
+	ServerName string           `json:"serverName"`
+	UserEmail  string           `json:"userEmail"`
+	Operations []BatchOperation `json:"operations"`
+	// ContinueOnError runs the remaining operations after one fails instead
+	// of stopping there.
+	ContinueOnError bool `json:"continueOnError,omitempty"`
+}
+
+type BatchOperationResult struct {
+	Index   int    `json:"index"`
+	Op      string `json:"op"`
+	Path    string `json:"path"`
+	Success bool   `json:"success"`
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+	// Code is the status the single-file endpoint would have answered with.
+	Code int `json:"code,omitempty"`
+}
+
+type BatchFileResponse struct {
+	Status    string                 `json:"status"`
+	Succeeded int                    `json:"succeeded"`
+	Failed    int                    `json:"failed"`
+	Skipped   int                    `json:"skipped"`
+	Results   []BatchOperationResult `json:"results"`
+}
+
+// validateBatch checks every operation's kind and paths before anything runs,
+// so a bad path anywhere in the batch changes nothing.
+func validateBatch(containerId string, ops []BatchOperation) error {
+	if len(ops) == 0 {
+		return errors.New("operations is required")
+	}
+	if len(ops) > maxBatchOperations {
+		return fmt.Errorf("at most %d operations are allowed per batch", maxBatchOperations)
+	}
+	for i, op := range ops {
+		if op.Path == "" {
+			return fmt.Errorf("operation %d: path is required", i)
+		}
+		if _, err := resolveServerPath(containerId, op.Path); err != nil {
+			return fmt.Errorf("operation %d: invalid path: %v", i, err)
+		}
+		switch op.Op {
+		case "delete", "mkdir":
+		case "rename", "copy":
+			if op.To == "" {
+				return fmt.Errorf("operation %d: to is required for %s", i, op.Op)
+			}
+			if _, err := resolveServerPath(containerId, op.To); err != nil {
+				return fmt.Errorf("operation %d: invalid to path: %v", i, err)
+			}
+		default:
+			return fmt.Errorf("operation %d: unknown op %q: use delete, mkdir, rename or copy", i, op.Op)
+		}
+	}
+	return nil
+}
+
+// runBatchOperation performs one operation and describes what it did.
+func runBatchOperation(containerId string, op BatchOperation) (string, error) {
+	switch op.Op {
+	case "delete":
+		removed, err := deletePath(containerId, op.Path, op.Recursive)
+		return fmt.Sprintf("Deleted %s (%d entries removed)", op.Path, removed), err
+	case "mkdir":
+		return "Created " + op.Path, makeDir(containerId, op.Path)
+	case "rename":
+		return "Renamed " + op.Path + " to " + op.To, renamePath(containerId, op.Path, op.To, op.Overwrite)
+	default:
+		copied, err := copyPath(containerId, op.Path, op.To, op.Overwrite)
+		return fmt.Sprintf("Copied %s to %s (%d files)", op.Path, op.To, copied), err
+	}
+}
+
+// batchFileHandler runs several delete, mkdir, rename and copy operations on
+// a server's volume in order, under one server lock, and reports each one's
+// outcome. It stops at the first failure unless continueOnError is set; the
+// operations after it are counted as skipped.
+func batchFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req BatchFileRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	if err := validateBatch(containerId, req.Operations); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	unlock, ok := lockServer(w, containerId, "file batch")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	resp := BatchFileResponse{Status: "ok", Results: []BatchOperationResult{}}
+	for i, op := range req.Operations {
+		result := BatchOperationResult{Index: i, Op: op.Op, Path: op.Path}
+		msg, err := runBatchOperation(containerId, op)
+		if err == nil {
+			result.Success = true
+			result.Message = msg
+			resp.Succeeded++
+			resp.Results = append(resp.Results, result)
+			continue
+		}
+		result.Error = err.Error()
+		result.Code = http.StatusInternalServerError
+		var opErr *fileOpError
+		if errors.As(err, &opErr) {
+			result.Code = opErr.code
+		}
+		resp.Failed++
+		resp.Results = append(resp.Results, result)
+		if !req.ContinueOnError {
+			resp.Skipped = len(req.Operations) - i - 1
+			break
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+)
+
+type CloneServerRequest struct {
+	SourceServerName string `json:"sourceServerName"`
+	TargetServerName string `json:"targetServerName"`
+	UserEmail        string `json:"userEmail"`
+	// ExcludeWorlds leaves the world directories out of the copy, so the
+	// clone generates a fresh world on its first start.
+	ExcludeWorlds bool `json:"excludeWorlds,omitempty"`
+}
+
+// worldDirs returns the directories holding the server's worlds: level-name
+// from server.properties, "world" by default, and its nether and end.
+func worldDirs(containerId string) []string {
+	level := "world"
+	if lines, err := readServerProperties(containerId); err == nil {
+		if name := propertiesMap(lines)["level-name"]; name != "" && name == filepath.Base(name) && name != ".." {
+			level = name
+		}
+	}
+	return []string{level, level + "_nether", level + "_the_end"}
+}
+
+// cloneVolume copies the volume of the server sourceId to dst, leaving out
+// the top-level entries in skip. The copy is made in a staging directory next
+// to dst and renamed into place, so a failed clone leaves no half-filled
+// volume behind.
+func cloneVolume(sourceId, dst string, skip map[string]bool) error {
+	src := getServerDataDir(sourceId)
+	tmp, err := makeStagingDir(filepath.Dir(dst))
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmp)
+	staging := filepath.Join(tmp, "data")
+	if err := copyVolumeEntries(src, staging, skip); err != nil {
+		return err
+	}
+	// The source may be running, and its locks would stop the clone from
+	// loading the world.
+	removeSessionLocks(staging)
+	return os.Rename(staging, dst)
+}
+
+// copyVolumeEntries snapshots src to dst, entry by entry when some entries
+// have to be left out.
+func copyVolumeEntries(src, dst string, skip map[string]bool) error {
+	if len(skip) == 0 {
+		return snapshotDir(src, dst)
+	}
+	entries, err := os.ReadDir(src)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dst, 0755); err != nil {
+		return err
+	}
+	for _, e := range entries {
+		if skip[e.Name()] {
+			continue
+		}
+		if err := snapshotDir(filepath.Join(src, e.Name()), filepath.Join(dst, e.Name())); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// cloneServerHandler creates a server with the software, version, resources
+// and env of an existing one, read from its container, and a copy of its
+// volume, e.g. for a staging copy of a live server. The clone gets its own
+// port. Like a create it answers 202 with a job to poll on
+// /server/create/status; the job copies the volume first, with the source's
+// autosave paused if it is running, then pulls and creates as usual. The
+// clone is left stopped.
+func cloneServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req CloneServerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.SourceServerName == "" || req.TargetServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "sourceServerName, targetServerName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.SourceServerName); err != nil {
+		writeError(w, http.StatusBadRequest, "sourceServerName: "+err.Error())
+		return
+	}
+	if err := validateServerName(req.TargetServerName); err != nil {
+		writeError(w, http.StatusBadRequest, "targetServerName: "+err.Error())
+		return
+	}
+	if req.SourceServerName == req.TargetServerName {
+		writeError(w, http.StatusBadRequest, "sourceServerName and targetServerName must differ")
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	sourceId := buildContainerId(req.SourceServerName, req.UserEmail)
+	unlockSource, ok := lockServer(w, sourceId, "clone")
+	if !ok {
+		return
+	}
+	info, ok := inspectServer(w, r, sourceId)
+	if !ok {
+		unlockSource()
+		return
+	}
+	plan, err := planCreate(createRequestFrom(RecreateServerRequest{ServerName: req.TargetServerName, UserEmail: req.UserEmail}, info))
+	if err != nil {
+		unlockSource()
+		writeError(w, http.StatusBadRequest, "Source server can't be cloned: "+err.Error())
+		return
+	}
+	var skip map[string]bool
+	if req.ExcludeWorlds {
+		skip = map[string]bool{}
+		for _, dir := range worldDirs(sourceId) {
+			skip[dir] = true
+		}
+	}
+
+	unlockTarget, ok := lockServer(w, plan.ContainerID, "clone")
+	if !ok {
+		unlockSource()
+		return
+	}
+	// A volume left behind by a deleted server would be merged with the copy.
+	if _, err := os.Lstat(plan.DataDir); !errors.Is(err, os.ErrNotExist) {
+		unlockTarget()
+		unlockSource()
+		writeError(w, http.StatusConflict, "A volume for "+req.TargetServerName+" already exists")
+		return
+	}
+	port, ok := reserveCreate(w, r, plan, req.UserEmail)
+	if !ok {
+		unlockTarget()
+		unlockSource()
+		return
+	}
+	plan.publishPort(port)
+	job := createJobs.add(plan.ContainerID)
+	quotaMu.Unlock()
+	go func() {
+		defer unlockTarget()
+		defer ports.release(port)
+		createJobs.setState(job.ID, jobCopying)
+		resume, err := pauseSaving(sourceId, "Clone")
+		if err != nil {
+			unlockSource()
+			createJobs.fail(job.ID, err.Error())
+			return
+		}
+		err = cloneVolume(sourceId, plan.DataDir, skip)
+		resume()
+		unlockSource()
+		if err != nil {
+			createJobs.fail(job.ID, "Failed to copy the source volume: "+err.Error())
+			return
+		}
+		runCreateJob(job.ID, plan)
+	}()
+
+	writeJSON(w, http.StatusAccepted, CreateServerResponse{
+		Status:        "ok",
+		Message:       "Cloning " + req.SourceServerName + " into a server with " + plan.Summary,
+		ServerID:      plan.ContainerID,
+		JobID:         job.ID,
+		Port:          port,
+		RestartPolicy: formatRestartPolicy(plan.HostConfig.RestartPolicy),
+	})
+}
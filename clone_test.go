@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCloneVolumeLeavesSiblingVolumes(t *testing.T) {
+	root := useVolumeRoot(t)
+	for dir, content := range map[string]string{
+		"lobby--alice/level.dat":          "lobby",
+		"lobby--alice/world/session.lock": "lock",
+		"copy--alice.cloning/level.dat":   "alice.cloning's world",
+	} {
+		path := filepath.Join(root, dir)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := cloneVolume("lobby--alice", filepath.Join(root, "copy--alice"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := os.ReadFile(filepath.Join(root, "copy--alice", "level.dat")); err != nil || string(got) != "lobby" {
+		t.Errorf("clone level.dat = %q, %v; want %q", got, err, "lobby")
+	}
+	if _, err := os.Stat(filepath.Join(root, "copy--alice", "world", "session.lock")); !os.IsNotExist(err) {
+		t.Errorf("clone kept the source's session.lock: %v", err)
+	}
+	if got, err := os.ReadFile(filepath.Join(root, "copy--alice.cloning", "level.dat")); err != nil || string(got) != "alice.cloning's world" {
+		t.Errorf("copy--alice.cloning/level.dat = %q, %v; want it untouched", got, err)
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, stagingDirName)); len(entries) != 0 {
+		t.Errorf("staging directory left behind %d entries", len(entries))
+	}
+}
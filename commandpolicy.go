@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// commandRule limits the console commands of one role. With Allow set only
+// those commands may run; Deny is applied after it. Both name commands by
+// their first word, e.g. "op" or "whitelist".
+type commandRule struct {
+	Allow []string `json:"allow,omitempty"`
+	Deny  []string `json:"deny,omitempty"`
+
+	allow, deny map[string]bool
+}
+
+// defaultCommandRole is the rule for user tokens without a role claim, or
+// with a role the policy doesn't list.
+const defaultCommandRole = "default"
+
+// commandPolicy maps roles to their rules. It is nil unless
+// COMMAND_POLICY_FILE is set, and never applies to the shared handshake
+// token, which acts for the panel.
+var commandPolicy map[string]*commandRule
+
+// configureCommandPolicy loads COMMAND_POLICY_FILE, a JSON object of role
+// names to {"allow": [...], "deny": [...]}, e.g.
+//
+//	{"default": {"deny": ["op", "deop", "stop"]}, "moderator": {"allow": ["kick", "ban", "say", "list"]}}
+func configureCommandPolicy() {
+	file := os.Getenv("COMMAND_POLICY_FILE")
+	if file == "" {
+		return
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fatal("Failed to read COMMAND_POLICY_FILE", "err", err)
+	}
+	var policy map[string]*commandRule
+	if err := json.Unmarshal(data, &policy); err != nil {
+		fatal("Invalid COMMAND_POLICY_FILE", "err", err)
+	}
+	for role, rule := range policy {
+		if rule == nil {
+			fatal("Invalid COMMAND_POLICY_FILE: role has no rule", "role", role)
+		}
+		rule.allow = commandSet(rule.Allow)
+		rule.deny = commandSet(rule.Deny)
+	}
+	commandPolicy = policy
+}
+
+func commandSet(names []string) map[string]bool {
+	if len(names) == 0 {
+		return nil
+	}
+	set := make(map[string]bool, len(names))
+	for _, n := range names {
+		set[commandName(n)] = true
+	}
+	return set
+}
+
+// commandName is the lower-cased first word of a console command, without a
+// leading slash or namespace, so "/minecraft:OP alice" is "op".
+func commandName(command string) string {
+	name, _, _ := strings.Cut(strings.TrimSpace(command), " ")
+	name = strings.TrimPrefix(name, "/")
+	if i := strings.LastIndexByte(name, ':'); i >= 0 {
+		name = name[i+1:]
+	}
+	return strings.ToLower(name)
+}
+
+// checkCommand returns an error when the policy doesn't let id run command.
+func checkCommand(id Identity, command string) error {
+	if commandPolicy == nil || id.Email == "" {
+		return nil
+	}
+	role := id.Role
+	rule := commandPolicy[role]
+	if rule == nil {
+		role = defaultCommandRole
+		rule = commandPolicy[role]
+	}
+	if rule == nil {
+		return nil
+	}
+	name := commandName(command)
+	if (rule.allow != nil && !rule.allow[name]) || rule.deny[name] {
+		return fmt.Errorf("command %s is not allowed for role %s", strconv.Quote(name), role)
+	}
+	return nil
+}
+
+// allowCommand checks command against the policy for endpoints that run it
+// on the caller's behalf, such as /server/op, and answers 403, auditing the
+// rejection, when it isn't allowed.
+func allowCommand(w http.ResponseWriter, r *http.Request, via, serverName, userEmail, command string) bool {
+	if err := checkCommand(identityFrom(r.Context()), command); err != nil {
+		auditCommand(r, via, serverName, userEmail, command, auditRejected, err.Error())
+		writeError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		return false
+	}
+	return true
+}
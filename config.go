@@ -0,0 +1,90 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/joho/godotenv"
+)
+
+// loadDotEnv merges .env, if there is one, into the process environment. It
+// runs before anything else reads settings, logging included.
+func loadDotEnv() bool {
+	return godotenv.Load() == nil
+}
+
+// envOr returns the environment variable key, or def when it is unset or empty.
+func envOr(key, def string) string {
+	if v := os.Getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+func envInt(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return n
+}
+
+func envFloat(key string, def float64) float64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return f
+}
+
+func envDuration(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return d
+}
+
+func envBool(key string, def bool) bool {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", def)
+		return def
+	}
+	return b
+}
+
+// envSize reads a size in parseMemory's format, e.g. 100M or 2G.
+func envSize(key string, def int64) int64 {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := parseMemory(v)
+	if err != nil {
+		slog.Warn("Invalid setting, using default", "key", key, "value", v, "default", formatMemory(def))
+		return def
+	}
+	return n
+}
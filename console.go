@@ -0,0 +1,190 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ConsoleRequest is one console command for a server.
+type ConsoleRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Command    string `json:"command"`
+}
+
+type CommandResponse struct {
+	Status string `json:"status"`
+	Output string `json:"output"`
+}
+
+// commandHandler runs a single RCON command and returns its output, for
+// automation that doesn't want to hold a console WebSocket open.
+func commandHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req ConsoleRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	req.Command = strings.TrimSpace(req.Command)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.Command == "" {
+		writeError(w, http.StatusBadRequest, "command is required")
+		return
+	}
+	if err := checkCommand(identityFrom(r.Context()), req.Command); err != nil {
+		auditCommand(r, "command", req.ServerName, req.UserEmail, req.Command, auditRejected, err.Error())
+		writeError(w, http.StatusForbidden, "Forbidden: "+err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	if !info.State.Running {
+		writeError(w, http.StatusConflict, "Server is not running")
+		return
+	}
+
+	out, err := runRcon(containerId, req.Command)
+	if err != nil {
+		auditCommand(r, "command", req.ServerName, req.UserEmail, req.Command, auditFailed, out)
+		writeError(w, http.StatusInternalServerError, "Command failed: "+out)
+		return
+	}
+	auditCommand(r, "command", req.ServerName, req.UserEmail, req.Command, auditOK, out)
+	writeJSON(w, http.StatusOK, CommandResponse{Status: "ok", Output: out})
+}
+
+// ConsoleMessage is sent over /ws/console. Type is "log" for a console line,
+// "command" for a command any client of the server sent, "result" for the
+// output of a command sent by this client, or "error".
+type ConsoleMessage struct {
+	Type    string `json:"type"`
+	Line    string `json:"line,omitempty"`
+	Command string `json:"command,omitempty"`
+	Output  string `json:"output,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// consoleAction is an inbound console message: {"action":"command","command":"say hi"}.
+type consoleAction struct {
+	Action  string `json:"action"`
+	Command string `json:"command"`
+}
+
+// consoleHandler relays every line of the server's output as it is printed,
+// exactly as an operator sees it in a terminal, while commands from the
+// client run over RCON. The backlog, the last tail lines (default 200), comes
+// first, so a crash that already happened can be read. All clients of a
+// server share one consoleHub: one docker logs --follow feeds them, and every
+// command sent is echoed to all of them. Closing the socket never affects the
+// server.
+func consoleHandler(w http.ResponseWriter, r *http.Request) {
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	tail, ok := logTailFromQuery(w, r)
+	if !ok {
+		return
+	}
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	if !info.State.Running {
+		writeError(w, http.StatusConflict, "Server is not running")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer trackSession(conn)()
+
+	var writeMu sync.Mutex
+	send := func(msg ConsoleMessage) error {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(msg)
+	}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	startHeartbeat(conn, ctx.Done())
+	hub, sub := joinConsole(containerId, tail)
+	relayed := make(chan struct{})
+	go func() {
+		defer close(relayed)
+		for msg := range sub.out {
+			send(msg)
+		}
+		if sub.reason != "" {
+			// The server stopped, or this client fell behind; tell it and
+			// end the session.
+			send(ConsoleMessage{Type: "error", Message: sub.reason})
+			conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, "detached"), time.Now().Add(time.Second))
+			conn.Close()
+		}
+	}()
+
+	serverName := r.URL.Query().Get("serverName")
+	userEmail := ownerEmail(r, r.URL.Query().Get("userEmail"))
+	for {
+		var in consoleAction
+		if err := conn.ReadJSON(&in); err != nil {
+			break
+		}
+		if in.Action != "command" {
+			send(ConsoleMessage{Type: "error", Message: "Unknown action " + strconv.Quote(in.Action)})
+			continue
+		}
+		command := strings.TrimSpace(in.Command)
+		if command == "" {
+			send(ConsoleMessage{Type: "error", Message: "command is required"})
+			continue
+		}
+		if err := checkCommand(identityFrom(r.Context()), command); err != nil {
+			auditCommand(r, "console", serverName, userEmail, command, auditRejected, err.Error())
+			send(ConsoleMessage{Type: "error", Command: command, Message: "Forbidden: " + err.Error()})
+			continue
+		}
+		hub.publish(ConsoleMessage{Type: "command", Command: command})
+		out, err := runRcon(containerId, command)
+		if err != nil {
+			auditCommand(r, "console", serverName, userEmail, command, auditFailed, out)
+			send(ConsoleMessage{Type: "error", Command: command, Message: "Command failed: " + out})
+			continue
+		}
+		auditCommand(r, "console", serverName, userEmail, command, auditOK, out)
+		send(ConsoleMessage{Type: "result", Command: command, Output: out})
+	}
+	cancel()
+	hub.leave(sub)
+	<-relayed
+}
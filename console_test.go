@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/websocket"
+)
+
+// fakeDockerCLI puts a docker command on PATH. As docker logs it records its
+// PID in the returned file, one line per process, prints a console line and
+// then runs until it is killed, as docker logs --follow does. Anything else,
+// such as an RCON command, prints its arguments and exits.
+func fakeDockerCLI(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	pidFile := filepath.Join(dir, "pid")
+	script := "#!/bin/sh\nif [ \"$1\" != logs ]; then echo \"$*\"; exit 0; fi\necho $$ >> \"$FAKE_DOCKER_PID\"\necho 'Done (1.0s)! For help, type \"help\"'\nexec sleep 600\n"
+	if err := os.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	t.Setenv("FAKE_DOCKER_PID", pidFile)
+	return pidFile
+}
+
+func TestConsoleReapsFollowerOnDisconnect(t *testing.T) {
+	const containerId = "lobby--alice"
+	fakeDocker(t, map[string]types.ContainerJSON{containerId: runningContainer(nil)})
+	pidFile := fakeDockerCLI(t)
+	srv := httptest.NewServer(http.HandlerFunc(consoleHandler))
+	defer srv.Close()
+
+	url := "ws" + strings.TrimPrefix(srv.URL, "http") + "/ws/console?serverName=lobby&userEmail=alice@example.com"
+	conn, _, err := websocket.DefaultDialer.Dial(url, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var msg ConsoleMessage
+	if err := conn.ReadJSON(&msg); err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != "log" || !strings.HasPrefix(msg.Line, "Done") {
+		t.Fatalf("first message %+v, want the console line", msg)
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn.Close()
+	// Signal 0 still finds a zombie, so this only passes once the follower
+	// has been killed and waited for.
+	deadline := time.Now().Add(10 * time.Second)
+	for !errors.Is(syscall.Kill(pid, 0), syscall.ESRCH) {
+		if time.Now().After(deadline) {
+			syscall.Kill(pid, syscall.SIGKILL)
+			t.Fatalf("docker logs process %d is still there after the client disconnected", pid)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	consoleHubsMu.Lock()
+	_, open := consoleHubs[containerId]
+	consoleHubsMu.Unlock()
+	if open {
+		t.Error("console hub still open after the last client left")
+	}
+}
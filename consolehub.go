@@ -0,0 +1,142 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strconv"
+	"sync"
+)
+
+// consoleSubBuffer is how many messages may wait for a console client. It
+// holds a full backlog; a client that falls this far behind is dropped rather
+// than slowing down everyone else.
+const consoleSubBuffer = maxLogTail + 256
+
+// consoleHub is the one log follower of a server's console, shared by all
+// /ws/console and /ws/dashboard clients of that server. It keeps the last maxLogTail lines so
+// clients that join later get a backlog from it.
+type consoleHub struct {
+	containerId string
+	cancel      context.CancelFunc
+
+	mu      sync.Mutex
+	subs    map[*consoleSub]struct{}
+	backlog []string
+	ended   bool
+}
+
+// consoleSub is one client of a hub. out is closed when the client leaves or
+// is dropped; reason then says why, if it wasn't the client's own doing.
+type consoleSub struct {
+	out    chan ConsoleMessage
+	reason string
+}
+
+var (
+	consoleHubsMu sync.Mutex
+	consoleHubs   = map[string]*consoleHub{}
+)
+
+// joinConsole subscribes to the server's console hub, starting its follower
+// when this is the first client. A new follower begins with the last tail
+// lines; a client joining a running hub gets up to tail lines of its backlog.
+func joinConsole(containerId string, tail int) (*consoleHub, *consoleSub) {
+	consoleHubsMu.Lock()
+	defer consoleHubsMu.Unlock()
+	h := consoleHubs[containerId]
+	if h == nil {
+		ctx, cancel := context.WithCancel(context.Background())
+		h = &consoleHub{containerId: containerId, cancel: cancel, subs: map[*consoleSub]struct{}{}}
+		consoleHubs[containerId] = h
+		go h.follow(ctx, tail)
+	}
+	sub := &consoleSub{out: make(chan ConsoleMessage, consoleSubBuffer)}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, line := range h.backlog[max(0, len(h.backlog)-tail):] {
+		sub.out <- ConsoleMessage{Type: "log", Line: line}
+	}
+	h.subs[sub] = struct{}{}
+	return h, sub
+}
+
+// leave unsubscribes sub and stops the follower once no client is left.
+func (h *consoleHub) leave(sub *consoleSub) {
+	consoleHubsMu.Lock()
+	defer consoleHubsMu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.drop(sub, "")
+	if len(h.subs) == 0 && !h.ended {
+		h.ended = true
+		delete(consoleHubs, h.containerId)
+		h.cancel()
+	}
+}
+
+// drop removes sub and closes its channel. h.mu must be held.
+func (h *consoleHub) drop(sub *consoleSub, reason string) {
+	if _, ok := h.subs[sub]; ok {
+		delete(h.subs, sub)
+		sub.reason = reason
+		close(sub.out)
+	}
+}
+
+// publish sends msg to every client, recording log lines in the backlog.
+func (h *consoleHub) publish(msg ConsoleMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if msg.Type == "log" {
+		h.backlog = append(h.backlog, msg.Line)
+		if len(h.backlog) >= 2*maxLogTail {
+			h.backlog = append([]string(nil), h.backlog[len(h.backlog)-maxLogTail:]...)
+		}
+	}
+	for sub := range h.subs {
+		select {
+		case sub.out <- msg:
+		default:
+			h.drop(sub, "Console detached: client is not keeping up with the output")
+		}
+	}
+}
+
+// publishConsole sends msg to the clients of the server's console hub, if it
+// has one.
+func publishConsole(containerId string, msg ConsoleMessage) {
+	consoleHubsMu.Lock()
+	h := consoleHubs[containerId]
+	consoleHubsMu.Unlock()
+	if h != nil {
+		h.publish(msg)
+	}
+}
+
+// follow streams the server's output into the hub until the server stops or
+// the last client leaves. When the server stops, every client is detached.
+func (h *consoleHub) follow(ctx context.Context, tail int) {
+	err := streamDockerContext(ctx, func(line string) {
+		h.publish(ConsoleMessage{Type: "log", Line: line})
+	}, "logs", "--follow", "--tail", strconv.Itoa(tail), h.containerId)
+	if ctx.Err() != nil {
+		return
+	}
+	reason := "Console detached: server stopped"
+	if err != nil && isRunning(h.containerId) {
+		reason = "Console detached: " + err.Error()
+		slog.Warn("Console log follow failed", "server", h.containerId, "err", err)
+	}
+	consoleHubsMu.Lock()
+	defer consoleHubsMu.Unlock()
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.ended = true
+	h.cancel()
+	if consoleHubs[h.containerId] == h {
+		delete(consoleHubs, h.containerId)
+	}
+	for sub := range h.subs {
+		h.drop(sub, reason)
+	}
+}
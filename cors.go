@@ -0,0 +1,62 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// corsAllowedHeaders are the request headers browsers may send cross-origin.
+var corsAllowedHeaders = strings.Join([]string{
+	"Authorization", "Content-Type", "Idempotency-Key", "If-Match", "Range", "X-Request-Id",
+	"X-Upload-Id", "X-Chunk-Index", "X-Total-Chunks",
+}, ", ")
+
+// corsExposedHeaders are the response headers cross-origin scripts may read.
+var corsExposedHeaders = strings.Join([]string{
+	"X-Request-Id", "Retry-After", "ETag", "Content-Disposition", "Content-Length", "Content-Range",
+	"Accept-Ranges", "Idempotent-Replayed",
+}, ", ")
+
+// corsOriginAllowed reports whether a page on origin may call the API. The
+// list is the one for WebSockets, ALLOWED_ORIGINS; when it is unset no other
+// origin is allowed, and same-origin pages need no CORS.
+func corsOriginAllowed(origin string) bool {
+	return origin != "" && (allowAnyOrigin || allowedOrigins[normalizeOrigin(origin)])
+}
+
+// handleCORS lets the browser dashboard call the API from the origins in
+// ALLOWED_ORIGINS. The allowed origin is echoed back, with Vary: Origin,
+// rather than "*", which browsers refuse for credentialed requests and which
+// caches would serve to every origin. Preflight requests carry no token, so
+// they are answered here, before authentication and rate limiting.
+func handleCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		allowed := corsOriginAllowed(origin)
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			if !allowed {
+				requestLogger(r).Warn("CORS preflight from disallowed origin", "origin", origin)
+				writeError(w, http.StatusForbidden, "Origin not allowed")
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Methods", "GET, HEAD, POST, PUT, DELETE")
+			w.Header().Set("Access-Control-Allow-Headers", corsAllowedHeaders)
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		if allowed {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Expose-Headers", corsExposedHeaders)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// managedLabel marks containers created by this agent so the crash monitor
+// ignores anything else running on the host.
+const managedLabel = "mcnode.managed"
+
+// crashLogLines is how much of the container log is attached to a crash report.
+const crashLogLines = 30
+
+// CrashEvent is recorded for every abnormal container exit and posted to
+// CRASH_WEBHOOK_URL when configured.
+type CrashEvent struct {
+	Event     string    `json:"event"`
+	ServerID  string    `json:"serverId"`
+	ExitCode  int       `json:"exitCode"`
+	OOMKilled bool      `json:"oomKilled"`
+	Time      time.Time `json:"time"`
+	LastLogs  []string  `json:"lastLogs,omitempty"`
+	Crashes   int       `json:"crashesInWindow"`
+}
+
+type CrashListResponse struct {
+	Status    string       `json:"status"`
+	CrashLoop bool         `json:"crashLoop"`
+	Crashes   []CrashEvent `json:"crashes"`
+}
+
+type crashHistory struct {
+	events       []CrashEvent
+	loopDetected bool
+}
+
+type crashMonitor struct {
+	webhookURL string
+	threshold  int
+	window     time.Duration
+	client     *http.Client
+
+	mu      sync.Mutex
+	servers map[string]*crashHistory
+	// killed holds when /server/kill sent SIGKILL to a container, whose exit
+	// is then not a crash.
+	killed map[string]time.Time
+}
+
+var crashes = &crashMonitor{servers: map[string]*crashHistory{}, killed: map[string]time.Time{}}
+
+// configureCrashMonitor reads the crash webhook and crash-loop settings.
+func configureCrashMonitor() {
+	crashes.webhookURL = envOr("CRASH_WEBHOOK_URL", "")
+	crashes.threshold = envInt("CRASH_LOOP_THRESHOLD", 5)
+	crashes.window = envDuration("CRASH_LOOP_WINDOW", 10*time.Minute)
+	crashes.client = &http.Client{Timeout: 10 * time.Second}
+}
+
+// isCleanExit reports whether an exit code is a normal shutdown: success, or
+// the SIGINT/SIGTERM sent by `docker stop`.
+func isCleanExit(code int) bool {
+	return code == 0 || code == 130 || code == 143
+}
+
+func (m *crashMonitor) handleExit(containerId string, code int) {
+	if containerId == "" || m.takeKill(containerId) {
+		return
+	}
+	oomKilled := false
+	if info, err := inspectContainer(context.Background(), containerId); err == nil {
+		oomKilled = info.State.OOMKilled
+	}
+	if isCleanExit(code) && !oomKilled {
+		return
+	}
+
+	ev := CrashEvent{
+		Event:     "crash",
+		ServerID:  containerId,
+		ExitCode:  code,
+		OOMKilled: oomKilled,
+		Time:      time.Now().UTC(),
+	}
+	if logs, err := containerLogs(context.Background(), containerId, container.LogsOptions{Tail: strconv.Itoa(crashLogLines)}); err == nil && logs != "" {
+		ev.LastLogs = strings.Split(logs, "\n")
+	}
+
+	loop := m.record(&ev)
+	slog.Warn("Server crashed", "server", containerId, "exitCode", code, "oom", oomKilled, "crashesInWindow", ev.Crashes)
+	if !loop {
+		go m.notify(ev)
+		return
+	}
+
+	update := container.UpdateConfig{RestartPolicy: container.RestartPolicy{Name: container.RestartPolicyDisabled}}
+	if _, err := dockerClient.ContainerUpdate(context.Background(), containerId, update); err != nil {
+		slog.Error("Crash monitor: failed to disable auto-restart", "server", containerId, "err", err)
+	}
+	loopEv := ev
+	loopEv.Event = "crash_loop"
+	slog.Warn("Server is crash-looping, auto-restart disabled", "server", containerId)
+	// The webhook may be slow, and the event stream waits for handleExit.
+	go func() {
+		m.notify(ev)
+		m.notify(loopEv)
+	}()
+}
+
+// reset forgets the crashes of a container, so a crash loop can be detected
+// again once its autostart is turned back on or it is recreated.
+func (m *crashMonitor) reset(containerId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.servers, containerId)
+}
+
+// expectKill marks the container's next exit, if it comes within a minute,
+// as a deliberate kill rather than a crash.
+func (m *crashMonitor) expectKill(containerId string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.killed[containerId] = time.Now()
+}
+
+// takeKill reports whether the container was just killed on purpose and
+// clears the mark.
+func (m *crashMonitor) takeKill(containerId string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	at, ok := m.killed[containerId]
+	delete(m.killed, containerId)
+	return ok && time.Since(at) < time.Minute
+}
+
+// prune drops crashes older than the window. A server that has gone a whole
+// window without crashing is no longer crash-looping.
+func (h *crashHistory) prune(now time.Time, window time.Duration) {
+	cutoff := now.Add(-window)
+	kept := h.events[:0]
+	for _, e := range h.events {
+		if e.Time.After(cutoff) {
+			kept = append(kept, e)
+		}
+	}
+	h.events = kept
+	if len(kept) == 0 {
+		h.loopDetected = false
+	}
+}
+
+// record stores ev, drops crashes older than the window and reports whether
+// the threshold was crossed for the first time.
+func (m *crashMonitor) record(ev *CrashEvent) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	h := m.servers[ev.ServerID]
+	if h == nil {
+		h = &crashHistory{}
+		m.servers[ev.ServerID] = h
+	}
+	h.prune(ev.Time, m.window)
+	h.events = append(h.events, *ev)
+	ev.Crashes = len(h.events)
+
+	if m.threshold > 0 && len(h.events) >= m.threshold && !h.loopDetected {
+		h.loopDetected = true
+		return true
+	}
+	return false
+}
+
+func (m *crashMonitor) notify(ev CrashEvent) {
+	if m.webhookURL == "" {
+		return
+	}
+	body, _ := json.Marshal(ev)
+	resp, err := m.client.Post(m.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		slog.Error("Crash monitor: webhook failed", "err", err)
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		slog.Error("Crash monitor: webhook returned an error", "status", resp.Status)
+	}
+}
+
+// history returns a copy of the crashes recorded for a container, and
+// whether it is crash-looping: it was detected and the last crash is within
+// the window.
+func (m *crashMonitor) history(containerId string) ([]CrashEvent, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	h := m.servers[containerId]
+	if h == nil {
+		return []CrashEvent{}, false
+	}
+	loop := h.loopDetected && len(h.events) > 0 && time.Since(h.events[len(h.events)-1].Time) < m.window
+	return append([]CrashEvent{}, h.events...), loop
+}
+
+func serverCrashesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	events, loop := crashes.history(containerId)
+	writeJSON(w, http.StatusOK, CrashListResponse{Status: "ok", CrashLoop: loop, Crashes: events})
+}
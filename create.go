@@ -0,0 +1,709 @@
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+type CreateServerRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Software   string `json:"software"`
+	// AcceptEula records that the user accepted the Minecraft EULA. Only
+	// then is the server started with EULA=TRUE; creating a game server
+	// without it is refused.
+	AcceptEula bool `json:"acceptEula"`
+	// RAM is the Java heap, e.g. "2G", "1536M" or "1024" (megabytes).
+	RAM string `json:"ram"`
+	// Storage is the server's disk quota in the same format, e.g. "10G".
+	Storage string `json:"storage,omitempty"`
+	CPU     string `json:"cpu,omitempty"`
+	// JavaVersion selects the JDK variant of the image, e.g. "17" or "java21".
+	JavaVersion string `json:"javaVersion,omitempty"`
+	// Version pins the Minecraft release, e.g. "1.20.4". Defaults to "latest".
+	Version string `json:"version,omitempty"`
+	// BandwidthIngress and BandwidthEgress are optional tc rates such as "10mbit".
+	BandwidthIngress string `json:"bandwidthIngress,omitempty"`
+	BandwidthEgress  string `json:"bandwidthEgress,omitempty"`
+	// Files are written into the new volume before the container is created,
+	// e.g. a starter server.properties or plugin configs.
+	Files []InjectFile `json:"files,omitempty"`
+	// Env adds environment variables such as JVM_OPTS or MAX_TICK_TIME. They
+	// override the image defaults but not the variables the agent sets.
+	Env map[string]string `json:"env,omitempty"`
+	// Port is the host port to publish the game port on. When 0 a free port
+	// from PORT_RANGE is assigned.
+	Port int `json:"port,omitempty"`
+	// RestartPolicy is no, always, unless-stopped (the default), on-failure
+	// or on-failure:N to give up after N retries.
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	// DryRun runs every check a create would and returns the resulting
+	// configuration, without pulling or creating anything.
+	DryRun bool `json:"dryRun,omitempty"`
+	// DisableHealthCheck creates the container without any health check, so
+	// /server/status reports no health.
+	DisableHealthCheck bool `json:"disableHealthCheck,omitempty"`
+}
+
+// InjectFile is one file to place in a new server's volume, with a path
+// relative to the volume root.
+type InjectFile struct {
+	Path          string `json:"path"`
+	ContentBase64 string `json:"contentBase64"`
+}
+
+type CreateServerResponse struct {
+	Status   string `json:"status"`
+	Message  string `json:"message"`
+	ServerID string `json:"serverId,omitempty"`
+	JobID    string `json:"jobId,omitempty"`
+	Port     int    `json:"port,omitempty"`
+	// RestartPolicy is the applied policy, e.g. "on-failure:3".
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	// A dry run also returns what the container would be created with.
+	DryRun     bool     `json:"dryRun,omitempty"`
+	Image      string   `json:"image,omitempty"`
+	Env        []string `json:"env,omitempty"`
+	VolumePath string   `json:"volumePath,omitempty"`
+}
+
+const defaultRAM = "1G"
+
+// serverHealthcheck pings the server on its game port with the image's
+// mc-monitor, so a server that is running but hung shows up as unhealthy.
+// Failures during the start period, while the world loads, don't count.
+var serverHealthcheck = &container.HealthConfig{
+	Test:        []string{"CMD", "mc-monitor", "status", "--host", "localhost"},
+	Interval:    30 * time.Second,
+	Timeout:     10 * time.Second,
+	Retries:     3,
+	StartPeriod: 5 * time.Minute,
+}
+
+// Limits on the files a create request may inject.
+const (
+	maxInjectFiles     = 200
+	maxInjectTotalSize = 20 << 20
+)
+
+// createBodyLimit is the largest create request: the injected files, base64
+// encoded, on top of MAX_JSON_BODY for the rest.
+func createBodyLimit() int64 {
+	return maxInjectTotalSize/3*4 + 4 + maxJSONBody()
+}
+
+var versionPattern = regexp.MustCompile(`^(latest|snapshot|\d+\.\d+(\.\d+)?)$`)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Z_][A-Z0-9_]*$`)
+
+// reservedEnv are variables the agent sets from other request fields.
+var reservedEnv = map[string]bool{"EULA": true, "TYPE": true, "VERSION": true, "MEMORY": true}
+
+// ownerLabel records the userId a server belongs to. Containers created before
+// container IDs used containerIdSeparator don't have it.
+const ownerLabel = "mcnode.owner"
+
+// storageLabel records a server's disk quota in bytes.
+const storageLabel = "mcnode.storage"
+
+var memoryPattern = regexp.MustCompile(`^(?i)([1-9][0-9]*)([MG]?)$`)
+
+// parseMemory converts a size such as "512M", "2G" or "1024" into bytes. A
+// plain number is in megabytes.
+func parseMemory(s string) (int64, error) {
+	m := memoryPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil {
+		return 0, fmt.Errorf("invalid size %q: use a whole number of megabytes or gigabytes, e.g. 1024, 1024M or 2G", s)
+	}
+	n, err := strconv.ParseInt(m[1], 10, 64)
+	if err != nil || n > 1<<20 {
+		return 0, fmt.Errorf("invalid size %q: too large", s)
+	}
+	if strings.EqualFold(m[2], "G") {
+		n *= 1024
+	}
+	return n << 20, nil
+}
+
+// formatMemory is the inverse of parseMemory, in the form the image's MEMORY
+// variable expects: "2G" when it's whole gigabytes, "1536M" otherwise.
+func formatMemory(bytes int64) string {
+	if bytes%(1<<30) == 0 {
+		return strconv.FormatInt(bytes>>30, 10) + "G"
+	}
+	return strconv.FormatInt(bytes>>20, 10) + "M"
+}
+
+// containerMemoryMB is the hard container limit for a given Java heap. The
+// JVM needs room beyond -Xmx for metaspace, threads and native buffers, so the
+// container gets 25% (at least 256M) on top of the heap.
+func containerMemoryMB(heapMB int64) int64 {
+	overhead := heapMB / 4
+	if overhead < 256 {
+		overhead = 256
+	}
+	return heapMB + overhead
+}
+
+// parseRestartPolicy parses a restart policy in docker run's --restart form.
+// An empty policy means unless-stopped.
+func parseRestartPolicy(s string) (container.RestartPolicy, error) {
+	name, count, hasCount := strings.Cut(strings.ToLower(strings.TrimSpace(s)), ":")
+	policy := container.RestartPolicy{Name: container.RestartPolicyMode(name)}
+	switch policy.Name {
+	case "":
+		policy.Name = container.RestartPolicyUnlessStopped
+	case container.RestartPolicyDisabled, container.RestartPolicyAlways, container.RestartPolicyUnlessStopped, container.RestartPolicyOnFailure:
+	default:
+		return policy, fmt.Errorf("invalid restartPolicy %q: use no, always, unless-stopped, on-failure or on-failure:N", s)
+	}
+	if hasCount {
+		if policy.Name != container.RestartPolicyOnFailure {
+			return policy, fmt.Errorf("invalid restartPolicy %q: only on-failure takes a retry count, e.g. on-failure:3", s)
+		}
+		n, err := strconv.Atoi(count)
+		if err != nil || n < 1 {
+			return policy, fmt.Errorf("invalid restartPolicy %q: the retry count must be a positive number", s)
+		}
+		policy.MaximumRetryCount = n
+	}
+	return policy, nil
+}
+
+// formatRestartPolicy is the inverse of parseRestartPolicy.
+func formatRestartPolicy(p container.RestartPolicy) string {
+	if p.Name == container.RestartPolicyOnFailure && p.MaximumRetryCount > 0 {
+		return fmt.Sprintf("%s:%d", p.Name, p.MaximumRetryCount)
+	}
+	return string(p.Name)
+}
+
+func parseCPU(s string) (float64, error) {
+	cpus, err := strconv.ParseFloat(strings.TrimSpace(s), 64)
+	if err != nil || cpus <= 0 {
+		return 0, fmt.Errorf("invalid cpu %q: must be a positive number of cores, e.g. 1.5", s)
+	}
+	return cpus, nil
+}
+
+const (
+	serverImage = "itzg/minecraft-server"
+	proxyImage  = "itzg/mc-proxy"
+)
+
+// softwareTypes maps the software names we accept onto the TYPE env var the
+// itzg images expect.
+var softwareTypes = map[string]string{
+	"vanilla":    "VANILLA",
+	"paper":      "PAPER",
+	"spigot":     "SPIGOT",
+	"forge":      "FORGE",
+	"fabric":     "FABRIC",
+	"bungeecord": "BUNGEECORD",
+}
+
+// javaImageTags maps the supported Java versions onto itzg image tags.
+var javaImageTags = map[string]string{
+	"8":  "java8",
+	"11": "java11",
+	"17": "java17",
+	"21": "java21",
+}
+
+// defaultJavaVersion returns the Java version a Minecraft release needs, or
+// "" when the image's default JDK (built for the latest release) is right.
+func defaultJavaVersion(mcVersion string) string {
+	parts := strings.Split(mcVersion, ".")
+	if len(parts) < 2 || parts[0] != "1" {
+		return ""
+	}
+	minor, _ := strconv.Atoi(parts[1])
+	patch := 0
+	if len(parts) == 3 {
+		patch, _ = strconv.Atoi(parts[2])
+	}
+	switch {
+	case minor <= 16:
+		return "8"
+	case minor < 20 || (minor == 20 && patch < 5):
+		return "17"
+	default:
+		return "21"
+	}
+}
+
+// selectImage picks the container image and TYPE env var for the requested
+// software and Java version. An empty software means vanilla; an empty
+// javaVersion picks the JDK that mcVersion needs.
+func selectImage(software, javaVersion, mcVersion string) (image string, typeEnv string, err error) {
+	software = strings.ToLower(strings.TrimSpace(software))
+	if software == "" {
+		software = "vanilla"
+	}
+	typeEnv, ok := softwareTypes[software]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported software %q: supported values are vanilla, paper, spigot, forge, fabric and bungeecord", software)
+	}
+	if isProxyType(typeEnv) {
+		if javaVersion != "" {
+			return "", "", fmt.Errorf("javaVersion is not supported for %s", software)
+		}
+		return proxyImage + ":latest", typeEnv, nil
+	}
+	if javaVersion == "" {
+		javaVersion = defaultJavaVersion(mcVersion)
+	}
+	if javaVersion == "" {
+		return serverImage + ":latest", typeEnv, nil
+	}
+	tag, ok := javaImageTags[strings.TrimPrefix(strings.ToLower(javaVersion), "java")]
+	if !ok {
+		return "", "", fmt.Errorf("unsupported javaVersion %q: supported versions are 8, 11, 17 and 21", javaVersion)
+	}
+	return serverImage + ":" + tag, typeEnv, nil
+}
+
+// isProxyType reports whether typeEnv runs on the mc-proxy image rather than
+// the game server image.
+func isProxyType(typeEnv string) bool {
+	return typeEnv == "BUNGEECORD"
+}
+
+// containerDataPath is where the image expects its persistent data.
+func containerDataPath(typeEnv string) string {
+	if isProxyType(typeEnv) {
+		return "/server"
+	}
+	return "/data"
+}
+
+// createPlan is a validated create request: everything needed to pull the
+// image and create the container.
+type createPlan struct {
+	ContainerID string
+	Image       string
+	TypeEnv     string
+	Version     string
+	// RAM is the Java heap as passed in MEMORY.
+	RAM     string
+	DataDir string
+	// Files maps paths inside the volume to the content written there.
+	Files map[string][]byte
+	// Config and HostConfig describe the container to create.
+	Config     *container.Config
+	HostConfig *container.HostConfig
+	// Port is the requested host port until publishPort sets the assigned one.
+	Port    int
+	Summary string
+}
+
+// planCreate validates req and works out the image, container name and
+// container configuration. Every error it returns is a client error.
+func planCreate(req CreateServerRequest) (*createPlan, error) {
+	if req.ServerName == "" || req.UserEmail == "" {
+		return nil, errors.New("serverName and userEmail are required")
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		return nil, err
+	}
+	ram := req.RAM
+	if ram == "" {
+		ram = defaultRAM
+	}
+	heap, err := parseMemory(ram)
+	if err != nil {
+		return nil, fmt.Errorf("ram: %v", err)
+	}
+	ram = formatMemory(heap)
+	heapMB := heap >> 20
+	var storage int64
+	if req.Storage != "" {
+		if storage, err = parseMemory(req.Storage); err != nil {
+			return nil, fmt.Errorf("storage: %v", err)
+		}
+	}
+	memoryMB := containerMemoryMB(heapMB)
+	memoryLimit := strconv.FormatInt(memoryMB, 10) + "m"
+	cpus := ""
+	var nanoCPUs int64
+	if req.CPU != "" {
+		c, err := parseCPU(req.CPU)
+		if err != nil {
+			return nil, err
+		}
+		cpus = strconv.FormatFloat(c, 'f', -1, 64)
+		nanoCPUs = int64(c * 1e9)
+	}
+
+	restartPolicy, err := parseRestartPolicy(req.RestartPolicy)
+	if err != nil {
+		return nil, err
+	}
+	if req.Port != 0 && (req.Port < 1024 || req.Port > 65535) {
+		return nil, fmt.Errorf("port must be between 1024 and 65535")
+	}
+	version := strings.ToLower(strings.TrimSpace(req.Version))
+	if version == "" {
+		version = "latest"
+	}
+	if !versionPattern.MatchString(version) {
+		return nil, fmt.Errorf("invalid version %q: use latest, snapshot or a release like 1.20.4", req.Version)
+	}
+	for _, bw := range []string{req.BandwidthIngress, req.BandwidthEgress} {
+		if bw == "" {
+			continue
+		}
+		if _, err := parseBandwidth(bw); err != nil {
+			return nil, err
+		}
+	}
+	image, typeEnv, err := selectImage(req.Software, req.JavaVersion, version)
+	if err != nil {
+		return nil, err
+	}
+	// The proxy runs no Minecraft server, so there is no EULA to accept.
+	needsEula := !isProxyType(typeEnv)
+	if needsEula && !req.AcceptEula {
+		return nil, errors.New("acceptEula must be true: the user has to accept the Minecraft EULA (https://aka.ms/MinecraftEULA) before the server can run")
+	}
+
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	dataDir, err := filepath.Abs(getServerDataDir(containerId))
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := decodeInjectFiles(containerId, req.Files, storage)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &container.Config{
+		Image:  image,
+		Labels: map[string]string{managedLabel: "true", ownerLabel: extractUserId(req.UserEmail)},
+		Env: []string{
+			"TYPE=" + typeEnv,
+			"VERSION=" + version,
+			"MEMORY=" + ram,
+		},
+	}
+	if needsEula {
+		config.Env = append([]string{"EULA=TRUE"}, config.Env...)
+	}
+	hostConfig := &container.HostConfig{
+		Binds:         []string{dataDir + ":" + containerDataPath(typeEnv)},
+		RestartPolicy: restartPolicy,
+		Resources: container.Resources{
+			Memory: memoryMB << 20,
+			// Equal to Memory so the container can't spill into swap.
+			MemorySwap: memoryMB << 20,
+			NanoCPUs:   nanoCPUs,
+		},
+	}
+	envKeys, err := validateEnv(req.Env)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range envKeys {
+		config.Env = append(config.Env, k+"="+req.Env[k])
+	}
+	if storage > 0 {
+		config.Labels[storageLabel] = strconv.FormatInt(storage, 10)
+	}
+	if req.BandwidthIngress != "" {
+		config.Labels[bandwidthIngressLabel] = strings.ToLower(req.BandwidthIngress)
+	}
+	if req.BandwidthEgress != "" {
+		config.Labels[bandwidthEgressLabel] = strings.ToLower(req.BandwidthEgress)
+	}
+	switch {
+	case req.DisableHealthCheck:
+		// Also turns off any HEALTHCHECK the image defines.
+		config.Healthcheck = &container.HealthConfig{Test: []string{"NONE"}}
+	case !isProxyType(typeEnv):
+		config.Healthcheck = serverHealthcheck
+	}
+
+	summary := fmt.Sprintf("%s (%s, version %s): %s Java heap, container memory limit %s (heap + JVM overhead, no swap)", image, typeEnv, version, ram, memoryLimit)
+	if cpus != "" {
+		summary += ", " + cpus + " CPUs"
+	} else {
+		summary += ", no CPU limit"
+	}
+	summary += ", restart " + formatRestartPolicy(restartPolicy)
+	if needsEula {
+		summary += ", Minecraft EULA accepted by the user"
+	}
+	if storage > 0 {
+		summary += ", " + formatMemory(storage) + " disk quota"
+	}
+	if req.DisableHealthCheck {
+		summary += ", no health check"
+	}
+	if len(envKeys) > 0 {
+		summary += ", custom env " + strings.Join(envKeys, ", ") + " (overrides image defaults; EULA, TYPE, VERSION and MEMORY always come from the agent)"
+	}
+	return &createPlan{
+		ContainerID: containerId,
+		Image:       image,
+		TypeEnv:     typeEnv,
+		Version:     version,
+		RAM:         ram,
+		DataDir:     dataDir,
+		Files:       files,
+		Config:      config,
+		HostConfig:  hostConfig,
+		Port:        req.Port,
+		Summary:     summary,
+	}, nil
+}
+
+// validateEnv checks custom environment variables and returns their keys in
+// sorted order. Keys must look like shell variable names so nothing odd ends
+// up in the container's environment, and reserved keys are refused.
+func validateEnv(env map[string]string) ([]string, error) {
+	keys := make([]string, 0, len(env))
+	for k, v := range env {
+		if !envKeyPattern.MatchString(k) {
+			return nil, fmt.Errorf("invalid env key %q: use upper-case letters, digits and underscores", k)
+		}
+		if reservedEnv[k] {
+			return nil, fmt.Errorf("env key %s is set by the agent and can't be overridden", k)
+		}
+		if strings.ContainsRune(v, 0) {
+			return nil, fmt.Errorf("env value for %s contains a NUL byte", k)
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// decodeInjectFiles validates the files of a create request: paths must stay
+// inside the volume and the decoded contents must fit the size limits and
+// the storage quota, if the server has one.
+func decodeInjectFiles(containerId string, files []InjectFile, storage int64) (map[string][]byte, error) {
+	if len(files) > maxInjectFiles {
+		return nil, fmt.Errorf("at most %d files can be injected", maxInjectFiles)
+	}
+	decoded := make(map[string][]byte, len(files))
+	var total int
+	for _, f := range files {
+		path, err := resolveServerPath(containerId, f.Path)
+		if err != nil || path == getServerDataDir(containerId) {
+			return nil, fmt.Errorf("invalid file path %q", f.Path)
+		}
+		if _, dup := decoded[path]; dup {
+			return nil, fmt.Errorf("file %q is listed twice", f.Path)
+		}
+		data, err := base64.StdEncoding.DecodeString(f.ContentBase64)
+		if err != nil {
+			return nil, fmt.Errorf("file %q: contentBase64 is not valid base64", f.Path)
+		}
+		if int64(len(data)) > maxFileSize() {
+			return nil, fmt.Errorf("file %q exceeds the maximum size of %s (MAX_FILE_SIZE)", f.Path, formatMemory(maxFileSize()))
+		}
+		total += len(data)
+		if total > maxInjectTotalSize {
+			return nil, fmt.Errorf("injected files exceed %d MB in total", maxInjectTotalSize>>20)
+		}
+		if storage > 0 && int64(total) > storage {
+			return nil, fmt.Errorf("injected files exceed the storage quota of %s", formatMemory(storage))
+		}
+		decoded[path] = data
+	}
+	return decoded, nil
+}
+
+// quotaMu makes counting a user's servers and registering the new create job
+// one step, so parallel creates can't all squeeze under the limit.
+var quotaMu sync.Mutex
+
+// maxServersPerUser is MAX_SERVERS_PER_USER (default 5); 0 or less disables
+// the limit.
+func maxServersPerUser() int {
+	return envInt("MAX_SERVERS_PER_USER", 5)
+}
+
+// countUserServers counts userId's existing containers and servers still being
+// created, leaving out exclude (the server being created, which doesn't use
+// a new slot if it already exists).
+func countUserServers(userId, exclude string) (int, error) {
+	ids, err := userServerIds(userId)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range createJobs.activeServerIds() {
+		if _, ok := serverNameFromContainerId(id, userId); ok {
+			ids = append(ids, id)
+		}
+	}
+	seen := map[string]bool{exclude: true}
+	count := 0
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			count++
+		}
+	}
+	return count, nil
+}
+
+// createServerHandler validates the request and starts the pull and create in
+// the background, returning 202 with a job ID to poll on
+// /server/create/status. Pulling a fresh image can take minutes.
+// A dry run stops after the checks and answers 200 with the plan.
+func createServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req CreateServerRequest
+	if err := decodeJSONBodyLimit(r, &req, createBodyLimit()); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	plan, err := planCreate(req)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+
+	unlock, ok := lockServer(w, plan.ContainerID, "create")
+	if !ok {
+		return
+	}
+	port, ok := reserveCreate(w, r, plan, req.UserEmail)
+	if !ok {
+		unlock()
+		return
+	}
+	plan.publishPort(port)
+	if req.DryRun {
+		quotaMu.Unlock()
+		ports.release(port)
+		unlock()
+		writeJSON(w, http.StatusOK, CreateServerResponse{
+			Status:        "ok",
+			Message:       "Dry run: would create server with " + plan.Summary,
+			ServerID:      plan.ContainerID,
+			Port:          port,
+			RestartPolicy: formatRestartPolicy(plan.HostConfig.RestartPolicy),
+			DryRun:        true,
+			Image:         plan.Image,
+			Env:           plan.Config.Env,
+			VolumePath:    plan.DataDir,
+		})
+		return
+	}
+	job := createJobs.add(plan.ContainerID)
+	quotaMu.Unlock()
+	go func() {
+		defer unlock()
+		// Once the container exists its label holds the port.
+		defer ports.release(port)
+		runCreateJob(job.ID, plan)
+	}()
+
+	writeJSON(w, http.StatusAccepted, CreateServerResponse{
+		Status:        "ok",
+		Message:       "Creating server with " + plan.Summary,
+		ServerID:      plan.ContainerID,
+		JobID:         job.ID,
+		Port:          port,
+		RestartPolicy: formatRestartPolicy(plan.HostConfig.RestartPolicy),
+	})
+}
+
+// reserveCreate checks, with the server locked, that plan's container doesn't
+// exist yet and that the user has a server slot left, and assigns the port.
+// On success quotaMu is still held, so the caller can register its job before
+// another create counts the user's servers; on failure the error has been
+// written and quotaMu released.
+func reserveCreate(w http.ResponseWriter, r *http.Request, plan *createPlan, userEmail string) (int, bool) {
+	// Checked under the lock, before the job touches the volume, so a
+	// repeated create can't write its files into the existing server.
+	if _, err := inspectContainer(r.Context(), plan.ContainerID); err == nil {
+		writeError(w, http.StatusConflict, "Server already exists")
+		return 0, false
+	} else if !isNotFound(err) {
+		writeError(w, http.StatusInternalServerError, "Failed to check for an existing server: "+err.Error())
+		return 0, false
+	}
+	quotaMu.Lock()
+	count, err := countUserServers(extractUserId(userEmail), plan.ContainerID)
+	if err != nil {
+		quotaMu.Unlock()
+		writeError(w, http.StatusInternalServerError, "Failed to count existing servers: "+err.Error())
+		return 0, false
+	}
+	if limit := maxServersPerUser(); limit > 0 && count >= limit {
+		quotaMu.Unlock()
+		writeError(w, http.StatusTooManyRequests, fmt.Sprintf("Server limit reached: %d of %d servers in use", count, limit))
+		return 0, false
+	}
+	port, err := ports.allocate(plan.ContainerID, plan.Port)
+	if err != nil {
+		quotaMu.Unlock()
+		switch {
+		case errors.Is(err, errPortInUse):
+			writeError(w, http.StatusConflict, fmt.Sprintf("Port %d is already assigned to another server", plan.Port))
+		case errors.Is(err, errNoFreePort):
+			writeError(w, http.StatusServiceUnavailable, "No free port left in PORT_RANGE")
+		default:
+			writeError(w, http.StatusInternalServerError, "Failed to assign a port: "+err.Error())
+		}
+		return 0, false
+	}
+	return port, true
+}
+
+// runCreateJob pulls the image and creates the container, recording progress
+// on the job as it goes.
+func runCreateJob(jobId string, plan *createPlan) {
+	if err := os.MkdirAll(plan.DataDir, 0755); err != nil {
+		createJobs.fail(jobId, "Failed to create data directory: "+err.Error())
+		return
+	}
+	for path, data := range plan.Files {
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			createJobs.fail(jobId, "Failed to write injected files: "+err.Error())
+			return
+		}
+		if err := writeFileAtomic(path, data, 0644); err != nil {
+			createJobs.fail(jobId, "Failed to write injected files: "+err.Error())
+			return
+		}
+	}
+
+	createJobs.setState(jobId, jobPulling)
+	err := streamDocker(func(line string) { createJobs.setOutput(jobId, redactCredentials(line)) }, "pull", plan.Image)
+	if err != nil {
+		code, msg := classifyPullError(plan.Image, err.Error())
+		createJobs.failWithCode(jobId, code, msg)
+		return
+	}
+
+	createJobs.setState(jobId, jobCreating)
+	if err := createContainer(plan.ContainerID, plan.Config, plan.HostConfig); err != nil {
+		createJobs.fail(jobId, "Failed to create container: "+err.Error())
+		return
+	}
+	createJobs.setState(jobId, jobDone)
+}
@@ -0,0 +1,231 @@
+package main
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestParseMemory(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int64
+	}{
+		{"1024", 1024 << 20},
+		{"512M", 512 << 20},
+		{"512m", 512 << 20},
+		{"2G", 2 << 30},
+		{"2g", 2 << 30},
+		{" 4G ", 4 << 30},
+		{"1048576", 1 << 40},
+	}
+	for _, tt := range tests {
+		got, err := parseMemory(tt.in)
+		if err != nil {
+			t.Errorf("parseMemory(%q) error = %v", tt.in, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseMemory(%q) = %d, want %d", tt.in, got, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "0", "0M", "-1G", "1.5G", "2GB", "2K", "G", "1 G", "0x10", "01G", "1048577", "99999999999999999999"} {
+		if got, err := parseMemory(in); err == nil {
+			t.Errorf("parseMemory(%q) = %d, want an error", in, got)
+		}
+	}
+}
+
+func TestFormatMemory(t *testing.T) {
+	for _, s := range []string{"512M", "1536M", "2G", "16G"} {
+		n, err := parseMemory(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := formatMemory(n); got != s {
+			t.Errorf("formatMemory(parseMemory(%q)) = %q", s, got)
+		}
+	}
+}
+
+func TestSelectImage(t *testing.T) {
+	tests := []struct {
+		software, java, version string
+		wantImage, wantType     string
+	}{
+		{"", "", "", "itzg/minecraft-server:latest", "VANILLA"},
+		{"vanilla", "", "LATEST", "itzg/minecraft-server:latest", "VANILLA"},
+		{" Paper ", "", "1.21.1", "itzg/minecraft-server:java21", "PAPER"},
+		{"spigot", "", "1.20.4", "itzg/minecraft-server:java17", "SPIGOT"},
+		{"spigot", "", "1.20.5", "itzg/minecraft-server:java21", "SPIGOT"},
+		{"forge", "", "1.18.2", "itzg/minecraft-server:java17", "FORGE"},
+		{"forge", "", "1.17", "itzg/minecraft-server:java17", "FORGE"},
+		{"forge", "", "1.16.5", "itzg/minecraft-server:java8", "FORGE"},
+		{"forge", "", "1.12.2", "itzg/minecraft-server:java8", "FORGE"},
+		{"fabric", "", "1.20", "itzg/minecraft-server:java17", "FABRIC"},
+		{"fabric", "", "24w14a", "itzg/minecraft-server:latest", "FABRIC"},
+		{"paper", "11", "1.16.5", "itzg/minecraft-server:java11", "PAPER"},
+		{"paper", "java17", "1.12.2", "itzg/minecraft-server:java17", "PAPER"},
+		{"paper", "Java21", "", "itzg/minecraft-server:java21", "PAPER"},
+		{"vanilla", "8", "", "itzg/minecraft-server:java8", "VANILLA"},
+		{"bungeecord", "", "", "itzg/mc-proxy:latest", "BUNGEECORD"},
+		{"BungeeCord", "", "1.20.4", "itzg/mc-proxy:latest", "BUNGEECORD"},
+	}
+	for _, tt := range tests {
+		image, typeEnv, err := selectImage(tt.software, tt.java, tt.version)
+		if err != nil {
+			t.Errorf("selectImage(%q, %q, %q) error = %v", tt.software, tt.java, tt.version, err)
+			continue
+		}
+		if image != tt.wantImage || typeEnv != tt.wantType {
+			t.Errorf("selectImage(%q, %q, %q) = %s, %s; want %s, %s", tt.software, tt.java, tt.version, image, typeEnv, tt.wantImage, tt.wantType)
+		}
+	}
+
+	for _, bad := range [][3]string{
+		{"bukkit", "", ""},
+		{"paper", "16", ""},
+		{"paper", "java", ""},
+		{"bungeecord", "17", ""},
+	} {
+		if image, _, err := selectImage(bad[0], bad[1], bad[2]); err == nil {
+			t.Errorf("selectImage(%q, %q, %q) = %s, want an error", bad[0], bad[1], bad[2], image)
+		}
+	}
+}
+
+// ownedServer is a managed container belonging to userId.
+func ownedServer(userId, port string) types.ContainerJSON {
+	return runningContainer(map[string]string{managedLabel: "true", ownerLabel: userId, portLabel: port})
+}
+
+func TestReserveCreateServerLimit(t *testing.T) {
+	fakeDocker(t, map[string]types.ContainerJSON{
+		"lobby--alice":    ownedServer("alice", "25565"),
+		"survival--alice": ownedServer("alice", "25566"),
+		"lobby--bob":      ownedServer("bob", "25567"),
+	})
+	r := httptest.NewRequest(http.MethodPost, "/server/create", nil)
+
+	reserve := func(t *testing.T, serverId string) *httptest.ResponseRecorder {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		port, ok := reserveCreate(rec, r, &createPlan{ContainerID: serverId, Port: 30001}, "alice@example.com")
+		if ok {
+			quotaMu.Unlock()
+			ports.release(port)
+			if port != 30001 {
+				t.Errorf("port = %d, want the requested 30001", port)
+			}
+		} else if !quotaMu.TryLock() {
+			t.Fatal("quotaMu still held after a refused create")
+		} else {
+			quotaMu.Unlock()
+		}
+		return rec
+	}
+
+	t.Run("under the limit", func(t *testing.T) {
+		t.Setenv("MAX_SERVERS_PER_USER", "3")
+		if rec := reserve(t, "creative--alice"); rec.Code != http.StatusOK {
+			t.Errorf("status = %d (%s), want the create to go ahead", rec.Code, rec.Body)
+		}
+	})
+	t.Run("at the limit", func(t *testing.T) {
+		// bob's server doesn't count against alice.
+		t.Setenv("MAX_SERVERS_PER_USER", "2")
+		if rec := reserve(t, "creative--alice"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, http.StatusTooManyRequests)
+		}
+	})
+	t.Run("unlimited", func(t *testing.T) {
+		t.Setenv("MAX_SERVERS_PER_USER", "0")
+		if rec := reserve(t, "creative--alice"); rec.Code != http.StatusOK {
+			t.Errorf("status = %d (%s), want the create to go ahead", rec.Code, rec.Body)
+		}
+	})
+	t.Run("pending create counts", func(t *testing.T) {
+		t.Setenv("MAX_SERVERS_PER_USER", "3")
+		job := createJobs.add("pending--alice")
+		defer createJobs.fail(job.ID, "test over")
+		if rec := reserve(t, "creative--alice"); rec.Code != http.StatusTooManyRequests {
+			t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, http.StatusTooManyRequests)
+		}
+	})
+	t.Run("existing server", func(t *testing.T) {
+		t.Setenv("MAX_SERVERS_PER_USER", "3")
+		if rec := reserve(t, "lobby--alice"); rec.Code != http.StatusConflict {
+			t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, http.StatusConflict)
+		}
+	})
+	t.Run("port taken", func(t *testing.T) {
+		t.Setenv("MAX_SERVERS_PER_USER", "3")
+		rec := httptest.NewRecorder()
+		if _, ok := reserveCreate(rec, r, &createPlan{ContainerID: "creative--alice", Port: 25567}, "alice@example.com"); ok {
+			t.Fatal("reserved a port assigned to lobby--bob")
+		}
+		if rec.Code != http.StatusConflict {
+			t.Errorf("status = %d (%s), want %d", rec.Code, rec.Body, http.StatusConflict)
+		}
+	})
+}
+
+func TestValidateEnv(t *testing.T) {
+	keys, err := validateEnv(map[string]string{"OPS": "alice", "_HIDDEN": "", "ENABLE_RCON": "true", "MAX_PLAYERS2": "20"})
+	if err != nil {
+		t.Fatalf("validateEnv() error = %v", err)
+	}
+	if want := []string{"ENABLE_RCON", "MAX_PLAYERS2", "OPS", "_HIDDEN"}; !reflect.DeepEqual(keys, want) {
+		t.Errorf("validateEnv() keys = %v, want %v", keys, want)
+	}
+
+	bad := []map[string]string{
+		{"": "x"},
+		{"ops": "alice"},
+		{"Ops": "alice"},
+		{"1OPS": "alice"},
+		{"MAX-PLAYERS": "20"},
+		{"MAX PLAYERS": "20"},
+		{"OPS=alice": ""},
+		{"OPS\nEULA": "TRUE"},
+		{"ÖPS": "alice"},
+		{"LD_PRELOAD;rm": "x"},
+		{"EULA": "TRUE"},
+		{"TYPE": "PAPER"},
+		{"VERSION": "1.20.4"},
+		{"MEMORY": "64G"},
+		{"MOTD": "hello\x00world"},
+	}
+	for _, env := range bad {
+		if keys, err := validateEnv(env); err == nil {
+			t.Errorf("validateEnv(%q) = %v, want an error", env, keys)
+		}
+	}
+}
+
+func TestDecodeInjectFilesStorageQuota(t *testing.T) {
+	useVolumeRoot(t)
+	file := func(path string, size int) InjectFile {
+		return InjectFile{Path: path, ContentBase64: base64.StdEncoding.EncodeToString([]byte(strings.Repeat("x", size)))}
+	}
+	files := []InjectFile{file("server.properties", 600<<10), file("config/paper.yml", 600<<10)}
+
+	if _, err := decodeInjectFiles("lobby--alice", files, 0); err != nil {
+		t.Errorf("without a quota: error = %v", err)
+	}
+	if _, err := decodeInjectFiles("lobby--alice", files, 2<<20); err != nil {
+		t.Errorf("within the quota: error = %v", err)
+	}
+	if _, err := decodeInjectFiles("lobby--alice", files, 1<<20); err == nil || !strings.Contains(err.Error(), "storage quota") {
+		t.Errorf("over the quota: error = %v, want the storage quota refused", err)
+	}
+	if _, err := decodeInjectFiles("lobby--alice", []InjectFile{file("../lobby--bob/x", 1)}, 0); err == nil {
+		t.Error("accepted a path outside the volume")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func TestParseCron(t *testing.T) {
+	for _, expr := range []string{
+		"* * * * *",
+		"*/15 * * * *",
+		"30 4 * * 1-5",
+		"0 0,12 1,15 * *",
+		"0 12 * jan,JUL sun",
+		"0 0 * * 7",
+		"5-55/10 * * * *",
+		" @daily ",
+		"@HOURLY",
+		"0 0 31 2 *",
+	} {
+		if _, err := parseCron(expr); err != nil {
+			t.Errorf("parseCron(%q) error = %v", expr, err)
+		}
+	}
+
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * 32 * *",
+		"* * * 0 *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"5-1 * * * *",
+		"1,,2 * * * *",
+		"foo * * * *",
+		"* * * * monday",
+		"@reboot",
+	} {
+		if _, err := parseCron(expr); err == nil {
+			t.Errorf("parseCron(%q) accepted", expr)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utc := func(s string) time.Time {
+		v, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	// ny reads a New York wall-clock time with an explicit offset, which
+	// picks one side of a daylight saving change.
+	ny := func(s string) time.Time { return utc(s).In(newYork) }
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"every 15 minutes", "*/15 * * * *", utc("2024-05-01T10:07:30Z"), utc("2024-05-01T10:15:00Z")},
+		{"strictly after", "*/15 * * * *", utc("2024-05-01T10:15:00Z"), utc("2024-05-01T10:30:00Z")},
+		{"next day", "0 0 * * *", utc("2024-05-01T23:59:30Z"), utc("2024-05-02T00:00:00Z")},
+		{"weekdays over a weekend", "30 4 * * 1-5", utc("2024-05-03T05:00:00Z"), utc("2024-05-06T04:30:00Z")},
+		{"Sunday as 7", "0 0 * * 7", utc("2024-05-01T00:00:00Z"), utc("2024-05-05T00:00:00Z")},
+		{"month and day names", "0 12 * jul sun", utc("2024-05-01T00:00:00Z"), utc("2024-07-07T12:00:00Z")},
+		{"monthly from the 31st", "@monthly", utc("2024-01-31T12:00:00Z"), utc("2024-02-01T00:00:00Z")},
+		{"day of month skips short months", "0 0 31 * *", utc("2024-04-01T00:00:00Z"), utc("2024-05-31T00:00:00Z")},
+		{"leap day", "0 0 29 2 *", utc("2024-03-01T00:00:00Z"), utc("2028-02-29T00:00:00Z")},
+		{"year end", "59 23 31 12 *", utc("2024-12-31T23:59:00Z"), utc("2025-12-31T23:59:00Z")},
+		// With both day fields restricted either one matches.
+		{"day of month or weekday", "0 0 31 2 1", utc("2024-01-01T00:00:00Z"), utc("2024-02-05T00:00:00Z")},
+		{"local time zone", "0 3 * * *", ny("2024-05-01T12:00:00-04:00"), ny("2024-05-02T03:00:00-04:00")},
+
+		// Clocks go from 02:00 EST to 03:00 EDT on 2024-03-10.
+		{"before spring forward", "0 1 * * *", ny("2024-03-10T00:00:00-05:00"), ny("2024-03-10T01:00:00-05:00")},
+		{"skipped time", "30 2 * * *", ny("2024-03-10T00:00:00-05:00"), ny("2024-03-11T02:30:00-04:00")},
+		{"after spring forward", "0 3 * * *", ny("2024-03-10T00:00:00-05:00"), ny("2024-03-10T03:00:00-04:00")},
+		{"across the gap", "*/30 * * * *", ny("2024-03-10T01:45:00-05:00"), ny("2024-03-10T03:00:00-04:00")},
+
+		// Clocks go from 02:00 EDT back to 01:00 EST on 2024-11-03.
+		{"repeated time, first", "30 1 * * *", ny("2024-11-03T00:00:00-04:00"), ny("2024-11-03T01:30:00-04:00")},
+		{"repeated time, not again", "30 1 * * *", ny("2024-11-03T01:30:10-04:00"), ny("2024-11-04T01:30:00-05:00")},
+		{"hourly over fall back", "0 * * * *", ny("2024-11-03T01:00:10-04:00"), ny("2024-11-03T02:00:00-05:00")},
+		{"after fall back", "0 3 * * *", ny("2024-11-03T00:00:00-04:00"), ny("2024-11-03T03:00:00-05:00")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := c.next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Errorf("next(%s) of %q = %s, want %s", tt.from, tt.expr, got, tt.want)
+			}
+			if got.Location() != tt.from.Location() {
+				t.Errorf("next() is in %s, want %s", got.Location(), tt.from.Location())
+			}
+		})
+	}
+}
+
+func TestCronNextNever(t *testing.T) {
+	for _, expr := range []string{"0 0 31 2 *", "0 0 30 2 *", "0 0 31 4,6,9,11 *"} {
+		c, err := parseCron(expr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := c.next(time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)); !got.IsZero() {
+			t.Errorf("next() of %q = %s, want never", expr, got)
+		}
+		if _, err := newScheduledBackup(BackupSchedule{Cron: expr}); err == nil {
+			t.Errorf("newScheduledBackup accepted %q, which never fires", expr)
+		}
+	}
+}
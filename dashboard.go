@@ -0,0 +1,285 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// DashboardMessage is sent over /ws/dashboard. Type names the channel ("log",
+// "stat", "status", "player"), is "command" on the log channel for a command
+// any console or dashboard client of the server sent, or "result" or "error"
+// for replies to the client's own actions.
+type DashboardMessage struct {
+	Type    string       `json:"type"`
+	Line    string       `json:"line,omitempty"`
+	State   string       `json:"state,omitempty"`
+	Stats   *ServerStats `json:"stats,omitempty"`
+	Players *PlayerList  `json:"players,omitempty"`
+	Command string       `json:"command,omitempty"`
+	Output  string       `json:"output,omitempty"`
+	Message string       `json:"message,omitempty"`
+}
+
+// dashboardAction is an inbound dashboard message: a console command, or
+// {"action":"subscribe"|"unsubscribe","channels":["stat"]}.
+type dashboardAction struct {
+	Action   string   `json:"action"`
+	Command  string   `json:"command"`
+	Channels []string `json:"channels"`
+}
+
+var dashboardChannels = map[string]bool{"log": true, "stat": true, "status": true, "player": true}
+
+// How often each polled channel is sampled.
+const (
+	dashboardStatusInterval = 5 * time.Second
+	dashboardStatInterval   = 2 * time.Second
+	dashboardPlayerInterval = 10 * time.Second
+)
+
+// dashboard is one /ws/dashboard session.
+type dashboard struct {
+	conn        *websocket.Conn
+	containerId string
+	writeMu     sync.Mutex
+
+	mu       sync.Mutex
+	channels map[string]bool
+}
+
+func (d *dashboard) send(msg DashboardMessage) error {
+	d.writeMu.Lock()
+	defer d.writeMu.Unlock()
+	d.conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+	return d.conn.WriteJSON(msg)
+}
+
+func (d *dashboard) enabled(channel string) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.channels[channel]
+}
+
+func (d *dashboard) setChannels(channels []string, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, c := range channels {
+		d.channels[c] = on
+	}
+}
+
+// dashboardHandler multiplexes a server's live console, resource stats,
+// state changes and player list over one socket, together with the console
+// command channel. The console comes from the server's consoleHub, shared
+// with /ws/console, and commands are echoed to every client of it. The channels query parameter (comma-separated, default
+// all) picks the initial channels; the client can change them later.
+func dashboardHandler(w http.ResponseWriter, r *http.Request) {
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	channels := map[string]bool{}
+	if v := r.URL.Query().Get("channels"); v != "" {
+		for _, c := range strings.Split(v, ",") {
+			if !dashboardChannels[c] {
+				writeError(w, http.StatusBadRequest, "Unknown channel "+strconv.Quote(c)+": use log, stat, status or player")
+				return
+			}
+			channels[c] = true
+		}
+	} else {
+		for c := range dashboardChannels {
+			channels[c] = true
+		}
+	}
+	if _, ok := inspectServer(w, r, containerId); !ok {
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer trackSession(conn)()
+	d := &dashboard{conn: conn, containerId: containerId, channels: channels}
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	startHeartbeat(conn, ctx.Done())
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		d.run(ctx)
+	}()
+
+	serverName := r.URL.Query().Get("serverName")
+	userEmail := ownerEmail(r, r.URL.Query().Get("userEmail"))
+	for {
+		var in dashboardAction
+		if err := conn.ReadJSON(&in); err != nil {
+			break
+		}
+		switch in.Action {
+		case "subscribe", "unsubscribe":
+			for _, c := range in.Channels {
+				if !dashboardChannels[c] {
+					d.send(DashboardMessage{Type: "error", Message: "Unknown channel " + strconv.Quote(c)})
+				}
+			}
+			d.setChannels(in.Channels, in.Action == "subscribe")
+		case "command":
+			command := strings.TrimSpace(in.Command)
+			if command == "" {
+				d.send(DashboardMessage{Type: "error", Message: "command is required"})
+				continue
+			}
+			if err := checkCommand(identityFrom(r.Context()), command); err != nil {
+				auditCommand(r, "dashboard", serverName, userEmail, command, auditRejected, err.Error())
+				d.send(DashboardMessage{Type: "error", Command: command, Message: "Forbidden: " + err.Error()})
+				continue
+			}
+			publishConsole(containerId, ConsoleMessage{Type: "command", Command: command})
+			out, err := runRcon(containerId, command)
+			if err != nil {
+				auditCommand(r, "dashboard", serverName, userEmail, command, auditFailed, out)
+				d.send(DashboardMessage{Type: "error", Command: command, Message: "Command failed: " + out})
+				continue
+			}
+			auditCommand(r, "dashboard", serverName, userEmail, command, auditOK, out)
+			d.send(DashboardMessage{Type: "result", Command: command, Output: out})
+		default:
+			d.send(DashboardMessage{Type: "error", Message: "Unknown action " + strconv.Quote(in.Action)})
+		}
+	}
+	cancel()
+	wg.Wait()
+}
+
+// run samples the polled channels and keeps the session subscribed to the
+// server's console while the log channel is on and the server is up, until
+// ctx is cancelled.
+func (d *dashboard) run(ctx context.Context) {
+	// sentState and sentPlayers are what the client last saw; they are reset
+	// when a channel is turned off so it gets the current value when it is
+	// turned back on.
+	var (
+		state       string
+		sentState   string
+		sentPlayers *PlayerList
+		lastStatus  time.Time
+		lastStat    time.Time
+		lastPlayers time.Time
+		console     *dashboardConsole
+	)
+	defer func() {
+		if console != nil {
+			console.stop()
+		}
+	}()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		if now.Sub(lastStatus) >= dashboardStatusInterval {
+			lastStatus = now
+			if info, err := inspectContainer(ctx, d.containerId); err == nil {
+				state = info.State.Status
+			}
+		}
+		if !d.enabled("status") {
+			sentState = ""
+		} else if state != sentState {
+			sentState = state
+			d.send(DashboardMessage{Type: "status", State: state})
+		}
+		running := state == "running"
+
+		// The hub detaches its clients when the server stops; the session
+		// joins again once the server is back up.
+		if console != nil && console.finished() {
+			// Most likely the server stopped; check on the next tick.
+			lastStatus = time.Time{}
+		}
+		if console != nil && (console.finished() || !running || !d.enabled("log")) {
+			console.stop()
+			console = nil
+		}
+		if console == nil && running && d.enabled("log") && !lastStatus.IsZero() {
+			console = d.joinConsole()
+		}
+
+		if running && d.enabled("stat") && now.Sub(lastStat) >= dashboardStatInterval {
+			lastStat = now
+			if stats, err := readStats(d.containerId); err == nil {
+				d.send(DashboardMessage{Type: "stat", Stats: &stats})
+			}
+		}
+		if !d.enabled("player") {
+			sentPlayers = nil
+			lastPlayers = time.Time{}
+		} else if running && now.Sub(lastPlayers) >= dashboardPlayerInterval {
+			lastPlayers = now
+			if list, err := listPlayers(d.containerId); err == nil && !reflect.DeepEqual(&list, sentPlayers) {
+				sentPlayers = &list
+				d.send(DashboardMessage{Type: "player", Players: sentPlayers})
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// dashboardConsole is a dashboard's subscription to the server's consoleHub.
+type dashboardConsole struct {
+	hub  *consoleHub
+	sub  *consoleSub
+	done chan struct{}
+}
+
+// joinConsole subscribes the session to the server's console, without a
+// backlog, and relays its lines and command echoes until it is detached.
+func (d *dashboard) joinConsole() *dashboardConsole {
+	hub, sub := joinConsole(d.containerId, 0)
+	c := &dashboardConsole{hub: hub, sub: sub, done: make(chan struct{})}
+	go func() {
+		defer close(c.done)
+		for msg := range sub.out {
+			switch msg.Type {
+			case "log":
+				d.send(DashboardMessage{Type: "log", Line: msg.Line})
+			case "command":
+				d.send(DashboardMessage{Type: "command", Command: msg.Command})
+			}
+		}
+	}()
+	return c
+}
+
+func (c *dashboardConsole) finished() bool {
+	select {
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// stop leaves the hub and waits for the relay to finish.
+func (c *dashboardConsole) stop() {
+	c.hub.leave(c.sub)
+	<-c.done
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/gorilla/websocket"
+)
+
+func TestDashboardSharesConsoleHub(t *testing.T) {
+	const containerId = "lobby--alice"
+	fakeDocker(t, map[string]types.ContainerJSON{containerId: runningContainer(nil)})
+	pidFile := fakeDockerCLI(t)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws/console", consoleHandler)
+	mux.HandleFunc("/ws/dashboard", dashboardHandler)
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	dial := func(path string) *websocket.Conn {
+		t.Helper()
+		conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+path+"?serverName=lobby&userEmail=alice@example.com&channels=log", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		return conn
+	}
+	console := dial("/ws/console")
+	var first ConsoleMessage
+	if err := console.ReadJSON(&first); err != nil {
+		t.Fatal(err)
+	}
+	dashboards := []*websocket.Conn{dial("/ws/dashboard"), dial("/ws/dashboard")}
+
+	// Wait for both dashboards to join the console client's hub.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		consoleHubsMu.Lock()
+		h := consoleHubs[containerId]
+		consoleHubsMu.Unlock()
+		subs := 0
+		if h != nil {
+			h.mu.Lock()
+			subs = len(h.subs)
+			h.mu.Unlock()
+		}
+		if subs == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("console hub has %d clients, want the console and both dashboards", subs)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	if data, err := os.ReadFile(pidFile); err != nil || strings.Count(string(data), "\n") != 1 {
+		t.Errorf("docker logs started %d times (%v), want one follower for every client", strings.Count(string(data), "\n"), err)
+	}
+
+	if err := dashboards[0].WriteJSON(dashboardAction{Action: "command", Command: "say hi"}); err != nil {
+		t.Fatal(err)
+	}
+	var echo ConsoleMessage
+	if err := console.ReadJSON(&echo); err != nil {
+		t.Fatal(err)
+	}
+	if echo.Type != "command" || echo.Command != "say hi" {
+		t.Errorf("console client got %+v, want the dashboard's command echoed", echo)
+	}
+	var other DashboardMessage
+	if err := dashboards[1].ReadJSON(&other); err != nil {
+		t.Fatal(err)
+	}
+	if other.Type != "command" || other.Command != "say hi" {
+		t.Errorf("other dashboard got %+v, want the command echoed", other)
+	}
+	// The sender sees the echo and the result, in either order.
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		var msg DashboardMessage
+		if err := dashboards[0].ReadJSON(&msg); err != nil {
+			t.Fatal(err)
+		}
+		got[msg.Type] = true
+		if msg.Type == "result" && !strings.Contains(msg.Output, "say hi") {
+			t.Errorf("result output = %q, want the RCON output", msg.Output)
+		}
+	}
+	if !got["command"] || !got["result"] {
+		t.Errorf("sending dashboard got %v, want its command echoed and the result", got)
+	}
+}
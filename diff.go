@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+)
+
+// FsChange is one line of `docker diff`.
+type FsChange struct {
+	Kind string `json:"kind"`
+	Path string `json:"path"`
+}
+
+type FsDiffResponse struct {
+	Status   string     `json:"status"`
+	Added    int        `json:"added"`
+	Modified int        `json:"modified"`
+	Deleted  int        `json:"deleted"`
+	Changes  []FsChange `json:"changes"`
+}
+
+var diffKinds = map[string]string{"A": "added", "C": "modified", "D": "deleted"}
+
+// serverDiffHandler reports files the container changed in its own writable
+// layer. Bind-mounted volumes aren't part of the diff, so everything listed is
+// data that will be lost when the container is recreated.
+func serverDiffHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	out, err := runDocker("diff", containerId)
+	if err != nil {
+		if strings.Contains(out, "No such") {
+			writeError(w, http.StatusNotFound, "Server not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to diff container: "+out)
+		return
+	}
+
+	resp := FsDiffResponse{Status: "ok", Changes: []FsChange{}}
+	for _, line := range strings.Split(out, "\n") {
+		code, path, ok := strings.Cut(strings.TrimSpace(line), " ")
+		kind, known := diffKinds[code]
+		if !ok || !known {
+			continue
+		}
+		switch code {
+		case "A":
+			resp.Added++
+		case "C":
+			resp.Modified++
+		case "D":
+			resp.Deleted++
+		}
+		resp.Changes = append(resp.Changes, FsChange{Kind: kind, Path: path})
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+type ServerDiskResponse struct {
+	Status        string `json:"status"`
+	Bytes         int64  `json:"bytes"`
+	HumanReadable string `json:"humanReadable"`
+	FileCount     int64  `json:"fileCount"`
+	// Quota is the server's storage limit in bytes, 0 when it has none.
+	Quota      int64     `json:"quota,omitempty"`
+	MeasuredAt time.Time `json:"measuredAt"`
+}
+
+// diskUsage is the measured size of a server's volume.
+type diskUsage struct {
+	bytes      int64
+	files      int64
+	measuredAt time.Time
+}
+
+// diskUsageCache keeps volume sizes for DISK_USAGE_TTL (default 30s), so
+// polling /server/disk or checking the quota on every write doesn't walk a
+// large world each time. Writes made through the agent adjust the cached
+// size; growth from the server itself shows up when the entry expires.
+type diskUsageCache struct {
+	mu      sync.Mutex
+	entries map[string]diskUsage
+}
+
+var diskCache = &diskUsageCache{entries: map[string]diskUsage{}}
+
+// usage returns the size of the server's volume, measuring it if the cached
+// value is missing or expired.
+func (c *diskUsageCache) usage(containerId string) (diskUsage, error) {
+	c.mu.Lock()
+	u, ok := c.entries[containerId]
+	c.mu.Unlock()
+	if ok && time.Since(u.measuredAt) < envDuration("DISK_USAGE_TTL", 30*time.Second) {
+		return u, nil
+	}
+	u, err := measureVolume(getServerDataDir(containerId))
+	if err != nil {
+		return diskUsage{}, err
+	}
+	c.mu.Lock()
+	c.entries[containerId] = u
+	c.mu.Unlock()
+	return u, nil
+}
+
+// grew records that the agent added delta bytes (negative when it freed
+// space) and newFiles files to the server's volume.
+func (c *diskUsageCache) grew(containerId string, delta, newFiles int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if u, ok := c.entries[containerId]; ok {
+		u.bytes += delta
+		u.files += newFiles
+		c.entries[containerId] = u
+	}
+}
+
+// forget drops the cached size, after changes too large to track by delta.
+func (c *diskUsageCache) forget(containerId string) {
+	c.mu.Lock()
+	delete(c.entries, containerId)
+	c.mu.Unlock()
+}
+
+// measureVolume sums the sizes and counts the regular files under root.
+func measureVolume(root string) (diskUsage, error) {
+	u := diskUsage{measuredAt: time.Now()}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			u.bytes += info.Size()
+			u.files++
+		}
+		return nil
+	})
+	return u, err
+}
+
+// humanBytes formats n in binary units, e.g. "1.5 GiB".
+func humanBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+// serverDiskHandler reports how much disk a server's volume uses.
+func serverDiskHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	u, err := diskCache.usage(containerId)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			writeError(w, http.StatusNotFound, "Server not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to measure server volume: "+err.Error())
+		return
+	}
+	quota, err := diskQuota(containerId)
+	if err != nil && !isNotFound(err) {
+		writeError(w, http.StatusInternalServerError, "Failed to read the server's disk quota: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ServerDiskResponse{
+		Status:        "ok",
+		Bytes:         u.bytes,
+		HumanReadable: humanBytes(u.bytes),
+		FileCount:     u.files,
+		Quota:         quota,
+		MeasuredAt:    u.measuredAt.UTC(),
+	})
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os/exec"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
+)
+
+// dockerClient talks to the Docker Engine API. It is created once at startup
+// by configureDocker and honours DOCKER_HOST, DOCKER_API_VERSION and the
+// other standard Docker environment variables.
+var dockerClient *client.Client
+
+func configureDocker() {
+	c, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	if err != nil {
+		fatal("Failed to create Docker client", "err", err)
+	}
+	dockerClient = c
+}
+
+// isNotFound reports whether a Docker API error means the container (or
+// image) doesn't exist.
+func isNotFound(err error) bool {
+	return errdefs.IsNotFound(err)
+}
+
+// runDocker runs the docker CLI and returns its trimmed combined output, so
+// callers can surface Docker's own error text on failure. It is only used for
+// what the API client doesn't cover as simply: exec, diff, pull and attach.
+func runDocker(args ...string) (string, error) {
+	out, err := exec.Command("docker", args...).CombinedOutput()
+	return strings.TrimSpace(string(out)), err
+}
+
+// runRcon sends one command to the server through the image's rcon-cli.
+func runRcon(containerId, command string) (string, error) {
+	return runDocker("exec", containerId, "rcon-cli", command)
+}
+
+// inspectContainer returns Docker's full description of the container.
+func inspectContainer(ctx context.Context, containerId string) (types.ContainerJSON, error) {
+	return dockerClient.ContainerInspect(ctx, containerId)
+}
+
+// containerEnv returns the environment the container was created with.
+func containerEnv(containerId string) (map[string]string, error) {
+	info, err := inspectContainer(context.Background(), containerId)
+	if err != nil {
+		return nil, err
+	}
+	env := map[string]string{}
+	for _, kv := range info.Config.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	return env, nil
+}
+
+// isRunning reports whether the container exists and is running.
+func isRunning(containerId string) bool {
+	info, err := inspectContainer(context.Background(), containerId)
+	return err == nil && info.State != nil && info.State.Running
+}
+
+// createContainer creates a stopped container.
+// createContainer creates the container name. Crashes recorded for an
+// earlier container of that name are forgotten.
+func createContainer(name string, config *container.Config, hostConfig *container.HostConfig) error {
+	_, err := dockerClient.ContainerCreate(context.Background(), config, hostConfig, nil, nil, name)
+	if err == nil {
+		crashes.reset(name)
+	}
+	return err
+}
+
+func startContainer(containerId string) error {
+	return dockerClient.ContainerStart(context.Background(), containerId, container.StartOptions{})
+}
+
+func removeContainer(containerId string, force bool) error {
+	return dockerClient.ContainerRemove(context.Background(), containerId, container.RemoveOptions{Force: force})
+}
+
+// containerLogs returns the container's stdout and stderr, interleaved as
+// they were written, with the trailing newline trimmed. The agent never
+// creates containers with a TTY, so the stream is always multiplexed.
+func containerLogs(ctx context.Context, containerId string, opts container.LogsOptions) (string, error) {
+	opts.ShowStdout = true
+	opts.ShowStderr = true
+	rc, err := dockerClient.ContainerLogs(ctx, containerId, opts)
+	if err != nil {
+		return "", err
+	}
+	defer rc.Close()
+	var buf bytes.Buffer
+	if _, err := stdcopy.StdCopy(&buf, &buf, rc); err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(buf.String()), nil
+}
+
+// streamDocker runs the docker CLI and calls onLine for every line it prints.
+// On failure the returned error carries the last line of output.
+func streamDocker(onLine func(string), args ...string) error {
+	return streamDockerContext(context.Background(), onLine, args...)
+}
+
+// streamDockerContext is streamDocker for commands that run until the caller
+// is done with them, such as docker attach: cancelling ctx kills the docker
+// process. It only returns once the process has exited and been reaped, so
+// nothing is left behind however the stream ends.
+func streamDockerContext(ctx context.Context, onLine func(string), args ...string) error {
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	// Don't let a child that inherited the output pipe keep Wait from
+	// returning after the kill.
+	cmd.WaitDelay = 5 * time.Second
+	pr, pw := io.Pipe()
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	waited := make(chan error, 1)
+	go func() {
+		err := cmd.Wait()
+		pw.CloseWithError(err)
+		waited <- err
+	}()
+
+	last := ""
+	sc := bufio.NewScanner(pr)
+	sc.Buffer(make([]byte, 64*1024), maxStreamLine)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			last = line
+			onLine(line)
+		}
+	}
+	scanErr := sc.Err()
+	// If scanning stopped early (an over-long line), drain the rest so the
+	// process can't block on a full pipe, then reap it.
+	io.Copy(io.Discard, pr)
+	if err := <-waited; err != nil {
+		if last != "" {
+			return errors.New(last)
+		}
+		return err
+	}
+	return scanErr
+}
+
+// maxStreamLine is the longest output line streamDocker passes on.
+const maxStreamLine = 1 << 20
+
+// credentialsInURL matches the user:password@ part of a URL.
+var credentialsInURL = regexp.MustCompile(`(://)[^/@\s]+:[^/@\s]+@`)
+
+// redactCredentials hides credentials embedded in registry URLs in docker
+// output before it is stored or returned.
+func redactCredentials(s string) string {
+	return credentialsInURL.ReplaceAllString(s, "${1}***@")
+}
+
+// classifyPullError maps the output of a failed docker pull onto an HTTP
+// status and a message for the client: 502 when the registry rejected our
+// credentials, 404 when the image doesn't exist, 503 when the registry
+// couldn't be reached, and 500 otherwise.
+func classifyPullError(image, out string) (int, string) {
+	lower := strings.ToLower(out)
+	has := func(subs ...string) bool {
+		for _, sub := range subs {
+			if strings.Contains(lower, sub) {
+				return true
+			}
+		}
+		return false
+	}
+	switch {
+	case has("pull access denied"):
+		// Docker Hub answers this both for private and missing repositories.
+		return http.StatusBadGateway, "Registry authentication failed for " + image + " (or the repository does not exist)"
+	case has("unauthorized", "authentication required", "no basic auth credentials",
+		"incorrect username or password", "denied: ", "403 forbidden"):
+		// The raw output is left out: it can echo the registry's auth realm
+		// and the credentials helper's complaints.
+		return http.StatusBadGateway, "Registry authentication failed for " + image + ": check the registry credentials configured for docker"
+	case has("manifest unknown", "not found", "repository does not exist", "invalid reference format"):
+		return http.StatusNotFound, "Image " + image + " not found: " + redactCredentials(out)
+	case has("dial tcp", "i/o timeout", "tls handshake timeout", "no such host", "connection refused",
+		"network is unreachable", "client.timeout", "temporary failure in name resolution", "connection reset"):
+		return http.StatusServiceUnavailable, "Registry unreachable while pulling " + image + ": " + redactCredentials(out)
+	}
+	return http.StatusInternalServerError, "Failed to pull image: " + redactCredentials(out)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/client"
+)
+
+// runningContainer is the inspect result of a running server.
+func runningContainer(labels map[string]string) types.ContainerJSON {
+	return types.ContainerJSON{
+		ContainerJSONBase: &types.ContainerJSONBase{
+			State:      &types.ContainerState{Status: "running", Running: true},
+			HostConfig: &container.HostConfig{},
+		},
+		Config: &container.Config{Labels: labels},
+	}
+}
+
+var apiVersionPrefix = regexp.MustCompile(`^/v[0-9.]+`)
+
+// fakeDocker points dockerClient at an Engine API that knows only
+// containers, keyed by name, for the rest of the test. It answers inspect
+// and list; anything else is a 404.
+func fakeDocker(t *testing.T, containers map[string]types.ContainerJSON) {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path := apiVersionPrefix.ReplaceAllString(r.URL.Path, "")
+		w.Header().Set("Content-Type", "application/json")
+		if path == "/containers/json" {
+			list := []types.Container{}
+			for name, c := range containers {
+				list = append(list, types.Container{ID: name, Names: []string{"/" + name}, Labels: c.Config.Labels, State: c.State.Status})
+			}
+			json.NewEncoder(w).Encode(list)
+			return
+		}
+		if name, ok := strings.CutSuffix(strings.TrimPrefix(path, "/containers/"), "/json"); ok {
+			if c, ok := containers[name]; ok {
+				json.NewEncoder(w).Encode(c)
+				return
+			}
+		}
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"message": "No such container"})
+	}))
+	t.Cleanup(srv.Close)
+
+	c, err := client.NewClientWithOpts(client.WithHost("tcp://"+srv.Listener.Addr().String()), client.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := dockerClient
+	dockerClient = c
+	t.Cleanup(func() { dockerClient = saved })
+}
+
+// unreachableDocker points dockerClient at a port nothing listens on for the
+// rest of the test, so every call fails with a connection error.
+func unreachableDocker(t *testing.T) {
+	t.Helper()
+	c, err := client.NewClientWithOpts(client.WithHost("tcp://127.0.0.1:1"), client.WithVersion("1.45"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := dockerClient
+	dockerClient = c
+	t.Cleanup(func() { dockerClient = saved })
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"log/slog"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// dockerEvent is the subset of `docker events --format '{{json .}}'` we use.
+// Attributes include the container's name and labels.
+type dockerEvent struct {
+	Action string `json:"Action"`
+	Actor  struct {
+		Attributes map[string]string `json:"Attributes"`
+	} `json:"Actor"`
+}
+
+// startEventMonitor follows start/die events of the containers this agent
+// manages in the background, restarting the stream if the daemon goes away.
+func startEventMonitor() {
+	go func() {
+		for {
+			if err := followEvents(); err != nil {
+				slog.Warn("Event monitor: docker events stopped", "err", err)
+			}
+			time.Sleep(5 * time.Second)
+		}
+	}()
+}
+
+func followEvents() error {
+	cmd := exec.Command("docker", "events",
+		"--filter", "type=container",
+		"--filter", "event=start",
+		"--filter", "event=die",
+		"--filter", "label="+managedLabel,
+		"--format", "{{json .}}")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	sc := bufio.NewScanner(stdout)
+	for sc.Scan() {
+		var ev dockerEvent
+		if err := json.Unmarshal(sc.Bytes(), &ev); err != nil {
+			continue
+		}
+		attrs := ev.Actor.Attributes
+		switch ev.Action {
+		case "start":
+			applyBandwidthLimits(attrs["name"], attrs[bandwidthIngressLabel], attrs[bandwidthEgressLabel])
+		case "die":
+			code, _ := strconv.Atoi(attrs["exitCode"])
+			crashes.handleExit(attrs["name"], code)
+		}
+	}
+	return cmd.Wait()
+}
@@ -0,0 +1,230 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// FileContentResponse is returned when reading a file for the editor.
+type FileContentResponse struct {
+	Status  string    `json:"status"`
+	Path    string    `json:"path"`
+	Content string    `json:"content"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+	ETag    string    `json:"etag"`
+}
+
+type WriteFileRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Path       string `json:"path"`
+	Content    string `json:"content"`
+	// ExpectedModTime, if set, makes the write fail with 412 unless the file
+	// still has this modification time. The If-Match header does the same
+	// with the ETag from the last read.
+	ExpectedModTime *time.Time `json:"expectedModTime,omitempty"`
+}
+
+type WriteFileResponse struct {
+	Status  string    `json:"status"`
+	Message string    `json:"message"`
+	ModTime time.Time `json:"modTime"`
+	ETag    string    `json:"etag"`
+}
+
+// maxEditableFileSize is the largest file served to the editor; bigger files
+// should be downloaded instead.
+const maxEditableFileSize = 5 << 20
+
+// fileETag is a strong ETag for the file's content.
+func fileETag(data []byte) string {
+	sum := sha256.Sum256(data)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// fileManagerHandler reads (GET) and writes (POST) single files in a server's
+// volume for the web editor. Reads return an ETag and answer 304 to a
+// matching If-None-Match; writes honour If-Match and expectedModTime so
+// concurrent edits aren't silently lost.
+func fileManagerHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		readFile(w, r)
+	case http.MethodPost:
+		writeFile(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func readFile(w http.ResponseWriter, r *http.Request) {
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	rel := r.URL.Query().Get("path")
+	path, err := resolveServerPath(containerId, rel)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read file: "+err.Error())
+		return
+	}
+	if info.IsDir() {
+		writeError(w, http.StatusBadRequest, "Path is a directory, not a file")
+		return
+	}
+	if info.Size() > maxEditableFileSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "File is too large to edit; download it instead")
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read file: "+err.Error())
+		return
+	}
+	etag := fileETag(data)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && (strings.TrimSpace(inm) == "*" || etagMatches(strings.ReplaceAll(inm, "W/", ""), etag)) {
+		// The editor already has this version.
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	writeJSON(w, http.StatusOK, FileContentResponse{
+		Status:  "ok",
+		Path:    rel,
+		Content: string(data),
+		Size:    info.Size(),
+		ModTime: info.ModTime().UTC(),
+		ETag:    etag,
+	})
+}
+
+func writeFile(w http.ResponseWriter, r *http.Request) {
+	var req WriteFileRequest
+	// The content may be up to MAX_FILE_SIZE, plus room for JSON escaping.
+	if err := decodeJSONBodyLimit(r, &req, maxFileSize()+maxFileSize()/8+maxJSONBody()); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.Path == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and path are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "file write")
+	if !ok {
+		return
+	}
+	defer unlock()
+	path, err := resolveServerPath(containerId, req.Path)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if path == getServerDataDir(containerId) {
+		writeError(w, http.StatusBadRequest, "Path is a directory, not a file")
+		return
+	}
+	if int64(len(req.Content)) > maxFileSize() {
+		writeFileOpError(w, errFileTooLarge())
+		return
+	}
+	// The server lock makes the precondition check and the write one step,
+	// so two editors saving at once can't both pass the check.
+	ifMatch := strings.TrimSpace(r.Header.Get("If-Match"))
+	perm := os.FileMode(0644)
+	// Only what the write adds counts against the quota.
+	var oldSize int64
+	newFiles := int64(1)
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && info.IsDir():
+		writeError(w, http.StatusBadRequest, "Path is a directory, not a file")
+		return
+	case err == nil:
+		perm = info.Mode().Perm()
+		oldSize, newFiles = info.Size(), 0
+		if ifMatch != "" && ifMatch != "*" {
+			current, err := os.ReadFile(path)
+			if err != nil {
+				writeError(w, http.StatusInternalServerError, "Failed to read file: "+err.Error())
+				return
+			}
+			if etag := fileETag(current); !etagMatches(ifMatch, etag) {
+				w.Header().Set("ETag", etag)
+				writeError(w, http.StatusPreconditionFailed, "File changed since it was read")
+				return
+			}
+		}
+		if req.ExpectedModTime != nil && !info.ModTime().Equal(*req.ExpectedModTime) {
+			writeError(w, http.StatusPreconditionFailed, "File changed since it was read (modified "+info.ModTime().UTC().Format(time.RFC3339Nano)+")")
+			return
+		}
+	case errors.Is(err, os.ErrNotExist):
+		// A precondition on a file that doesn't exist can't hold.
+		if ifMatch != "" || req.ExpectedModTime != nil {
+			writeError(w, http.StatusPreconditionFailed, "File no longer exists")
+			return
+		}
+	default:
+		writeError(w, http.StatusInternalServerError, "Failed to write file: "+err.Error())
+		return
+	}
+
+	data := []byte(req.Content)
+	if err := checkDiskQuota(containerId, int64(len(data))-oldSize); err != nil {
+		writeFileOpError(w, err)
+		return
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to create parent directory: "+err.Error())
+		return
+	}
+	if err := writeFileAtomic(path, data, perm); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to write file: "+err.Error())
+		return
+	}
+	diskCache.grew(containerId, int64(len(data))-oldSize, newFiles)
+	info, err = os.Stat(path)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to write file: "+err.Error())
+		return
+	}
+	etag := fileETag(data)
+	w.Header().Set("ETag", etag)
+	writeJSON(w, http.StatusOK, WriteFileResponse{Status: "ok", Message: "File saved", ModTime: info.ModTime().UTC(), ETag: etag})
+}
+
+// etagMatches reports whether an If-Match header lists etag. Weak validators
+// never match, as If-Match requires strong comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimSpace(candidate) == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,822 @@
+package main
+
+import (
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileEntry is one item of a directory listing.
+type FileEntry struct {
+	Name    string    `json:"name"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// listDirHandler lists a directory in a server's volume, directories first and
+// then files, each group sorted by name.
+func listDirHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	dir, err := resolveServerPath(containerId, r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "Directory not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read directory: "+err.Error())
+		return
+	}
+	if !info.IsDir() {
+		writeError(w, http.StatusBadRequest, "Path is a file, not a directory")
+		return
+	}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read directory: "+err.Error())
+		return
+	}
+	entries := make([]FileEntry, 0, len(dirEntries))
+	for _, d := range dirEntries {
+		fi, err := d.Info()
+		if err != nil {
+			// Removed between ReadDir and Info.
+			continue
+		}
+		entries = append(entries, FileEntry{
+			Name:    d.Name(),
+			IsDir:   d.IsDir(),
+			Size:    fi.Size(),
+			ModTime: fi.ModTime().UTC(),
+		})
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].IsDir != entries[j].IsDir {
+			return entries[i].IsDir
+		}
+		return strings.ToLower(entries[i].Name) < strings.ToLower(entries[j].Name)
+	})
+	writeJSON(w, http.StatusOK, entries)
+}
+
+// FileStat describes a single file or directory in a server's volume.
+type FileStat struct {
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	Mode    string    `json:"mode"`
+	ModTime time.Time `json:"modTime"`
+	IsDir   bool      `json:"isDir"`
+}
+
+// fileStatHandler returns a file's metadata without its content, so the UI
+// can show the size of a large file before downloading it. Symlinks are
+// described rather than followed.
+func fileStatHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	path, err := resolveServerPath(containerId, r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	info, err := os.Lstat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to stat file: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, FileStat{
+		Name:    info.Name(),
+		Size:    info.Size(),
+		Mode:    fmt.Sprintf("%04o", info.Mode().Perm()),
+		ModTime: info.ModTime().UTC(),
+		IsDir:   info.IsDir(),
+	})
+}
+
+// fileOpError is a failed file operation with the status code to answer it
+// with, so the single-file endpoints and /file/batch report it the same way.
+type fileOpError struct {
+	code    int
+	message string
+}
+
+func (e *fileOpError) Error() string { return e.message }
+
+func fileOpErrorf(code int, format string, args ...interface{}) error {
+	return &fileOpError{code: code, message: fmt.Sprintf(format, args...)}
+}
+
+// writeFileOpError answers with the status of a fileOpError, or 500.
+func writeFileOpError(w http.ResponseWriter, err error) {
+	var opErr *fileOpError
+	if errors.As(err, &opErr) {
+		writeError(w, opErr.code, opErr.message)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err.Error())
+}
+
+type RenameFileRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Overwrite  bool   `json:"overwrite"`
+}
+
+// renameFileHandler moves a file or directory within a server's volume.
+func renameFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req RenameFileRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.From == "" || req.To == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail, from and to are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "file rename")
+	if !ok {
+		return
+	}
+	defer unlock()
+	if err := renamePath(containerId, req.From, req.To, req.Overwrite); err != nil {
+		writeFileOpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok", Message: "Renamed " + req.From + " to " + req.To})
+}
+
+// renamePath moves fromRel to toRel within the server's volume.
+func renamePath(containerId, fromRel, toRel string, overwrite bool) error {
+	base := getServerDataDir(containerId)
+	from, err := resolveServerEntry(containerId, fromRel)
+	if err != nil {
+		return fileOpErrorf(http.StatusBadRequest, "Invalid from path: %v", err)
+	}
+	to, err := resolveServerEntry(containerId, toRel)
+	if err != nil {
+		return fileOpErrorf(http.StatusBadRequest, "Invalid to path: %v", err)
+	}
+	if from == base || to == base {
+		return fileOpErrorf(http.StatusBadRequest, "Cannot rename the server root directory")
+	}
+
+	if _, err := os.Lstat(from); errors.Is(err, os.ErrNotExist) {
+		return fileOpErrorf(http.StatusNotFound, "Source does not exist")
+	}
+	if _, err := os.Lstat(to); err == nil && !overwrite {
+		return fileOpErrorf(http.StatusConflict, "Destination already exists")
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return fileOpErrorf(http.StatusInternalServerError, "Failed to create destination directory: %v", err)
+	}
+	if err := os.Rename(from, to); err != nil {
+		return fileOpErrorf(http.StatusInternalServerError, "Failed to rename: %v", err)
+	}
+	return nil
+}
+
+type CopyFileRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	From       string `json:"from"`
+	To         string `json:"to"`
+	Overwrite  bool   `json:"overwrite"`
+}
+
+// copyFileHandler duplicates a file, or a directory tree, within a server's
+// volume. File modes are kept; symlinks are skipped rather than followed.
+func copyFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req CopyFileRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.From == "" || req.To == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail, from and to are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "file copy")
+	if !ok {
+		return
+	}
+	defer unlock()
+	copied, err := copyPath(containerId, req.From, req.To, req.Overwrite)
+	if err != nil {
+		writeFileOpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok", Message: fmt.Sprintf("Copied %s to %s (%d files)", req.From, req.To, copied)})
+}
+
+// copyPath copies the file or directory fromRel to toRel within the server's
+// volume and returns the number of files copied.
+func copyPath(containerId, fromRel, toRel string, overwrite bool) (int, error) {
+	base := getServerDataDir(containerId)
+	from, err := resolveServerPath(containerId, fromRel)
+	if err != nil {
+		return 0, fileOpErrorf(http.StatusBadRequest, "Invalid from path: %v", err)
+	}
+	to, err := resolveServerPath(containerId, toRel)
+	if err != nil {
+		return 0, fileOpErrorf(http.StatusBadRequest, "Invalid to path: %v", err)
+	}
+	if to == base {
+		return 0, fileOpErrorf(http.StatusBadRequest, "Cannot copy onto the server root directory")
+	}
+
+	src, err := os.Lstat(from)
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, fileOpErrorf(http.StatusNotFound, "Source does not exist")
+	}
+	if err != nil || (!src.IsDir() && !src.Mode().IsRegular()) {
+		return 0, fileOpErrorf(http.StatusBadRequest, "Source must be a regular file or directory")
+	}
+	if src.IsDir() {
+		if rel, err := filepath.Rel(from, to); err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return 0, fileOpErrorf(http.StatusBadRequest, "Cannot copy a directory into itself")
+		}
+	}
+	if dst, err := os.Lstat(to); err == nil {
+		if dst.IsDir() != src.IsDir() {
+			return 0, fileOpErrorf(http.StatusConflict, "Cannot copy a file onto a directory or a directory onto a file")
+		}
+		if !overwrite {
+			return 0, fileOpErrorf(http.StatusConflict, "Destination already exists")
+		}
+	}
+	adding, err := copySize(from, to, src)
+	if err != nil {
+		return 0, err
+	}
+	if err := checkDiskQuota(containerId, adding); err != nil {
+		return 0, err
+	}
+	if err := os.MkdirAll(filepath.Dir(to), 0755); err != nil {
+		return 0, fileOpErrorf(http.StatusInternalServerError, "Failed to create destination directory: %v", err)
+	}
+
+	copied := 1
+	if src.IsDir() {
+		copied, err = copyTree(from, to)
+	} else {
+		err = copyFile(from, to, src.Mode().Perm())
+	}
+	diskCache.forget(containerId)
+	if err != nil {
+		return 0, fileOpErrorf(http.StatusInternalServerError, "Failed to copy: %v", err)
+	}
+	return copied, nil
+}
+
+// copySize is how much copying from to to grows the volume: the size of the
+// regular files copyTree or copyFile would write, less that of the files they
+// replace. It fails with 413 when one of them is over MAX_FILE_SIZE.
+func copySize(from, to string, src fs.FileInfo) (int64, error) {
+	var adding int64
+	add := func(target string, info fs.FileInfo) error {
+		if info.Size() > maxFileSize() {
+			return errFileTooLarge()
+		}
+		adding += info.Size()
+		if old, err := os.Lstat(target); err == nil && old.Mode().IsRegular() {
+			adding -= old.Size()
+		}
+		return nil
+	}
+	if !src.IsDir() {
+		return adding, add(to, src)
+	}
+	err := filepath.WalkDir(from, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(from, path)
+		if err != nil {
+			return err
+		}
+		return add(filepath.Join(to, rel), info)
+	})
+	var opErr *fileOpError
+	if err != nil && !errors.As(err, &opErr) {
+		return 0, fileOpErrorf(http.StatusInternalServerError, "Failed to measure source: %v", err)
+	}
+	return adding, err
+}
+
+// copyTree copies the directories and regular files under src to dst, merging
+// into dst if it already exists, and returns the number of files copied.
+func copyTree(src, dst string) (int, error) {
+	copied := 0
+	err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			// A symlinked directory in an existing dst would take the copy
+			// out of the volume.
+			if t, err := os.Lstat(target); err == nil && t.Mode()&fs.ModeSymlink != 0 {
+				return fmt.Errorf("%s is a symlink", rel)
+			}
+			if err := os.MkdirAll(target, info.Mode().Perm()); err != nil {
+				return err
+			}
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		if err := copyFile(path, target, info.Mode().Perm()); err != nil {
+			if errors.Is(err, errNotRegular) {
+				// Replaced by a symlink since the walk saw it.
+				return nil
+			}
+			return err
+		}
+		copied++
+		return nil
+	})
+	return copied, err
+}
+
+var errNotRegular = errors.New("not a regular file")
+
+// openRegular opens path for reading if it is a regular file. The check is
+// made on the open file, and a symlink at path isn't followed, so the game
+// server can't swap in a link between a check and the open. O_NONBLOCK keeps
+// the open of a FIFO from hanging.
+func openRegular(path string) (*os.File, fs.FileInfo, error) {
+	f, err := os.OpenFile(path, os.O_RDONLY|syscall.O_NOFOLLOW|syscall.O_NONBLOCK, 0)
+	if errors.Is(err, syscall.ELOOP) {
+		return nil, nil, errNotRegular
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err == nil && !info.Mode().IsRegular() {
+		err = errNotRegular
+	}
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	return f, info, nil
+}
+
+// copyFile copies src to dst, replacing dst, and gives it the mode perm. A
+// symlink at dst is replaced rather than written through.
+func copyFile(src, dst string, perm os.FileMode) error {
+	in, _, err := openRegular(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	if info, err := os.Lstat(dst); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		if err := os.Remove(dst); err != nil {
+			return err
+		}
+	}
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	if err := out.Close(); err != nil {
+		return err
+	}
+	return os.Chmod(dst, perm)
+}
+
+type DeleteFileRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Path       string `json:"path"`
+	// Recursive allows deleting non-empty directories.
+	Recursive bool `json:"recursive"`
+}
+
+func deleteFileHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req DeleteFileRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.Path == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and path are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "file delete")
+	if !ok {
+		return
+	}
+	defer unlock()
+	removed, err := deletePath(containerId, req.Path, req.Recursive)
+	if err != nil {
+		writeFileOpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok", Message: fmt.Sprintf("Deleted %s (%d entries removed)", req.Path, removed)})
+}
+
+// deletePath removes rel from the server's volume and returns the number of
+// entries removed. Non-empty directories need recursive.
+func deletePath(containerId, rel string, recursive bool) (int, error) {
+	path, err := resolveServerEntry(containerId, rel)
+	if err != nil {
+		return 0, fileOpErrorf(http.StatusBadRequest, "%v", err)
+	}
+	if path == getServerDataDir(containerId) {
+		return 0, fileOpErrorf(http.StatusBadRequest, "Cannot delete the server root directory")
+	}
+	if _, err := os.Lstat(path); errors.Is(err, os.ErrNotExist) {
+		return 0, fileOpErrorf(http.StatusNotFound, "File not found")
+	}
+
+	removed := 1
+	if recursive {
+		removed = countEntries(path)
+		err = os.RemoveAll(path)
+	} else {
+		err = os.Remove(path)
+	}
+	diskCache.forget(containerId)
+	if errors.Is(err, syscall.ENOTEMPTY) || errors.Is(err, syscall.EEXIST) {
+		return 0, fileOpErrorf(http.StatusConflict, "Directory not empty; set recursive to delete it")
+	}
+	if err != nil {
+		return 0, fileOpErrorf(http.StatusInternalServerError, "Failed to delete: %v", err)
+	}
+	return removed, nil
+}
+
+// countEntries returns the number of files and directories at and below path,
+// without following symlinks.
+func countEntries(path string) int {
+	n := 0
+	filepath.WalkDir(path, func(_ string, _ fs.DirEntry, err error) error {
+		if err == nil {
+			n++
+		}
+		return nil
+	})
+	return n
+}
+
+type MkdirRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Path       string `json:"path"`
+}
+
+// mkdirHandler creates a directory (and any missing parents). It succeeds if
+// the directory already exists.
+func mkdirHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req MkdirRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.Path == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and path are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "mkdir")
+	if !ok {
+		return
+	}
+	defer unlock()
+	if err := makeDir(containerId, req.Path); err != nil {
+		writeFileOpError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok"})
+}
+
+// makeDir creates rel and any missing parents in the server's volume.
+func makeDir(containerId, rel string) error {
+	path, err := resolveServerPath(containerId, rel)
+	if err != nil {
+		return fileOpErrorf(http.StatusBadRequest, "%v", err)
+	}
+	if info, err := os.Stat(path); err == nil && !info.IsDir() {
+		return fileOpErrorf(http.StatusConflict, "A file already exists at %s", rel)
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		if errors.Is(err, syscall.ENOTDIR) {
+			return fileOpErrorf(http.StatusConflict, "A parent of %s is a file", rel)
+		}
+		return fileOpErrorf(http.StatusInternalServerError, "Failed to create directory: %v", err)
+	}
+	return nil
+}
+
+type MultiDownloadRequest struct {
+	ServerName string   `json:"serverName"`
+	UserEmail  string   `json:"userEmail"`
+	Paths      []string `json:"paths"`
+}
+
+// fileDownloadHandler sends one file of a server's volume as an attachment.
+func fileDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	path, err := resolveServerPath(containerId, r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	serveAttachment(w, r, path)
+}
+
+// serveAttachment sends the regular file at path as an attachment, typed by
+// its extension. http.ServeContent sets Content-Length and handles Range
+// requests, so large downloads show progress and can be resumed.
+func serveAttachment(w http.ResponseWriter, r *http.Request, path string) {
+	f, info, err := openRegular(path)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "File not found")
+		return
+	}
+	if errors.Is(err, errNotRegular) {
+		writeError(w, http.StatusBadRequest, "Path is not a regular file")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to open file: "+err.Error())
+		return
+	}
+	defer f.Close()
+
+	contentType := mime.TypeByExtension(filepath.Ext(info.Name()))
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": info.Name()}))
+	http.ServeContent(w, r, info.Name(), info.ModTime(), f)
+}
+
+// maxDownloadPaths bounds how many paths one multi-file download may name.
+const maxDownloadPaths = 1000
+
+// downloadManifestName is added to a multi-file zip listing anything that
+// couldn't be included.
+const downloadManifestName = "MISSING_FILES.txt"
+
+// multiDownloadHandler streams several files (or directories) from a server's
+// volume as one zip, keeping their paths relative to the volume root.
+func multiDownloadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req MultiDownloadRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Paths) == 0 || len(req.Paths) > maxDownloadPaths {
+		writeError(w, http.StatusBadRequest, "paths must contain between 1 and 1000 entries")
+		return
+	}
+
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	base := getServerDataDir(containerId)
+	// Validate everything up front; once the zip starts streaming we can no
+	// longer change the status code.
+	resolved := make([]string, 0, len(req.Paths))
+	seen := map[string]bool{}
+	for _, p := range req.Paths {
+		full, err := resolveServerPath(containerId, p)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid path "+p+": "+err.Error())
+			return
+		}
+		if full == base {
+			writeError(w, http.StatusBadRequest, "Invalid path "+p+": use a subdirectory, not the server root")
+			return
+		}
+		if !seen[full] {
+			seen[full] = true
+			resolved = append(resolved, full)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", `attachment; filename="`+containerId+`-files.zip"`)
+
+	zw := zip.NewWriter(w)
+	var missing []string
+	for _, full := range resolved {
+		rel, _ := filepath.Rel(base, full)
+		info, err := os.Lstat(full)
+		if err != nil || info.Mode()&fs.ModeSymlink != 0 {
+			missing = append(missing, filepath.ToSlash(rel))
+			continue
+		}
+		if !info.IsDir() {
+			if err := addFileToZip(zw, full, filepath.ToSlash(rel), info); err != nil {
+				missing = append(missing, filepath.ToSlash(rel))
+			}
+			continue
+		}
+		filepath.WalkDir(full, func(path string, d fs.DirEntry, err error) error {
+			entry, _ := filepath.Rel(base, path)
+			entry = filepath.ToSlash(entry)
+			if err != nil {
+				missing = append(missing, entry)
+				return nil
+			}
+			// Symlinks could point outside the volume, so never follow them.
+			if d.IsDir() || d.Type()&fs.ModeSymlink != 0 {
+				return nil
+			}
+			fi, err := d.Info()
+			if err == nil {
+				err = addFileToZip(zw, path, entry, fi)
+			}
+			if err != nil {
+				missing = append(missing, entry)
+			}
+			return nil
+		})
+	}
+
+	if len(missing) > 0 {
+		hdr := &zip.FileHeader{Name: downloadManifestName, Method: zip.Deflate, Modified: time.Now()}
+		if mw, err := zw.CreateHeader(hdr); err == nil {
+			io.WriteString(mw, "The following paths were missing or unreadable and were skipped:\n")
+			io.WriteString(mw, strings.Join(missing, "\n")+"\n")
+		}
+	}
+	if err := zw.Close(); err != nil {
+		requestLogger(r).Error("Multi-file download failed", "server", containerId, "err", err)
+	}
+}
+
+// writeFileAtomic replaces path with data via a temporary file in the same
+// directory, so readers never see a partially written file.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func addFileToZip(zw *zip.Writer, path, name string, info fs.FileInfo) error {
+	f, _, err := openRegular(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hdr, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return err
+	}
+	hdr.Name = name
+	hdr.Method = zip.Deflate
+	fw, err := zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(fw, f)
+	return err
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestOpenRegular(t *testing.T) {
+	dir := t.TempDir()
+	outside := filepath.Join(t.TempDir(), "secret.txt")
+	if err := os.WriteFile(outside, []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(dir, "level.dat")
+	if err := os.WriteFile(file, []byte("world"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	link := filepath.Join(dir, "escape")
+	if err := os.Symlink(outside, link); err != nil {
+		t.Fatal(err)
+	}
+	fifo := filepath.Join(dir, "fifo")
+	if err := syscall.Mkfifo(fifo, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	f, info, err := openRegular(file)
+	if err != nil {
+		t.Fatalf("openRegular(file) error = %v", err)
+	}
+	f.Close()
+	if info.Name() != "level.dat" || info.Size() != 5 {
+		t.Errorf("openRegular(file) info = %s, %d bytes", info.Name(), info.Size())
+	}
+	// A FIFO would block a plain open until something writes to it.
+	for _, path := range []string{link, fifo, dir} {
+		if f, _, err := openRegular(path); !errors.Is(err, errNotRegular) {
+			if f != nil {
+				f.Close()
+			}
+			t.Errorf("openRegular(%s) error = %v, want errNotRegular", filepath.Base(path), err)
+		}
+	}
+	if _, _, err := openRegular(filepath.Join(dir, "missing")); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("openRegular(missing) error = %v, want ErrNotExist", err)
+	}
+
+	for path, want := range map[string]int{file: http.StatusOK, link: http.StatusBadRequest, fifo: http.StatusBadRequest, filepath.Join(dir, "missing"): http.StatusNotFound} {
+		rec := httptest.NewRecorder()
+		serveAttachment(rec, httptest.NewRequest(http.MethodGet, "/file/download", nil), path)
+		if rec.Code != want {
+			t.Errorf("serveAttachment(%s) status = %d, want %d", filepath.Base(path), rec.Code, want)
+		}
+		if rec.Body.String() == "secret" {
+			t.Errorf("serveAttachment(%s) sent the file outside the volume", filepath.Base(path))
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	defaultTreeDepth = 16
+	maxTreeDepth     = 64
+	// treeBatchSize and treeFlushInterval control how entries are grouped
+	// into messages: whichever limit is hit first sends a batch.
+	treeBatchSize     = 200
+	treeFlushInterval = 100 * time.Millisecond
+)
+
+// TreeEntry is one file or directory found while walking, with a path
+// relative to the requested root.
+type TreeEntry struct {
+	Path    string    `json:"path"`
+	IsDir   bool      `json:"isDir"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"modTime"`
+}
+
+// TreeMessage is sent over /ws/file-tree. Type is "entries" while walking,
+// then a final "done" (or "error").
+type TreeMessage struct {
+	Type     string      `json:"type"`
+	Entries  []TreeEntry `json:"entries,omitempty"`
+	Count    int         `json:"count,omitempty"`
+	Canceled bool        `json:"canceled,omitempty"`
+	Message  string      `json:"message,omitempty"`
+}
+
+// fileTreeHandler walks a directory of a server's volume and streams what it
+// finds in batches, so a file browser can render huge trees progressively.
+// The client can stop the walk by sending {"action":"cancel"} or closing the
+// socket.
+func fileTreeHandler(w http.ResponseWriter, r *http.Request) {
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	root, err := resolveServerPath(containerId, r.URL.Query().Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	maxDepth := defaultTreeDepth
+	if v := r.URL.Query().Get("maxDepth"); v != "" {
+		maxDepth, err = strconv.Atoi(v)
+		if err != nil || maxDepth < 1 || maxDepth > maxTreeDepth {
+			writeError(w, http.StatusBadRequest, "maxDepth must be between 1 and "+strconv.Itoa(maxTreeDepth))
+			return
+		}
+	}
+	if info, err := os.Stat(root); err != nil || !info.IsDir() {
+		writeError(w, http.StatusNotFound, "Directory not found")
+		return
+	}
+
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	defer trackSession(conn)()
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	startHeartbeat(conn, ctx.Done())
+	var canceled atomic.Bool
+	go func() {
+		defer cancel()
+		for {
+			var msg struct {
+				Action string `json:"action"`
+			}
+			if err := conn.ReadJSON(&msg); err != nil {
+				return
+			}
+			if msg.Action == "cancel" {
+				canceled.Store(true)
+				return
+			}
+		}
+	}()
+
+	send := func(msg TreeMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(wsWriteTimeout))
+		return conn.WriteJSON(msg)
+	}
+
+	count := 0
+	batch := make([]TreeEntry, 0, treeBatchSize)
+	lastFlush := time.Now()
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := send(TreeMessage{Type: "entries", Entries: batch})
+		batch = batch[:0]
+		lastFlush = time.Now()
+		return err
+	}
+
+	walkErr := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil || path == root {
+			// Unreadable entries are skipped rather than ending the walk.
+			return nil
+		}
+		rel, _ := filepath.Rel(root, path)
+		if d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		batch = append(batch, TreeEntry{
+			Path:    filepath.ToSlash(rel),
+			IsDir:   d.IsDir(),
+			Size:    info.Size(),
+			ModTime: info.ModTime().UTC(),
+		})
+		count++
+		if len(batch) >= treeBatchSize || time.Since(lastFlush) >= treeFlushInterval {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+		if d.IsDir() && strings.Count(rel, string(filepath.Separator))+1 >= maxDepth {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+
+	if ctx.Err() != nil && !canceled.Load() {
+		// The client went away; there's nobody left to tell.
+		return
+	}
+	if walkErr != nil && ctx.Err() == nil {
+		send(TreeMessage{Type: "error", Message: walkErr.Error()})
+		return
+	}
+	if err := flush(); err != nil {
+		return
+	}
+	send(TreeMessage{Type: "done", Count: count, Canceled: canceled.Load()})
+	conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+}
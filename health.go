@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// readyTimeout bounds the Docker ping behind /readyz, so a hung daemon
+// fails the probe instead of piling up probe requests.
+const readyTimeout = 3 * time.Second
+
+// healthzHandler answers 200 while the process is serving requests. It needs
+// no token, for liveness probes.
+func healthzHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok"})
+}
+
+// readyzHandler answers 200 when the Docker daemon is reachable and 503 when
+// it isn't or the agent is shutting down. It needs no token, for readiness
+// probes.
+func readyzHandler(w http.ResponseWriter, r *http.Request) {
+	if shuttingDown.Err() != nil {
+		writeError(w, http.StatusServiceUnavailable, "Shutting down")
+		return
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), readyTimeout)
+	defer cancel()
+	if _, err := dockerClient.Ping(ctx); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "Docker daemon unreachable")
+		return
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok"})
+}
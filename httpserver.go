@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// newHTTPServer builds the agent's server with timeouts, so a slow or stalled
+// client can't hold a connection open forever. Endpoints that legitimately
+// run long are wrapped in longRunning, which lifts the read and write
+// timeouts for that request only.
+func newHTTPServer(addr string, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              addr,
+		Handler:           handler,
+		ReadHeaderTimeout: envDuration("HTTP_READ_HEADER_TIMEOUT", 10*time.Second),
+		ReadTimeout:       envDuration("HTTP_READ_TIMEOUT", time.Minute),
+		WriteTimeout:      envDuration("HTTP_WRITE_TIMEOUT", time.Minute),
+		IdleTimeout:       envDuration("HTTP_IDLE_TIMEOUT", 2*time.Minute),
+	}
+}
+
+// longRunning clears the server's read and write deadlines for WebSockets,
+// large transfers and operations that wait on Docker for minutes. It goes
+// inside tokenMiddleware so only authenticated requests get the exemption.
+func longRunning(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		rc.SetReadDeadline(time.Time{})
+		rc.SetWriteDeadline(time.Time{})
+		next(w, r)
+	}
+}
+
+// shuttingDown is cancelled once the agent has been told to stop. Long-lived
+// handlers that outlive http.Server.Shutdown, i.e. WebSockets, watch it.
+var shuttingDown, beginShutdown = context.WithCancel(context.Background())
+
+// wsSessions counts open WebSocket sessions so shutdown can wait for their
+// handlers to clean up, e.g. to reap their docker attach processes.
+var wsSessions sync.WaitGroup
+
+// serve runs srv until SIGINT or SIGTERM, then stops accepting connections
+// and gives in-flight requests and WebSocket sessions up to grace to finish.
+func serve(srv *http.Server, grace time.Duration) {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.ListenAndServe() }()
+	select {
+	case err := <-errc:
+		fatal("HTTP server stopped", "err", err)
+	case <-ctx.Done():
+	}
+	// A second signal kills the process straight away.
+	stop()
+	slog.Info("Shutting down", "grace", grace)
+
+	graceCtx, cancel := context.WithTimeout(context.Background(), grace)
+	defer cancel()
+	beginShutdown()
+	if err := srv.Shutdown(graceCtx); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		slog.Warn("Requests still running at shutdown", "err", err)
+	}
+	done := make(chan struct{})
+	go func() {
+		wsSessions.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		slog.Info("Shutdown complete")
+	case <-graceCtx.Done():
+		slog.Warn("WebSocket sessions still open at shutdown")
+	}
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// maxIdempotencyKey bounds the Idempotency-Key header.
+const maxIdempotencyKey = 255
+
+// idempotentResult is a finished response kept for replay, or a placeholder
+// while the first request with the key is still running.
+type idempotentResult struct {
+	fingerprint [32]byte
+	done        bool
+	status      int
+	header      http.Header
+	body        []byte
+	created     time.Time
+}
+
+type idempotencyStore struct {
+	mu      sync.Mutex
+	results map[string]*idempotentResult
+}
+
+var idempotencyKeys = &idempotencyStore{results: map[string]*idempotentResult{}}
+
+// startJanitor forgets results ttl after their request arrived.
+func (s *idempotencyStore) startJanitor(ttl time.Duration) {
+	go func() {
+		for range time.Tick(ttl / 4) {
+			cutoff := time.Now().Add(-ttl)
+			s.mu.Lock()
+			for key, res := range s.results {
+				if res.done && res.created.Before(cutoff) {
+					delete(s.results, key)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+// idempotencyRecorder passes a response through while keeping a copy of it.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *idempotencyRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+		w.header = w.Header().Clone()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *idempotencyRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	w.body.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// idempotent lets clients retry a request safely by sending the same
+// Idempotency-Key header: the first response is kept for
+// IDEMPOTENCY_KEY_TTL and replayed to later requests with the key, marked
+// with Idempotent-Replayed, instead of running the handler again. A retry
+// while the first request is still running gets 409, and reusing a key for
+// a different body 422. Keys are scoped to the caller, so users can't replay
+// each other's responses. Requests without the header run as usual.
+//
+// It goes inside tokenMiddleware, and outside expensive so replays don't
+// count against the rate limit. A 429 isn't kept, as nothing was done.
+func idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxIdempotencyKey {
+			writeError(w, http.StatusBadRequest, "Idempotency-Key must be at most 255 characters")
+			return
+		}
+		// Only /server/create is idempotent, so its limit bounds the copy.
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, createBodyLimit()))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			writeError(w, http.StatusRequestEntityTooLarge, "Request body exceeds "+humanBytes(createBodyLimit()))
+			return
+		}
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		fingerprint := sha256.Sum256(append([]byte(r.Method+" "+r.URL.Path+"\n"), body...))
+		scope := "shared"
+		if id := identityFrom(r.Context()); id.Email != "" {
+			scope = "user:" + extractUserId(id.Email)
+		}
+		key = scope + "\x00" + r.URL.Path + "\x00" + key
+
+		s := idempotencyKeys
+		s.mu.Lock()
+		if res, ok := s.results[key]; ok {
+			s.mu.Unlock()
+			switch {
+			case res.fingerprint != fingerprint:
+				writeError(w, http.StatusUnprocessableEntity, "Idempotency-Key was already used for a different request")
+			case !res.done:
+				writeError(w, http.StatusConflict, "A request with this Idempotency-Key is still in progress")
+			default:
+				for k, v := range res.header {
+					if k != "X-Request-Id" {
+						w.Header()[k] = v
+					}
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(res.status)
+				w.Write(res.body)
+			}
+			return
+		}
+		res := &idempotentResult{fingerprint: fingerprint, created: time.Now()}
+		s.results[key] = res
+		s.mu.Unlock()
+
+		rec := &idempotencyRecorder{ResponseWriter: w}
+		kept := false
+		defer func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			if !kept {
+				delete(s.results, key)
+			}
+		}()
+		next(rec, r)
+		if rec.status == 0 || rec.status == http.StatusTooManyRequests {
+			return
+		}
+		s.mu.Lock()
+		res.done = true
+		res.status, res.header, res.body = rec.status, rec.header, rec.body.Bytes()
+		s.mu.Unlock()
+		kept = true
+	}
+}
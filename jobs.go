@@ -0,0 +1,158 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Create job states, in order.
+const (
+	jobPending = "pending"
+	// jobCopying is only used by /server/clone, while the source volume is
+	// copied.
+	jobCopying  = "copying"
+	jobPulling  = "pulling"
+	jobCreating = "creating"
+	jobDone     = "done"
+	jobError    = "error"
+)
+
+// CreateJob tracks one background server creation.
+type CreateJob struct {
+	ID         string `json:"jobId"`
+	ServerID   string `json:"serverId"`
+	State      string `json:"state"`
+	LastOutput string `json:"lastOutput,omitempty"`
+	Error      string `json:"error,omitempty"`
+	// ErrorCode is the HTTP status /server/create/status answers with once
+	// the job has failed.
+	ErrorCode int       `json:"errorCode,omitempty"`
+	UpdatedAt time.Time `json:"updatedAt"`
+}
+
+type CreateJobResponse struct {
+	Status string `json:"status"`
+	CreateJob
+}
+
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*CreateJob
+}
+
+var createJobs = &jobStore{jobs: map[string]*CreateJob{}}
+
+func newJobId() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *jobStore) add(serverId string) CreateJob {
+	job := &CreateJob{ID: newJobId(), ServerID: serverId, State: jobPending, UpdatedAt: time.Now().UTC()}
+	s.mu.Lock()
+	s.jobs[job.ID] = job
+	s.mu.Unlock()
+	return *job
+}
+
+// activeServerIds returns the servers of jobs that are still running, whose
+// containers may not exist yet.
+func (s *jobStore) activeServerIds() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ids []string
+	for _, job := range s.jobs {
+		if job.State != jobDone && job.State != jobError {
+			ids = append(ids, job.ServerID)
+		}
+	}
+	return ids
+}
+
+func (s *jobStore) get(id string) (CreateJob, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return CreateJob{}, false
+	}
+	return *job, true
+}
+
+func (s *jobStore) update(id string, fn func(*CreateJob)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now().UTC()
+	}
+}
+
+func (s *jobStore) setState(id, state string) {
+	s.update(id, func(j *CreateJob) { j.State = state })
+}
+
+func (s *jobStore) setOutput(id, line string) {
+	s.update(id, func(j *CreateJob) { j.LastOutput = line })
+}
+
+func (s *jobStore) fail(id, msg string) {
+	s.failWithCode(id, http.StatusInternalServerError, msg)
+}
+
+func (s *jobStore) failWithCode(id string, code int, msg string) {
+	s.update(id, func(j *CreateJob) {
+		j.State = jobError
+		j.Error = msg
+		j.ErrorCode = code
+	})
+}
+
+// startJanitor drops finished jobs once they haven't changed for ttl.
+func (s *jobStore) startJanitor(ttl time.Duration) {
+	go func() {
+		for range time.Tick(ttl / 4) {
+			cutoff := time.Now().Add(-ttl)
+			s.mu.Lock()
+			for id, job := range s.jobs {
+				finished := job.State == jobDone || job.State == jobError
+				if finished && job.UpdatedAt.Before(cutoff) {
+					delete(s.jobs, id)
+				}
+			}
+			s.mu.Unlock()
+		}
+	}()
+}
+
+func createStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	jobId := r.URL.Query().Get("jobId")
+	if jobId == "" {
+		writeError(w, http.StatusBadRequest, "jobId is required")
+		return
+	}
+	job, ok := createJobs.get(jobId)
+	if !ok {
+		writeError(w, http.StatusNotFound, "Job not found")
+		return
+	}
+	if id := identityFrom(r.Context()); id.Email != "" {
+		if _, own := serverNameFromContainerId(job.ServerID, extractUserId(id.Email)); !own {
+			writeError(w, http.StatusForbidden, "Forbidden: server belongs to another user")
+			return
+		}
+	}
+	if job.State == jobError {
+		writeJSON(w, job.ErrorCode, CreateJobResponse{Status: "error", CreateJob: job})
+		return
+	}
+	writeJSON(w, http.StatusOK, CreateJobResponse{Status: "ok", CreateJob: job})
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// userClaims are the claims read from a per-user JWT. The user is taken from
+// email, or from sub when there is no email claim. role is optional.
+type userClaims struct {
+	Email string `json:"email"`
+	Role  string `json:"role"`
+	jwt.RegisteredClaims
+}
+
+// jwtParser verifies per-user tokens. It is nil unless JWT_SECRET or
+// JWT_PUBLIC_KEY_FILE is set, in which case it replaces the static token.
+var (
+	jwtParser *jwt.Parser
+	jwtKey    interface{}
+)
+
+// configureJWT reads the JWT settings. JWT_SECRET selects HMAC (HS256/384/512);
+// JWT_PUBLIC_KEY_FILE, a PEM RSA public key, selects RS256/384/512. JWT_ISSUER,
+// if set, must match the iss claim. Tokens must always carry exp.
+func configureJWT() {
+	secret := os.Getenv("JWT_SECRET")
+	keyFile := os.Getenv("JWT_PUBLIC_KEY_FILE")
+	if secret == "" && keyFile == "" {
+		return
+	}
+	if secret != "" && keyFile != "" {
+		fatal("Set only one of JWT_SECRET and JWT_PUBLIC_KEY_FILE")
+	}
+
+	opts := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if iss := os.Getenv("JWT_ISSUER"); iss != "" {
+		opts = append(opts, jwt.WithIssuer(iss))
+	}
+	if secret != "" {
+		jwtKey = []byte(secret)
+		opts = append(opts, jwt.WithValidMethods([]string{"HS256", "HS384", "HS512"}))
+	} else {
+		pem, err := os.ReadFile(keyFile)
+		if err != nil {
+			fatal("Failed to read JWT_PUBLIC_KEY_FILE", "err", err)
+		}
+		key, err := jwt.ParseRSAPublicKeyFromPEM(pem)
+		if err != nil {
+			fatal("Invalid JWT_PUBLIC_KEY_FILE", "err", err)
+		}
+		jwtKey = key
+		opts = append(opts, jwt.WithValidMethods([]string{"RS256", "RS384", "RS512"}))
+	}
+	jwtParser = jwt.NewParser(opts...)
+}
+
+// verifyJWT checks the token's signature, exp and iss and returns the user it
+// was issued to.
+func verifyJWT(token string) (Identity, error) {
+	var claims userClaims
+	_, err := jwtParser.ParseWithClaims(token, &claims, func(*jwt.Token) (interface{}, error) {
+		return jwtKey, nil
+	})
+	switch {
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return Identity{}, errors.New("token expired")
+	case err != nil:
+		return Identity{}, errors.New("invalid token")
+	}
+	email := strings.TrimSpace(claims.Email)
+	if email == "" {
+		email = strings.TrimSpace(claims.Subject)
+	}
+	if extractUserId(email) == "" {
+		return Identity{}, errors.New("token has no email or sub claim")
+	}
+	return Identity{Email: email, Role: strings.TrimSpace(claims.Role)}, nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// useJWTConfig runs configureJWT with env set and restores the previous
+// parser once the test is done.
+func useJWTConfig(t *testing.T, env map[string]string) {
+	t.Helper()
+	for _, k := range []string{"JWT_SECRET", "JWT_PUBLIC_KEY_FILE", "JWT_ISSUER"} {
+		t.Setenv(k, env[k])
+	}
+	savedParser, savedKey := jwtParser, jwtKey
+	t.Cleanup(func() { jwtParser, jwtKey = savedParser, savedKey })
+	configureJWT()
+}
+
+func signJWT(t *testing.T, method jwt.SigningMethod, key interface{}, claims jwt.MapClaims) string {
+	t.Helper()
+	token, err := jwt.NewWithClaims(method, claims).SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// tamper replaces the payload of a signed token, keeping its signature.
+func tamper(t *testing.T, token string, claims jwt.MapClaims) string {
+	t.Helper()
+	forged := signJWT(t, jwt.SigningMethodHS256, []byte("other"), claims)
+	parts := strings.Split(token, ".")
+	parts[1] = strings.Split(forged, ".")[1]
+	return strings.Join(parts, ".")
+}
+
+func TestVerifyJWTHMAC(t *testing.T) {
+	const secret = "s3cret"
+	useJWTConfig(t, map[string]string{"JWT_SECRET": secret, "JWT_ISSUER": "panel"})
+	key := []byte(secret)
+	exp := time.Now().Add(time.Hour).Unix()
+	valid := jwt.MapClaims{"email": "alice@example.com", "iss": "panel", "exp": exp}
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		token   string
+		want    Identity
+		wantErr string
+	}{
+		{"valid", signJWT(t, jwt.SigningMethodHS256, key, valid), Identity{Email: "alice@example.com"}, ""},
+		{"HS512", signJWT(t, jwt.SigningMethodHS512, key, valid), Identity{Email: "alice@example.com"}, ""},
+		{"sub and role", signJWT(t, jwt.SigningMethodHS256, key, jwt.MapClaims{"sub": "bob@example.com", "role": " moderator ", "iss": "panel", "exp": exp}), Identity{Email: "bob@example.com", Role: "moderator"}, ""},
+		{"expired", signJWT(t, jwt.SigningMethodHS256, key, jwt.MapClaims{"email": "alice@example.com", "iss": "panel", "exp": time.Now().Add(-time.Minute).Unix()}), Identity{}, "token expired"},
+		{"no exp", signJWT(t, jwt.SigningMethodHS256, key, jwt.MapClaims{"email": "alice@example.com", "iss": "panel"}), Identity{}, "invalid token"},
+		{"wrong issuer", signJWT(t, jwt.SigningMethodHS256, key, jwt.MapClaims{"email": "alice@example.com", "iss": "elsewhere", "exp": exp}), Identity{}, "invalid token"},
+		{"wrong secret", signJWT(t, jwt.SigningMethodHS256, []byte("guess"), valid), Identity{}, "invalid token"},
+		{"tampered payload", tamper(t, signJWT(t, jwt.SigningMethodHS256, key, valid), jwt.MapClaims{"email": "mallory@example.com", "iss": "panel", "exp": exp}), Identity{}, "invalid token"},
+		{"alg none", signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), Identity{}, "invalid token"},
+		{"RS256", signJWT(t, jwt.SigningMethodRS256, rsaKey, valid), Identity{}, "invalid token"},
+		{"no user", signJWT(t, jwt.SigningMethodHS256, key, jwt.MapClaims{"iss": "panel", "exp": exp}), Identity{}, "token has no email or sub claim"},
+		{"garbage", "not.a.token", Identity{}, "invalid token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := verifyJWT(tt.token)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("verifyJWT() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("verifyJWT() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("verifyJWT() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVerifyJWTRSA(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pemBytes := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	keyFile := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := os.WriteFile(keyFile, pemBytes, 0600); err != nil {
+		t.Fatal(err)
+	}
+	useJWTConfig(t, map[string]string{"JWT_PUBLIC_KEY_FILE": keyFile})
+	valid := jwt.MapClaims{"email": "alice@example.com", "exp": time.Now().Add(time.Hour).Unix()}
+
+	tests := []struct {
+		name   string
+		token  string
+		wantOK bool
+	}{
+		{"RS256", signJWT(t, jwt.SigningMethodRS256, rsaKey, valid), true},
+		{"RS512", signJWT(t, jwt.SigningMethodRS512, rsaKey, valid), true},
+		// The public key is no secret: an HMAC token keyed with it must not
+		// pass as one signed with the private key.
+		{"HS256 keyed with the public key", signJWT(t, jwt.SigningMethodHS256, pemBytes, valid), false},
+		{"alg none", signJWT(t, jwt.SigningMethodNone, jwt.UnsafeAllowNoneSignatureType, valid), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := verifyJWT(tt.token)
+			if (err == nil) != tt.wantOK {
+				t.Errorf("verifyJWT() error = %v, want ok %v", err, tt.wantOK)
+			}
+		})
+	}
+}
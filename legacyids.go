@@ -0,0 +1,284 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// Servers created before container IDs used containerIdSeparator are named
+// <server-name>-<userId>, which can't be split reliably when either part
+// contains '-'. migrateLegacyServerIds renames them in the background once the
+// agent is serving, when their owner is known: from the name itself when it
+// has a single '-', or from LEGACY_SERVER_OWNERS otherwise.
+
+// legacyServerOwners reads LEGACY_SERVER_OWNERS, a comma-separated list of
+// <old-container-name>=<userId> for legacy servers whose names are ambiguous.
+func legacyServerOwners() map[string]string {
+	owners := map[string]string{}
+	for _, entry := range strings.Split(os.Getenv("LEGACY_SERVER_OWNERS"), ",") {
+		if id, owner, ok := strings.Cut(strings.TrimSpace(entry), "="); ok && id != "" && owner != "" {
+			owners[id] = sanitizeDockerName(owner)
+		}
+	}
+	return owners
+}
+
+// splitLegacyId splits a legacy container name into server name and userId.
+func splitLegacyId(id string, owners map[string]string) (server, userId string, ok bool) {
+	if owner, listed := owners[id]; listed {
+		server = strings.TrimSuffix(id, "-"+owner)
+		return server, owner, server != id && server != ""
+	}
+	if strings.Count(id, "-") != 1 {
+		return "", "", false
+	}
+	server, userId, _ = strings.Cut(id, "-")
+	return server, userId, server != "" && userId != ""
+}
+
+// renamedIdsFile lists the servers migrateLegacyServerIds renamed in place.
+// They keep their containers, which have no ownerLabel, so the list is what
+// tells them apart from legacy names that merely contain "--".
+func renamedIdsFile() string {
+	return filepath.Join(volumeRoot(), ".renamed-servers.json")
+}
+
+// renamedServers holds the IDs in renamedIdsFile, loaded at startup by
+// loadRenamedIds and added to as migrateLegacyServerIds renames servers.
+var renamedServers = struct {
+	sync.Mutex
+	ids map[string]bool
+}{ids: map[string]bool{}}
+
+// isRenamedServer reports whether containerId is a server renamed in place
+// from a legacy ID.
+func isRenamedServer(containerId string) bool {
+	renamedServers.Lock()
+	defer renamedServers.Unlock()
+	return renamedServers.ids[containerId]
+}
+
+// loadRenamedIds reads renamedIdsFile into renamedServers. The servers in an
+// unreadable file are left out of server lists until it is fixed.
+func loadRenamedIds() {
+	data, err := os.ReadFile(renamedIdsFile())
+	if err != nil {
+		return
+	}
+	var ids []string
+	if err := json.Unmarshal(data, &ids); err != nil {
+		slog.Error("Invalid renamed servers file, servers renamed from legacy IDs won't be listed", "file", renamedIdsFile(), "err", err)
+		return
+	}
+	renamedServers.Lock()
+	defer renamedServers.Unlock()
+	for _, id := range ids {
+		renamedServers.ids[id] = true
+	}
+}
+
+// addRenamedId records newId in renamedServers and saves the file.
+func addRenamedId(newId string) error {
+	renamedServers.Lock()
+	defer renamedServers.Unlock()
+	renamedServers.ids[newId] = true
+	ids := make([]string, 0, len(renamedServers.ids))
+	for id := range renamedServers.ids {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	data, _ := json.MarshalIndent(ids, "", "  ")
+	return writeFileAtomic(renamedIdsFile(), data, 0644)
+}
+
+// migrateLegacyServerIds renames the legacy servers whose owner is known and
+// logs the others, which no request can reach until they are renamed. It
+// runs once the agent is serving and never stops it: when Docker can't be
+// asked, the migration is skipped until the next start. A legacy name that
+// contains "--" reads as a current ID, possibly of another user, so those
+// are logged as errors until their owner is listed.
+func migrateLegacyServerIds() {
+	ctx := context.Background()
+	containers, err := dockerClient.ContainerList(ctx, container.ListOptions{All: true, Filters: filters.NewArgs(filters.Arg("label", managedLabel))})
+	if err != nil {
+		slog.Error("Failed to list servers, legacy IDs aren't migrated until the next start", "err", err)
+		return
+	}
+	owners := legacyServerOwners()
+	moved := map[string]string{}
+	for _, c := range containers {
+		if len(c.Names) == 0 || c.Labels[ownerLabel] != "" {
+			continue
+		}
+		oldId := strings.TrimPrefix(c.Names[0], "/")
+		if isRenamedServer(oldId) {
+			continue
+		}
+		reachable := strings.Contains(oldId, containerIdSeparator)
+		server, userId, ok := splitLegacyId(oldId, owners)
+		if !ok {
+			if reachable {
+				slog.Error("Legacy server name is ambiguous and reads as a current ID, possibly of another user; add it to LEGACY_SERVER_OWNERS as <name>=<userId>", "server", oldId)
+			} else {
+				slog.Warn("Legacy server name is ambiguous and the server can't be reached until it is added to LEGACY_SERVER_OWNERS", "server", oldId)
+			}
+			continue
+		}
+		newId := serverIdPart(server) + containerIdSeparator + userId
+		if err := migrateLegacyServer(ctx, oldId, newId, userId, reachable); err != nil {
+			slog.Error("Failed to rename legacy server", "server", oldId, "to", newId, "err", err)
+			continue
+		}
+		moved[oldId] = newId
+		slog.Info("Renamed legacy server", "from", oldId, "to", newId)
+	}
+	if len(moved) > 0 {
+		renameLegacyBackups(moved)
+	}
+}
+
+// migrateLegacyServer renames one legacy server while holding the locks of
+// both its IDs, as requests are already being served.
+func migrateLegacyServer(ctx context.Context, oldId, newId, userId string, reachable bool) error {
+	unlockOld, held, ok := tryLockServer(oldId, "legacy ID migration")
+	if !ok {
+		return errors.New("server is busy: " + held.Operation + " in progress")
+	}
+	defer unlockOld()
+	unlockNew, held, ok := tryLockServer(newId, "legacy ID migration")
+	if !ok {
+		return errors.New("server is busy: " + held.Operation + " in progress")
+	}
+	defer unlockNew()
+	if reachable {
+		return recreateLegacyServer(ctx, oldId, newId, userId)
+	}
+	if err := renameLegacyServer(ctx, oldId, newId); err != nil {
+		return err
+	}
+	if err := addRenamedId(newId); err != nil {
+		slog.Error("Failed to save renamed servers", "file", renamedIdsFile(), "err", err)
+	}
+	return nil
+}
+
+// renameLegacyServer renames the container in place, so a running server
+// keeps running. Its bind mount still names the old volume directory, which
+// stays where it is, with the new volume entry linking to it.
+func renameLegacyServer(ctx context.Context, oldId, newId string) error {
+	newLink := serverVolumeLink(newId)
+	if _, err := os.Lstat(newLink); err == nil {
+		return errors.New("a volume for the new name already exists")
+	}
+	linked := false
+	if _, err := os.Stat(serverVolumeLink(oldId)); err == nil {
+		if err := os.Symlink(getServerDataDir(oldId), newLink); err != nil {
+			return err
+		}
+		linked = true
+	}
+	if err := dockerClient.ContainerRename(ctx, oldId, newId); err != nil {
+		if linked {
+			os.Remove(newLink)
+		}
+		return err
+	}
+	return nil
+}
+
+// recreateLegacyServer moves the volume entry to the new name, so the old one
+// can't be reached as another user's ID, and recreates the container on it
+// with its owner label. A running server is stopped for it and started again.
+func recreateLegacyServer(ctx context.Context, oldId, newId, userId string) error {
+	info, err := inspectContainer(ctx, oldId)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Lstat(serverVolumeLink(newId)); err == nil {
+		return errors.New("a volume for the new name already exists")
+	}
+	wasRunning := info.State.Running
+	if wasRunning {
+		if _, err := stopContainer(oldId, defaultStopTimeout); err != nil {
+			return fmt.Errorf("failed to stop server: %v", err)
+		}
+	}
+	if err := os.Rename(serverVolumeLink(oldId), serverVolumeLink(newId)); err != nil {
+		return err
+	}
+	if err := removeContainer(oldId, false); err != nil {
+		os.Rename(serverVolumeLink(newId), serverVolumeLink(oldId))
+		return err
+	}
+	labelled := info
+	labelled.Config = &container.Config{}
+	*labelled.Config = *info.Config
+	labelled.Config.Labels = map[string]string{ownerLabel: userId}
+	for k, v := range info.Config.Labels {
+		labelled.Config.Labels[k] = v
+	}
+	if err := recreateContainer(newId, labelled, getServerDataDir(newId)); err != nil {
+		os.Rename(serverVolumeLink(newId), serverVolumeLink(oldId))
+		if err := recreateContainer(oldId, info, getServerDataDir(oldId)); err != nil {
+			slog.Error("Failed to restore legacy server", "server", oldId, "err", err)
+		}
+		return err
+	}
+	if wasRunning {
+		return startContainer(newId)
+	}
+	return nil
+}
+
+// renameLegacyBackups gives the backups and backup schedules of renamed
+// servers their new IDs.
+func renameLegacyBackups(moved map[string]string) {
+	if entries, err := os.ReadDir(getBackupsDir()); err == nil {
+		for _, e := range entries {
+			for oldId, newId := range moved {
+				if !e.IsDir() && backupBelongsTo(e.Name(), oldId) {
+					from := filepath.Join(getBackupsDir(), e.Name())
+					to := filepath.Join(getBackupsDir(), newId+strings.TrimPrefix(e.Name(), oldId))
+					if err := os.Rename(from, to); err != nil {
+						slog.Error("Failed to rename legacy backup", "file", e.Name(), "err", err)
+					}
+				}
+			}
+		}
+	}
+
+	data, err := os.ReadFile(backupSchedulesFile())
+	if err != nil {
+		return
+	}
+	saved := map[string]json.RawMessage{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		return
+	}
+	changed := false
+	for oldId, newId := range moved {
+		if s, ok := saved[oldId]; ok {
+			delete(saved, oldId)
+			saved[newId] = s
+			changed = true
+		}
+	}
+	if !changed {
+		return
+	}
+	data, _ = json.MarshalIndent(saved, "", "  ")
+	if err := writeFileAtomic(backupSchedulesFile(), data, 0644); err != nil {
+		slog.Error("Failed to rename legacy backup schedules", "err", err)
+	}
+}
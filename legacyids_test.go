@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestSplitLegacyId(t *testing.T) {
+	owners := map[string]string{"my-lobby-bob-x": "bob-x", "lobby-x-y": "x-y"}
+	tests := []struct {
+		id             string
+		server, userId string
+		ok             bool
+	}{
+		{"lobby-alice", "lobby", "alice", true},
+		{"my-lobby-bob-x", "my-lobby", "bob-x", true},
+		{"lobby-x-y", "lobby", "x-y", true},
+		{"my-lobby-alice", "", "", false},
+		{"lobby", "", "", false},
+		{"-alice", "", "", false},
+		{"lobby-", "", "", false},
+	}
+	for _, tt := range tests {
+		server, userId, ok := splitLegacyId(tt.id, owners)
+		if ok != tt.ok || (ok && (server != tt.server || userId != tt.userId)) {
+			t.Errorf("splitLegacyId(%q) = %q, %q, %v; want %q, %q, %v", tt.id, server, userId, ok, tt.server, tt.userId, tt.ok)
+		}
+	}
+}
+
+func TestMigrateLegacyServerIdsDoesNotStopTheAgent(t *testing.T) {
+	useVolumeRoot(t)
+	t.Setenv("LEGACY_SERVER_OWNERS", "")
+
+	// Reaching fatal would end the test binary.
+	unreachableDocker(t)
+	migrateLegacyServerIds()
+
+	legacy := runningContainer(map[string]string{managedLabel: "true"})
+	fakeDocker(t, map[string]types.ContainerJSON{
+		"lobby--x-bob":   legacy,
+		"my-lobby-alice": legacy,
+	})
+	migrateLegacyServerIds()
+	for _, id := range []string{"lobby--x-bob", "my-lobby-alice"} {
+		if isRenamedServer(id) {
+			t.Errorf("ambiguous legacy server %s was renamed", id)
+		}
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ServerRequest is the standard body of the lifecycle endpoints.
+type ServerRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+}
+
+type StopServerRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// StopTimeoutSeconds is how long the server gets to save and exit before
+	// it is killed. Defaults to 30.
+	StopTimeoutSeconds int `json:"stopTimeoutSeconds,omitempty"`
+}
+
+type StopServerResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	// Method is "rcon" when the server shut itself down after an RCON stop,
+	// or "docker" when docker stop had to do it.
+	Method string `json:"method,omitempty"`
+}
+
+const defaultStopTimeout = 30
+
+type KillServerRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// Signal is one of killSignals, with or without the SIG prefix. Defaults
+	// to SIGKILL.
+	Signal string `json:"signal,omitempty"`
+}
+
+// killSignals are the signals /server/kill may send.
+var killSignals = map[string]bool{"SIGKILL": true, "SIGTERM": true, "SIGINT": true, "SIGQUIT": true, "SIGHUP": true}
+
+func startServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req ServerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "start")
+	if !ok {
+		return
+	}
+	defer unlock()
+	if err := startContainer(containerId); err != nil {
+		if isNotFound(err) {
+			writeError(w, http.StatusNotFound, "Server not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to start server: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok", Message: "Server started"})
+}
+
+// stopServerHandler asks the server to stop over RCON first so it saves the
+// world cleanly, and only falls back to docker stop if that fails or the
+// server doesn't exit within the timeout.
+func stopServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req StopServerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	timeout := req.StopTimeoutSeconds
+	if timeout == 0 {
+		timeout = defaultStopTimeout
+	}
+	if timeout < 0 || timeout > 600 {
+		writeError(w, http.StatusBadRequest, "stopTimeoutSeconds must be between 1 and 600")
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	if !allowCommand(w, r, "stop", req.ServerName, req.UserEmail, "stop") {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "stop")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	if !info.State.Running {
+		writeJSON(w, http.StatusOK, StopServerResponse{Status: "ok", Message: "Server is not running"})
+		return
+	}
+
+	method, err := stopContainer(containerId, timeout)
+	if err != nil {
+		auditCommand(r, "stop", req.ServerName, req.UserEmail, "stop", auditFailed, err.Error())
+		writeError(w, http.StatusInternalServerError, "Failed to stop server: "+err.Error())
+		return
+	}
+	auditCommand(r, "stop", req.ServerName, req.UserEmail, "stop", auditOK, method)
+	if method == "rcon" {
+		writeJSON(w, http.StatusOK, StopServerResponse{Status: "ok", Message: "Server saved and stopped", Method: method})
+		return
+	}
+	msg := "Server stopped with docker stop"
+	if method == "docker-after-rcon" {
+		msg = "Server did not exit within " + strconv.Itoa(timeout) + "s of the RCON stop; stopped with docker stop"
+	}
+	writeJSON(w, http.StatusOK, StopServerResponse{Status: "ok", Message: msg, Method: "docker"})
+}
+
+// stopContainer stops a running server, over RCON if it can so the world is
+// saved, and with docker stop otherwise. It returns "rcon", "docker", or
+// "docker-after-rcon" when the RCON stop was sent but didn't finish in time.
+//
+// docker stop is sent even after the RCON stop has worked: to Docker that
+// exit is the server's own, and the unless-stopped policy would start it
+// again, while waitForExit may have caught it between the two.
+func stopContainer(containerId string, timeout int) (string, error) {
+	method := "docker"
+	if env, err := containerEnv(containerId); err == nil && !isProxyType(env["TYPE"]) {
+		if _, err := runRcon(containerId, "stop"); err == nil {
+			method = "docker-after-rcon"
+			if waitForExit(containerId, time.Duration(timeout)*time.Second) {
+				method = "rcon"
+			}
+		}
+	}
+	if err := dockerClient.ContainerStop(context.Background(), containerId, container.StopOptions{Timeout: &timeout}); err != nil {
+		return "", err
+	}
+	return method, nil
+}
+
+// waitForExit polls until the container stops running or timeout passes.
+func waitForExit(containerId string, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if !isRunning(containerId) {
+			return true
+		}
+		time.Sleep(time.Second)
+	}
+	return !isRunning(containerId)
+}
+
+// killServerHandler sends a signal, SIGKILL unless another is asked for, to a
+// server that won't stop gracefully. Unlike /server/stop nothing is saved
+// first. It doesn't take the server lock, as the operation holding it may be
+// the stop that hangs.
+func killServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req KillServerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	signal := "SIGKILL"
+	if req.Signal != "" {
+		signal = strings.ToUpper(req.Signal)
+		if !strings.HasPrefix(signal, "SIG") {
+			signal = "SIG" + signal
+		}
+		if !killSignals[signal] {
+			writeError(w, http.StatusBadRequest, "signal must be one of SIGKILL, SIGTERM, SIGINT, SIGQUIT or SIGHUP")
+			return
+		}
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	// Killing stops the server too, so a role that may not stop it may not
+	// kill it either.
+	if !allowCommand(w, r, "kill", req.ServerName, req.UserEmail, "stop") {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	if !info.State.Running {
+		writeError(w, http.StatusConflict, "Server is not running")
+		return
+	}
+	if signal == "SIGKILL" {
+		crashes.expectKill(containerId)
+	}
+	if err := dockerClient.ContainerKill(r.Context(), containerId, signal); err != nil {
+		crashes.takeKill(containerId)
+		if strings.Contains(err.Error(), "is not running") {
+			writeError(w, http.StatusConflict, "Server is not running")
+			return
+		}
+		auditCommand(r, "kill", req.ServerName, req.UserEmail, "stop", auditFailed, err.Error())
+		writeError(w, http.StatusInternalServerError, "Failed to kill server: "+err.Error())
+		return
+	}
+	auditCommand(r, "kill", req.ServerName, req.UserEmail, "stop", auditOK, signal)
+	msg := "Server killed; unsaved world changes may have been lost"
+	if signal != "SIGKILL" {
+		msg = "Sent " + signal + " to server; it may not have saved the world before exiting"
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok", Message: msg})
+}
+
+// pauseServerHandler freezes a running server with docker pause. Players stay
+// connected but the server stops ticking until it is unpaused.
+func pauseServerHandler(w http.ResponseWriter, r *http.Request) {
+	pauseState(w, r, "pause")
+}
+
+func unpauseServerHandler(w http.ResponseWriter, r *http.Request) {
+	pauseState(w, r, "unpause")
+}
+
+// pauseState pauses or unpauses the container and maps Docker's state errors
+// onto 404 and 409.
+func pauseState(w http.ResponseWriter, r *http.Request, action string) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req ServerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, action)
+	if !ok {
+		return
+	}
+	defer unlock()
+	pause := dockerClient.ContainerPause
+	if action == "unpause" {
+		pause = dockerClient.ContainerUnpause
+	}
+	if err := pause(context.Background(), containerId); err != nil {
+		out := err.Error()
+		switch {
+		case isNotFound(err):
+			writeError(w, http.StatusNotFound, "Server not found")
+		case strings.Contains(out, "is not running"):
+			writeError(w, http.StatusConflict, "Server is not running")
+		case strings.Contains(out, "is already paused"):
+			writeError(w, http.StatusConflict, "Server is already paused")
+		case strings.Contains(out, "is not paused"):
+			writeError(w, http.StatusConflict, "Server is not paused")
+		default:
+			writeError(w, http.StatusInternalServerError, "Failed to "+action+" server: "+out)
+		}
+		return
+	}
+	msg := "Server paused"
+	if action == "unpause" {
+		msg = "Server unpaused"
+	}
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok", Message: msg})
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+)
+
+// ServerSummary is one entry returned by /server/list.
+type ServerSummary struct {
+	ServerID string `json:"serverId"`
+	Name     string `json:"name"`
+	State    string `json:"state"`
+}
+
+// serverNameFromContainerId reverses buildContainerId. ok is false when the
+// container doesn't belong to userId.
+func serverNameFromContainerId(containerId, userId string) (name string, ok bool) {
+	name, owner, found := strings.Cut(containerId, containerIdSeparator)
+	if !found || name == "" || owner != userId {
+		return "", false
+	}
+	return name, true
+}
+
+// containerStates are the Docker states /server/list can filter on.
+var containerStates = map[string]bool{
+	"created":    true,
+	"restarting": true,
+	"running":    true,
+	"removing":   true,
+	"paused":     true,
+	"exited":     true,
+	"dead":       true,
+}
+
+// listUserContainers returns every server container owned by userId, stopped
+// ones included, or only those in state when it isn't empty. Ownership comes
+// from ownerLabel, and the ID must agree with it; servers renamed from legacy
+// IDs have no label and are matched by their ID instead.
+func listUserContainers(ctx context.Context, userId, state string) ([]ServerSummary, error) {
+	args := filters.NewArgs(filters.Arg("label", managedLabel))
+	if state != "" {
+		args.Add("status", state)
+	}
+	containers, err := dockerClient.ContainerList(ctx, container.ListOptions{All: true, Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	servers := []ServerSummary{}
+	for _, c := range containers {
+		if len(c.Names) == 0 {
+			continue
+		}
+		containerId := strings.TrimPrefix(c.Names[0], "/")
+		owner, labelled := c.Labels[ownerLabel]
+		if (labelled && owner != userId) || (!labelled && !isRenamedServer(containerId)) {
+			continue
+		}
+		if name, ok := serverNameFromContainerId(containerId, userId); ok {
+			servers = append(servers, ServerSummary{ServerID: containerId, Name: name, State: c.State})
+		}
+	}
+	return servers, nil
+}
+
+// userServerIds returns the IDs of every container belonging to userId.
+func userServerIds(userId string) ([]string, error) {
+	servers, err := listUserContainers(context.Background(), userId, "")
+	if err != nil {
+		return nil, err
+	}
+	var ids []string
+	for _, s := range servers {
+		ids = append(ids, s.ServerID)
+	}
+	return ids, nil
+}
+
+func listServersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	userEmail := ownerEmail(r, r.URL.Query().Get("userEmail"))
+	userId := extractUserId(userEmail)
+	if userId == "" {
+		writeError(w, http.StatusBadRequest, "userEmail is required")
+		return
+	}
+	state := r.URL.Query().Get("state")
+	if state != "" && !containerStates[state] {
+		writeError(w, http.StatusBadRequest, "state must be one of created, restarting, running, removing, paused, exited or dead")
+		return
+	}
+	if !authorizeOwner(w, r, userEmail) {
+		return
+	}
+
+	servers, err := listUserContainers(r.Context(), userId, state)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to list containers: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, servers)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+// ServerLockResponse reports whether an operation is currently changing a
+// server.
+type ServerLockResponse struct {
+	Status    string     `json:"status"`
+	Busy      bool       `json:"busy"`
+	Operation string     `json:"operation,omitempty"`
+	Since     *time.Time `json:"since,omitempty"`
+}
+
+// ServerBusyResponse is the 409 body returned when another operation holds a
+// server's lock.
+type ServerBusyResponse struct {
+	Status    string `json:"status"`
+	Message   string `json:"message"`
+	Operation string `json:"operation"`
+}
+
+type serverOperation struct {
+	Operation string
+	Since     time.Time
+}
+
+// serverLocks records which operation, if any, is mutating each server. Only
+// one runs at a time per server; others are refused rather than queued.
+var serverLocks = struct {
+	sync.Mutex
+	ops map[string]serverOperation
+}{ops: map[string]serverOperation{}}
+
+// tryLockServer claims containerId for operation. If another operation holds
+// it, ok is false and held describes that operation.
+func tryLockServer(containerId, operation string) (unlock func(), held serverOperation, ok bool) {
+	serverLocks.Lock()
+	defer serverLocks.Unlock()
+	if held, busy := serverLocks.ops[containerId]; busy {
+		return nil, held, false
+	}
+	serverLocks.ops[containerId] = serverOperation{Operation: operation, Since: time.Now().UTC()}
+	return func() {
+		serverLocks.Lock()
+		delete(serverLocks.ops, containerId)
+		serverLocks.Unlock()
+	}, serverOperation{}, true
+}
+
+// lockServer is tryLockServer for handlers: when the server is busy it writes
+// a 409 naming the running operation and returns false.
+func lockServer(w http.ResponseWriter, containerId, operation string) (func(), bool) {
+	unlock, held, ok := tryLockServer(containerId, operation)
+	if !ok {
+		writeJSON(w, http.StatusConflict, ServerBusyResponse{
+			Status:    "error",
+			Message:   "Server is busy: " + held.Operation + " in progress",
+			Operation: held.Operation,
+		})
+		return nil, false
+	}
+	return unlock, true
+}
+
+func serverLockHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	serverLocks.Lock()
+	op, busy := serverLocks.ops[containerId]
+	serverLocks.Unlock()
+	resp := ServerLockResponse{Status: "ok", Busy: busy}
+	if busy {
+		resp.Operation = op.Operation
+		resp.Since = &op.Since
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
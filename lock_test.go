@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+)
+
+func TestLockServerRace(t *testing.T) {
+	const containerId = "race--alice"
+	const racers = 2
+
+	var (
+		start   = make(chan struct{})
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		unlocks []func()
+		busy    []*httptest.ResponseRecorder
+	)
+	for i := 0; i < racers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+			rec := httptest.NewRecorder()
+			unlock, ok := lockServer(rec, containerId, "backup")
+			mu.Lock()
+			defer mu.Unlock()
+			if ok {
+				unlocks = append(unlocks, unlock)
+			} else {
+				busy = append(busy, rec)
+			}
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(unlocks) != 1 || len(busy) != racers-1 {
+		t.Fatalf("got %d holders and %d refusals, want 1 and %d", len(unlocks), len(busy), racers-1)
+	}
+	rec := busy[0]
+	if rec.Code != http.StatusConflict {
+		t.Errorf("refused lock: status %d, want %d", rec.Code, http.StatusConflict)
+	}
+	var resp ServerBusyResponse
+	if err := json.NewDecoder(rec.Body).Decode(&resp); err != nil {
+		t.Fatalf("refused lock: invalid body: %v", err)
+	}
+	if resp.Operation != "backup" {
+		t.Errorf("refused lock: operation %q, want %q", resp.Operation, "backup")
+	}
+
+	unlocks[0]()
+	unlock, _, ok := tryLockServer(containerId, "restore")
+	if !ok {
+		t.Fatal("lock not released by unlock")
+	}
+	unlock()
+}
+
+func TestLockServerPerServer(t *testing.T) {
+	unlockA, _, ok := tryLockServer("a--alice", "backup")
+	if !ok {
+		t.Fatal("failed to lock a--alice")
+	}
+	defer unlockA()
+	unlockB, _, ok := tryLockServer("b--alice", "backup")
+	if !ok {
+		t.Fatal("lock on a--alice blocked b--alice")
+	}
+	unlockB()
+}
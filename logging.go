@@ -0,0 +1,149 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// configureLogging installs the default slog logger, which the log package
+// also writes through. LOG_LEVEL is debug, info (default), warn or error;
+// LOG_FORMAT=json switches from the text format to JSON lines.
+func configureLogging() {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(envOr("LOG_LEVEL", "info"))); err != nil {
+		level = slog.LevelInfo
+		defer slog.Warn("Invalid LOG_LEVEL, using info", "value", os.Getenv("LOG_LEVEL"))
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	var h slog.Handler = slog.NewTextHandler(os.Stderr, opts)
+	if strings.EqualFold(os.Getenv("LOG_FORMAT"), "json") {
+		h = slog.NewJSONHandler(os.Stderr, opts)
+	}
+	slog.SetDefault(slog.New(h))
+}
+
+// fatal logs msg at error level and exits, for startup errors.
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+type requestIdKey struct{}
+
+// requestIdPattern limits which X-Request-Id values from a proxy in front of
+// the agent are reused, since they end up in logs and responses.
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+func newRequestId() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// requestId returns the ID logRequests gave the request, or "".
+func requestId(ctx context.Context) string {
+	id, _ := ctx.Value(requestIdKey{}).(string)
+	return id
+}
+
+// requestLogger is the default logger tagged with the request's ID.
+func requestLogger(r *http.Request) *slog.Logger {
+	return slog.Default().With("requestId", requestId(r.Context()))
+}
+
+// loggingResponseWriter records what a handler answered for the access log.
+// Hijack and Flush are passed through so WebSockets and streamed responses
+// keep working.
+type loggingResponseWriter struct {
+	http.ResponseWriter
+	requestId string
+	status    int
+	bytes     int64
+	errorMsg  string
+}
+
+func (w *loggingResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loggingResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+func (w *loggingResponseWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *loggingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("connection does not support hijacking")
+	}
+	if w.status == 0 {
+		w.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+func (w *loggingResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// logRequests gives every request an ID, returned in the X-Request-Id header
+// and in error bodies, and logs one line per request once it is done.
+func logRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-Id")
+		if !requestIdPattern.MatchString(id) {
+			id = newRequestId()
+		}
+		r = r.WithContext(context.WithValue(r.Context(), requestIdKey{}, id))
+		w.Header().Set("X-Request-Id", id)
+		lw := &loggingResponseWriter{ResponseWriter: w, requestId: id}
+
+		start := time.Now()
+		next.ServeHTTP(lw, r)
+
+		status := lw.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		attrs := []any{
+			"requestId", id,
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", status,
+			"bytes", lw.bytes,
+			"duration", time.Since(start).Round(time.Microsecond),
+			"remote", r.RemoteAddr,
+		}
+		if lw.errorMsg != "" {
+			attrs = append(attrs, "error", lw.errorMsg)
+		}
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		slog.Log(r.Context(), level, "request", attrs...)
+	})
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+type ServerLogsResponse struct {
+	Status string   `json:"status"`
+	Lines  []string `json:"lines"`
+}
+
+// Defaults and bounds for /server/logs.
+const (
+	defaultLogTail = 200
+	maxLogTail     = 5000
+)
+
+// logTailFromQuery reads the tail query parameter, the number of past lines
+// to return, capped at maxLogTail. It writes a 400 when it is invalid.
+func logTailFromQuery(w http.ResponseWriter, r *http.Request) (int, bool) {
+	v := r.URL.Query().Get("tail")
+	if v == "" {
+		return defaultLogTail, true
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		writeError(w, http.StatusBadRequest, "tail must be a non-negative integer")
+		return 0, false
+	}
+	return min(n, maxLogTail), true
+}
+
+// serverLogsHandler returns the end of a server's console output, for
+// dashboards that poll instead of holding /ws/console open.
+func serverLogsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	tail, ok := logTailFromQuery(w, r)
+	if !ok {
+		return
+	}
+	opts := container.LogsOptions{Tail: strconv.Itoa(tail)}
+	if v := r.URL.Query().Get("since"); v != "" {
+		since, err := time.ParseDuration(v)
+		if err != nil || since <= 0 {
+			writeError(w, http.StatusBadRequest, "since must be a positive duration such as 10m or 1h30m")
+			return
+		}
+		opts.Since = since.String()
+	}
+
+	out, err := containerLogs(r.Context(), containerId, opts)
+	if err != nil {
+		if isNotFound(err) {
+			writeError(w, http.StatusNotFound, "Server not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to read logs: "+err.Error())
+		return
+	}
+	lines := splitLines(out)
+	if lines == nil {
+		lines = []string{}
+	}
+	writeJSON(w, http.StatusOK, ServerLogsResponse{Status: "ok", Lines: lines})
+}
+
+// sseKeepAlive is how often an idle log stream gets a comment line, so
+// proxies keep it open and a vanished client is noticed.
+const sseKeepAlive = 30 * time.Second
+
+// serverLogStreamHandler follows a server's console output as Server-Sent
+// Events, for read-only viewers that can't use /ws/console. Each line is one
+// event, after a backlog of the last tail lines; commands sent through the
+// console arrive as "command" events. It shares the console's consoleHub, so
+// the docker logs process is stopped once the last viewer disconnects. When
+// the server stops an "end" event says so and the stream closes.
+func serverLogStreamHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	tail, ok := logTailFromQuery(w, r)
+	if !ok {
+		return
+	}
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	if !info.State.Running {
+		writeError(w, http.StatusConflict, "Server is not running")
+		return
+	}
+
+	rc := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Tell nginx not to buffer the stream.
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		return
+	}
+
+	hub, sub := joinConsole(containerId, tail)
+	defer hub.leave(sub)
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case <-shuttingDown.Done():
+			writeSSE(w, "end", "Agent is shutting down")
+			rc.Flush()
+			return
+		case <-keepAlive.C:
+			_, err = fmt.Fprint(w, ": keep-alive\n\n")
+		case msg, open := <-sub.out:
+			switch {
+			case !open:
+				reason := strings.TrimPrefix(sub.reason, "Console detached: ")
+				writeSSE(w, "end", reason)
+				rc.Flush()
+				return
+			case msg.Type == "log":
+				err = writeSSE(w, "", msg.Line)
+			default:
+				data, _ := json.Marshal(msg)
+				err = writeSSE(w, msg.Type, string(data))
+			}
+		}
+		if err == nil {
+			err = rc.Flush()
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// writeSSE writes one event. data is split on line breaks, as each line of
+// an event needs its own data field.
+func writeSSE(w http.ResponseWriter, event, data string) error {
+	var b strings.Builder
+	if event != "" {
+		b.WriteString("event: " + event + "\n")
+	}
+	for _, line := range strings.Split(strings.ReplaceAll(data, "\r\n", "\n"), "\n") {
+		b.WriteString("data: " + strings.TrimSuffix(line, "\r") + "\n")
+	}
+	b.WriteString("\n")
+	_, err := fmt.Fprint(w, b.String())
+	return err
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+func main() {
+	addrFlag := flag.String("addr", "", "address to listen on, e.g. :25580 (overrides LISTEN_ADDR)")
+	flag.Parse()
+
+	foundDotEnv := loadDotEnv()
+	configureLogging()
+	if !foundDotEnv {
+		slog.Info("No .env file found, using process environment")
+	}
+	configureJWT()
+	configureCommandPolicy()
+	configureAuditLog()
+	loadToken()
+	configureDocker()
+	configureVolumeRoot()
+	configureBackupRoot()
+	loadRenamedIds()
+	configureCrashMonitor()
+	configureRateLimits()
+	configureAllowedOrigins()
+	configurePorts()
+	startEventMonitor()
+	createJobs.startJanitor(envDuration("CREATE_JOB_TTL", time.Hour))
+	uploads.startJanitor(envDuration("UPLOAD_SESSION_TTL", time.Hour))
+	idempotencyKeys.startJanitor(envDuration("IDEMPOTENCY_KEY_TTL", 24*time.Hour))
+	backupSchedules.start()
+
+	http.HandleFunc("/healthz", healthzHandler)
+	http.HandleFunc("/readyz", readyzHandler)
+	http.HandleFunc("/openapi.json", openAPIHandler)
+	http.HandleFunc("/handshake", tokenMiddleware(handshakeHandler))
+	http.HandleFunc("/whoami", tokenMiddleware(whoamiHandler))
+	http.HandleFunc("/server/create", tokenMiddleware(idempotent(expensive(createServerHandler))))
+	http.HandleFunc("/server/create/status", tokenMiddleware(createStatusHandler))
+	http.HandleFunc("/server/start", tokenMiddleware(startServerHandler))
+	http.HandleFunc("/server/stop", tokenMiddleware(longRunning(stopServerHandler)))
+	http.HandleFunc("/server/kill", tokenMiddleware(killServerHandler))
+	http.HandleFunc("/server/pause", tokenMiddleware(pauseServerHandler))
+	http.HandleFunc("/server/unpause", tokenMiddleware(unpauseServerHandler))
+	http.HandleFunc("/server/command", tokenMiddleware(commandHandler))
+	http.HandleFunc("/server/test-start", tokenMiddleware(expensive(longRunning(testStartHandler))))
+	http.HandleFunc("/server/recreate", tokenMiddleware(expensive(longRunning(recreateServerHandler))))
+	http.HandleFunc("/server/clone", tokenMiddleware(expensive(cloneServerHandler)))
+	http.HandleFunc("/server/resources", tokenMiddleware(resourcesHandler))
+	http.HandleFunc("/server/autostart", tokenMiddleware(autostartHandler))
+	http.HandleFunc("/server/migrate", tokenMiddleware(expensive(longRunning(migrateVolumeHandler))))
+	http.HandleFunc("/server/backup", tokenMiddleware(expensive(longRunning(backupServerHandler))))
+	http.HandleFunc("/server/backup/schedule", tokenMiddleware(backupScheduleHandler))
+	http.HandleFunc("/server/backup/prune", tokenMiddleware(pruneBackupsHandler))
+	http.HandleFunc("/server/backup/download", tokenMiddleware(longRunning(backupDownloadHandler)))
+	http.HandleFunc("/server/restore", tokenMiddleware(expensive(longRunning(restoreServerHandler))))
+	http.HandleFunc("/server/list", tokenMiddleware(listServersHandler))
+	http.HandleFunc("/server/status", tokenMiddleware(serverStatusHandler))
+	http.HandleFunc("/server/stats", tokenMiddleware(serverStatsHandler))
+	http.HandleFunc("/server/logs", tokenMiddleware(serverLogsHandler))
+	http.HandleFunc("/server/logs/stream", tokenMiddleware(longRunning(serverLogStreamHandler)))
+	http.HandleFunc("/server/disk", tokenMiddleware(longRunning(serverDiskHandler)))
+	http.HandleFunc("/server/port", tokenMiddleware(serverPortHandler))
+	http.HandleFunc("/server/lock", tokenMiddleware(serverLockHandler))
+	http.HandleFunc("/server/crashes", tokenMiddleware(serverCrashesHandler))
+	http.HandleFunc("/server/max-players", tokenMiddleware(maxPlayersHandler))
+	http.HandleFunc("/server/properties", tokenMiddleware(serverPropertiesHandler))
+	http.HandleFunc("/server/whitelist", tokenMiddleware(whitelistHandler))
+	http.HandleFunc("/server/op", tokenMiddleware(opHandler))
+	http.HandleFunc("/server/deop", tokenMiddleware(deopHandler))
+	http.HandleFunc("/server/players", tokenMiddleware(serverPlayersHandler))
+	http.HandleFunc("/server/plugins", tokenMiddleware(listPluginsHandler))
+	http.HandleFunc("/server/plugins/toggle", tokenMiddleware(togglePluginHandler))
+
+	http.HandleFunc("/file_manager", tokenMiddleware(fileManagerHandler))
+	http.HandleFunc("/file_manager/list", tokenMiddleware(listDirHandler))
+	http.HandleFunc("/file/stat", tokenMiddleware(fileStatHandler))
+	http.HandleFunc("/file/mkdir", tokenMiddleware(mkdirHandler))
+	http.HandleFunc("/file/delete", tokenMiddleware(deleteFileHandler))
+	http.HandleFunc("/file/rename", tokenMiddleware(renameFileHandler))
+	http.HandleFunc("/file/copy", tokenMiddleware(copyFileHandler))
+	http.HandleFunc("/file/search", tokenMiddleware(longRunning(fileSearchHandler)))
+	http.HandleFunc("/file/batch", tokenMiddleware(batchFileHandler))
+	http.HandleFunc("/file/replace", tokenMiddleware(replaceHandler))
+	http.HandleFunc("/file/upload/chunk", tokenMiddleware(longRunning(uploadChunkHandler)))
+	http.HandleFunc("/file/upload/finalize", tokenMiddleware(finalizeUploadHandler))
+	http.HandleFunc("/file/download", tokenMiddleware(longRunning(fileDownloadHandler)))
+	http.HandleFunc("/file/download/zip", tokenMiddleware(longRunning(multiDownloadHandler)))
+	http.HandleFunc("/file/archive", tokenMiddleware(longRunning(archiveDirHandler)))
+	http.HandleFunc("/file/extract", tokenMiddleware(expensive(longRunning(extractHandler))))
+	http.HandleFunc("/ws/file-tree", tokenMiddleware(longRunning(fileTreeHandler)))
+	http.HandleFunc("/ws/console", tokenMiddleware(longRunning(consoleHandler)))
+	http.HandleFunc("/ws/dashboard", tokenMiddleware(longRunning(dashboardHandler)))
+
+	http.HandleFunc("/admin/server/diff", adminMiddleware(serverDiffHandler))
+	checkAPIOperations()
+
+	addr := *addrFlag
+	if addr == "" {
+		addr = envOr("LISTEN_ADDR", ":25575")
+	}
+	srv := newHTTPServer(addr, logRequests(handleCORS(limitRequests(http.DefaultServeMux))))
+	slog.Info("Node HTTP server listening", "addr", addr)
+	// Renaming legacy servers may stop and recreate containers, which must
+	// not hold up serving or /readyz.
+	go migrateLegacyServerIds()
+	serve(srv, envDuration("SHUTDOWN_GRACE", 15*time.Second))
+}
@@ -0,0 +1,356 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/container"
+)
+
+type MigrateVolumeRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// Target is one of the base paths listed in MIGRATION_TARGETS. The volume
+	// ends up at <target>/<serverId>.
+	Target     string `json:"target"`
+	SkipBackup bool   `json:"skipBackup,omitempty"`
+}
+
+type MigrateVolumeResponse struct {
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	From        string `json:"from"`
+	To          string `json:"to"`
+	Bytes       int64  `json:"bytes"`
+	PreBackupID string `json:"preBackupId,omitempty"`
+}
+
+// migrationHeadroom is the extra space, as a share of the volume's size, that
+// must be free on the target on top of the volume itself.
+const migrationHeadroom = 0.05
+
+// migrationTargets returns the base paths volumes may be moved to, taken from
+// the comma-separated MIGRATION_TARGETS. Migration is disabled when it's empty.
+func migrationTargets() []string {
+	var targets []string
+	for _, t := range strings.Split(os.Getenv("MIGRATION_TARGETS"), ",") {
+		if t = strings.TrimSpace(t); t != "" {
+			targets = append(targets, filepath.Clean(t))
+		}
+	}
+	return targets
+}
+
+// recreateContainer creates a container identical to info, with the server's
+// data mounted from dataDir.
+func recreateContainer(containerId string, info types.ContainerJSON, dataDir string) error {
+	config := &container.Config{
+		Image:        info.Config.Image,
+		Labels:       info.Config.Labels,
+		Healthcheck:  info.Config.Healthcheck,
+		ExposedPorts: info.Config.ExposedPorts,
+	}
+	env := map[string]string{}
+	for _, kv := range info.Config.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+		if !imageProvidedEnv(k) {
+			config.Env = append(config.Env, kv)
+		}
+	}
+	hostConfig := &container.HostConfig{
+		Binds:         []string{dataDir + ":" + containerDataPath(env["TYPE"])},
+		PortBindings:  info.HostConfig.PortBindings,
+		RestartPolicy: info.HostConfig.RestartPolicy,
+		Resources: container.Resources{
+			Memory:     info.HostConfig.Memory,
+			MemorySwap: info.HostConfig.MemorySwap,
+			NanoCPUs:   info.HostConfig.NanoCPUs,
+		},
+	}
+	return createContainer(containerId, config, hostConfig)
+}
+
+// migrateVolumeHandler moves a server's volume onto another disk: it stops the
+// server, copies and verifies the data, recreates the container on the new
+// location and starts it again if it was running. The old location is left
+// as a symlink to the new one so file endpoints keep working. Any failure
+// before the old data is removed rolls back to the original container.
+func migrateVolumeHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req MigrateVolumeRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.Target == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and target are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	targets := migrationTargets()
+	if len(targets) == 0 {
+		writeError(w, http.StatusForbidden, "Volume migration is disabled: MIGRATION_TARGETS is not set")
+		return
+	}
+	target := filepath.Clean(req.Target)
+	allowed := false
+	for _, t := range targets {
+		if t == target {
+			allowed = true
+		}
+	}
+	if !allowed {
+		writeError(w, http.StatusBadRequest, "target must be one of: "+strings.Join(targets, ", "))
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "migrate")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	cfg, err := inspectContainer(context.Background(), containerId)
+	if err != nil {
+		if isNotFound(err) {
+			writeError(w, http.StatusNotFound, "Server not found")
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to inspect server: "+err.Error())
+		return
+	}
+	src := mustAbs(getServerDataDir(containerId))
+	dst := filepath.Join(mustAbs(target), containerId)
+	if src == dst {
+		writeError(w, http.StatusConflict, "Server volume is already on "+target)
+		return
+	}
+	if _, err := os.Lstat(dst); err == nil {
+		writeError(w, http.StatusConflict, "Destination "+dst+" already exists")
+		return
+	}
+
+	size, err := dirSize(src)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to measure server volume: "+err.Error())
+		return
+	}
+	free, err := freeSpace(target)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to check free space on target: "+err.Error())
+		return
+	}
+	if need := size + int64(float64(size)*migrationHeadroom); need > free {
+		writeError(w, http.StatusInsufficientStorage, fmt.Sprintf("Not enough space on %s: volume is %d bytes, %d bytes free", target, size, free))
+		return
+	}
+
+	preBackupId, err := backupBeforeDestructive(containerId, "migrate", req.SkipBackup)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to back up server before migrating: "+err.Error())
+		return
+	}
+
+	wasRunning := isRunning(containerId)
+	if wasRunning {
+		if _, err := stopContainer(containerId, defaultStopTimeout); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to stop server: "+err.Error())
+			return
+		}
+	}
+	old, err := moveVolume(containerId, cfg, src, dst)
+	if err != nil {
+		if wasRunning {
+			startContainer(containerId)
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to migrate volume: "+err.Error())
+		return
+	}
+	if err := os.RemoveAll(old); err != nil {
+		slog.Warn("Migration: failed to remove old volume", "server", containerId, "path", old, "err", err)
+	}
+
+	msg := "Volume migrated to " + dst
+	if wasRunning {
+		if err := startContainer(containerId); err != nil {
+			writeError(w, http.StatusInternalServerError, "Volume migrated to "+dst+" but the server failed to start: "+err.Error())
+			return
+		}
+		msg += " and server restarted"
+	}
+	writeJSON(w, http.StatusOK, MigrateVolumeResponse{Status: "ok", Message: msg, From: src, To: dst, Bytes: size, PreBackupID: preBackupId})
+}
+
+// moveVolume copies src to dst, verifies the copy, recreates the (stopped)
+// container on dst and points the server's volume link at it. On error
+// everything is put back the way it was and dst is removed; on success it
+// returns the old data directory for the caller to delete.
+func moveVolume(containerId string, cfg types.ContainerJSON, src, dst string) (string, error) {
+	tmp, err := makeStagingDir(filepath.Dir(dst))
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+	staging := filepath.Join(tmp, "data")
+	if err := snapshotDir(src, staging); err != nil {
+		return "", fmt.Errorf("copy failed: %v", err)
+	}
+	if err := verifyCopy(src, staging); err != nil {
+		return "", fmt.Errorf("copy verification failed: %v", err)
+	}
+	if err := os.Rename(staging, dst); err != nil {
+		return "", err
+	}
+
+	if err := removeContainer(containerId, false); err != nil {
+		os.RemoveAll(dst)
+		return "", fmt.Errorf("failed to remove old container: %v", err)
+	}
+	if err := recreateContainer(containerId, cfg, dst); err != nil {
+		os.RemoveAll(dst)
+		if err := recreateContainer(containerId, cfg, src); err != nil {
+			slog.Error("Migration: failed to recreate original container", "server", containerId, "err", err)
+		}
+		return "", fmt.Errorf("failed to create container on new volume: %v", err)
+	}
+
+	old, err := relinkVolume(containerId, src, dst)
+	if err != nil {
+		removeContainer(containerId, false)
+		recreateContainer(containerId, cfg, src)
+		os.RemoveAll(dst)
+		return "", fmt.Errorf("failed to update volume link: %v", err)
+	}
+	return old, nil
+}
+
+// relinkVolume points the server's entry under volume/ at dst and returns
+// the path holding the old data. When the volume was still in place it is
+// moved into a staging directory first, so the link can take its name, and
+// that directory is returned.
+func relinkVolume(containerId, src, dst string) (string, error) {
+	link := serverVolumeLink(containerId)
+	info, err := os.Lstat(link)
+	if err != nil {
+		return "", err
+	}
+	tmp, err := makeStagingDir(filepath.Dir(link))
+	if err != nil {
+		return "", err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		defer os.RemoveAll(tmp)
+		newLink := filepath.Join(tmp, "link")
+		if err := os.Symlink(dst, newLink); err != nil {
+			return "", err
+		}
+		return src, os.Rename(newLink, link)
+	}
+	old := filepath.Join(tmp, "data")
+	if err := os.Rename(link, old); err != nil {
+		os.Remove(tmp)
+		return "", err
+	}
+	if err := os.Symlink(dst, link); err != nil {
+		os.Rename(old, link)
+		os.Remove(tmp)
+		return "", err
+	}
+	return tmp, nil
+}
+
+// dirSize is the total size of the regular files under root.
+func dirSize(root string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			size += info.Size()
+		}
+		return nil
+	})
+	return size, err
+}
+
+// freeSpace is the number of bytes available to unprivileged users on the
+// filesystem holding path.
+func freeSpace(path string) (int64, error) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(path, &st); err != nil {
+		return 0, err
+	}
+	return int64(st.Bavail) * int64(st.Bsize), nil
+}
+
+// verifyCopy checks that dst holds the same entries as src, with the same
+// type and size.
+func verifyCopy(src, dst string) error {
+	manifest := func(root string) (map[string]string, error) {
+		m := map[string]string{}
+		err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, _ := filepath.Rel(root, path)
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			m[rel] = info.Mode().Type().String() + ":" + strconv.FormatInt(sizeOf(info), 10)
+			return nil
+		})
+		return m, err
+	}
+	want, err := manifest(src)
+	if err != nil {
+		return err
+	}
+	got, err := manifest(dst)
+	if err != nil {
+		return err
+	}
+	for path, w := range want {
+		if got[path] != w {
+			return fmt.Errorf("%s differs in the copy", path)
+		}
+	}
+	if len(got) != len(want) {
+		return fmt.Errorf("copy has %d entries, expected %d", len(got), len(want))
+	}
+	return nil
+}
+
+// sizeOf is the size of a regular file; directory sizes vary between
+// filesystems and are ignored.
+func sizeOf(info os.FileInfo) int64 {
+	if info.Mode().IsRegular() {
+		return info.Size()
+	}
+	return 0
+}
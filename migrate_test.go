@@ -0,0 +1,69 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRelinkVolumeLeavesSiblingVolumes(t *testing.T) {
+	root := useVolumeRoot(t)
+	disk := t.TempDir()
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(root, "lobby--alice", "level.dat"), "lobby")
+	// Server "lobby" of the users "alice.old" and "alice.new", the second
+	// one migrated.
+	write(filepath.Join(root, "lobby--alice.old", "level.dat"), "alice.old's world")
+	write(filepath.Join(disk, "other", "lobby--alice.new", "level.dat"), "alice.new's world")
+	if err := os.Symlink(filepath.Join(disk, "other", "lobby--alice.new"), filepath.Join(root, "lobby--alice.new")); err != nil {
+		t.Fatal(err)
+	}
+	siblingsIntact := func() {
+		t.Helper()
+		for _, dir := range []string{"lobby--alice.old", "lobby--alice.new"} {
+			got, err := os.ReadFile(filepath.Join(root, dir, "level.dat"))
+			if want := map[string]string{"lobby--alice.old": "alice.old's world", "lobby--alice.new": "alice.new's world"}[dir]; err != nil || string(got) != want {
+				t.Errorf("%s/level.dat = %q, %v; want %q", dir, got, err, want)
+			}
+		}
+	}
+
+	// A volume still in the root is moved aside.
+	first := filepath.Join(disk, "a", "lobby--alice")
+	old, err := relinkVolume("lobby--alice", filepath.Join(root, "lobby--alice"), first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target, _ := os.Readlink(filepath.Join(root, "lobby--alice")); target != first {
+		t.Errorf("volume link points at %q, want %q", target, first)
+	}
+	if got, err := os.ReadFile(filepath.Join(old, "data", "level.dat")); err != nil || string(got) != "lobby" {
+		t.Errorf("old data = %q, %v; want the volume moved aside", got, err)
+	}
+	if !withinDir(filepath.Join(root, stagingDirName), old) {
+		t.Errorf("old data in %s, want it under the staging directory", old)
+	}
+	siblingsIntact()
+
+	// A migrated volume's link is replaced.
+	second := filepath.Join(disk, "b", "lobby--alice")
+	old, err = relinkVolume("lobby--alice", first, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if old != first {
+		t.Errorf("old = %q, want %q", old, first)
+	}
+	if target, _ := os.Readlink(filepath.Join(root, "lobby--alice")); target != second {
+		t.Errorf("volume link points at %q, want %q", target, second)
+	}
+	siblingsIntact()
+}
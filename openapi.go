@@ -0,0 +1,327 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+)
+
+// apiOperation describes one endpoint for /openapi.json. Request and
+// Response are zero values of the structs the handler decodes and answers
+// with, so the schemas follow the code.
+type apiOperation struct {
+	Method  string
+	Path    string
+	Summary string
+	// Query lists query parameters. "server" stands for serverName and
+	// userEmail, as read by containerIdFromQuery.
+	Query    []string
+	Request  interface{}
+	Response interface{}
+	// Media is the response type for endpoints that don't answer with JSON.
+	Media string
+	// Public endpoints need no token; Admin ones need ADMIN_TOKEN.
+	Public, Admin bool
+}
+
+// apiOperations is the API as documented in /openapi.json. Add an entry here
+// when registering a new route in main.
+var apiOperations = []apiOperation{
+	{Method: "GET", Path: "/healthz", Summary: "Liveness probe", Response: GenericResponse{}, Public: true},
+	{Method: "GET", Path: "/readyz", Summary: "Readiness probe: Docker is reachable", Response: GenericResponse{}, Public: true},
+	{Method: "POST", Path: "/handshake", Summary: "Verify the token", Response: GenericResponse{}},
+	{Method: "GET", Path: "/whoami", Summary: "The user the token was issued to", Response: WhoamiResponse{}},
+
+	{Method: "POST", Path: "/server/create", Summary: "Create a server (in the background unless dryRun)", Request: CreateServerRequest{}, Response: CreateServerResponse{}},
+	{Method: "GET", Path: "/server/create/status", Summary: "Progress of a create job", Query: []string{"jobId"}, Response: CreateJobResponse{}},
+	{Method: "POST", Path: "/server/start", Summary: "Start a server", Request: ServerRequest{}, Response: GenericResponse{}},
+	{Method: "POST", Path: "/server/stop", Summary: "Save and stop a server", Request: StopServerRequest{}, Response: StopServerResponse{}},
+	{Method: "POST", Path: "/server/kill", Summary: "Kill a server that won't stop, without saving", Request: KillServerRequest{}, Response: GenericResponse{}},
+	{Method: "POST", Path: "/server/pause", Summary: "Freeze a running server", Request: ServerRequest{}, Response: GenericResponse{}},
+	{Method: "POST", Path: "/server/unpause", Summary: "Resume a paused server", Request: ServerRequest{}, Response: GenericResponse{}},
+	{Method: "POST", Path: "/server/command", Summary: "Run a console command over RCON", Request: ConsoleRequest{}, Response: CommandResponse{}},
+	{Method: "POST", Path: "/server/test-start", Summary: "Start a server and wait for it to boot", Request: TestStartRequest{}, Response: TestStartResponse{}},
+	{Method: "POST", Path: "/server/recreate", Summary: "Replace a server's container with new settings", Request: RecreateServerRequest{}, Response: RecreateServerResponse{}},
+	{Method: "POST", Path: "/server/clone", Summary: "Create a server from a copy of another (in the background)", Request: CloneServerRequest{}, Response: CreateServerResponse{}},
+	{Method: "POST", Path: "/server/resources", Summary: "Change memory and CPU limits in place", Request: ResourcesRequest{}, Response: ResourcesResponse{}},
+	{Method: "GET", Path: "/server/autostart", Summary: "Whether a server starts with the host", Query: []string{"server"}, Response: AutostartResponse{}},
+	{Method: "POST", Path: "/server/autostart", Summary: "Enable or disable autostart", Request: AutostartRequest{}, Response: AutostartResponse{}},
+	{Method: "POST", Path: "/server/migrate", Summary: "Move a server's volume to another disk", Request: MigrateVolumeRequest{}, Response: MigrateVolumeResponse{}},
+	{Method: "POST", Path: "/server/backup", Summary: "Back up a server's volume", Request: BackupRequest{}, Response: BackupResponse{}},
+	{Method: "GET", Path: "/server/backup/schedule", Summary: "A server's backup schedule", Query: []string{"server"}, Response: BackupScheduleResponse{}},
+	{Method: "POST", Path: "/server/backup/schedule", Summary: "Set a server's backup schedule", Request: BackupScheduleRequest{}, Response: BackupScheduleResponse{}},
+	{Method: "DELETE", Path: "/server/backup/schedule", Summary: "Remove a server's backup schedule", Request: ServerRequest{}, Response: BackupScheduleResponse{}},
+	{Method: "POST", Path: "/server/backup/prune", Summary: "Delete old backups", Request: PruneBackupsRequest{}, Response: PruneBackupsResponse{}},
+	{Method: "GET", Path: "/server/backup/download", Summary: "Download a backup", Query: []string{"server", "file"}, Media: "application/gzip"},
+	{Method: "POST", Path: "/server/restore", Summary: "Restore a server from a backup", Request: RestoreRequest{}, Response: RestoreResponse{}},
+	{Method: "GET", Path: "/server/list", Summary: "A user's servers", Query: []string{"userEmail", "state"}, Response: []ServerSummary{}},
+	{Method: "GET", Path: "/server/status", Summary: "Container state and uptime", Query: []string{"server"}, Response: ServerStatusResponse{}},
+	{Method: "GET", Path: "/server/stats", Summary: "CPU, memory, network and disk I/O", Query: []string{"server"}, Response: ServerStatsResponse{}},
+	{Method: "GET", Path: "/server/logs", Summary: "Recent container logs", Query: []string{"server", "tail", "since"}, Response: ServerLogsResponse{}},
+	{Method: "GET", Path: "/server/logs/stream", Summary: "Follow console output as Server-Sent Events", Query: []string{"server", "tail"}, Media: "text/event-stream"},
+	{Method: "GET", Path: "/server/disk", Summary: "Volume size and quota", Query: []string{"server"}, Response: ServerDiskResponse{}},
+	{Method: "GET", Path: "/server/port", Summary: "The host port players connect to", Query: []string{"server"}, Response: ServerPortResponse{}},
+	{Method: "GET", Path: "/server/lock", Summary: "The operation running on a server, if any", Query: []string{"server"}, Response: ServerLockResponse{}},
+	{Method: "GET", Path: "/server/crashes", Summary: "Recent crashes", Query: []string{"server"}, Response: CrashListResponse{}},
+	{Method: "POST", Path: "/server/max-players", Summary: "Set max-players", Request: MaxPlayersRequest{}, Response: MaxPlayersResponse{}},
+	{Method: "GET", Path: "/server/properties", Summary: "Read server.properties", Query: []string{"server"}, Response: ServerPropertiesResponse{}},
+	{Method: "PUT", Path: "/server/properties", Summary: "Update server.properties", Request: ServerPropertiesRequest{}, Response: ServerPropertiesResponse{}},
+	{Method: "GET", Path: "/server/whitelist", Summary: "List the whitelist", Query: []string{"server"}, Response: WhitelistResponse{}},
+	{Method: "POST", Path: "/server/whitelist", Summary: "Add a player to the whitelist", Request: PlayerRequest{}, Response: WhitelistResponse{}},
+	{Method: "DELETE", Path: "/server/whitelist", Summary: "Remove a player from the whitelist", Request: PlayerRequest{}, Response: WhitelistResponse{}},
+	{Method: "POST", Path: "/server/op", Summary: "Make a player an operator", Request: PlayerRequest{}, Response: OpsResponse{}},
+	{Method: "POST", Path: "/server/deop", Summary: "Take operator status away", Request: PlayerRequest{}, Response: OpsResponse{}},
+	{Method: "GET", Path: "/server/players", Summary: "Online players", Query: []string{"server"}, Response: ServerPlayersResponse{}},
+	{Method: "GET", Path: "/server/plugins", Summary: "Installed plugins and mods", Query: []string{"server"}, Response: PluginListResponse{}},
+	{Method: "POST", Path: "/server/plugins/toggle", Summary: "Enable or disable a plugin", Request: TogglePluginRequest{}, Response: TogglePluginResponse{}},
+
+	{Method: "GET", Path: "/file_manager", Summary: "Read a text file", Query: []string{"server", "path"}, Response: FileContentResponse{}},
+	{Method: "POST", Path: "/file_manager", Summary: "Write a text file", Request: WriteFileRequest{}, Response: WriteFileResponse{}},
+	{Method: "GET", Path: "/file_manager/list", Summary: "List a directory", Query: []string{"server", "path"}, Response: []FileEntry{}},
+	{Method: "GET", Path: "/file/stat", Summary: "Describe a file or directory", Query: []string{"server", "path"}, Response: FileStat{}},
+	{Method: "POST", Path: "/file/mkdir", Summary: "Create a directory", Request: MkdirRequest{}, Response: GenericResponse{}},
+	{Method: "POST", Path: "/file/delete", Summary: "Delete a file or directory", Request: DeleteFileRequest{}, Response: GenericResponse{}},
+	{Method: "POST", Path: "/file/rename", Summary: "Rename or move a file", Request: RenameFileRequest{}, Response: GenericResponse{}},
+	{Method: "POST", Path: "/file/copy", Summary: "Copy a file or directory", Request: CopyFileRequest{}, Response: GenericResponse{}},
+	{Method: "GET", Path: "/file/search", Summary: "Find files by name or content", Query: []string{"server", "path", "name", "contains"}, Response: SearchResponse{}},
+	{Method: "POST", Path: "/file/batch", Summary: "Run several file operations", Request: BatchFileRequest{}, Response: BatchFileResponse{}},
+	{Method: "POST", Path: "/file/replace", Summary: "Search and replace across files", Request: ReplaceRequest{}, Response: ReplaceResponse{}},
+	{Method: "GET", Path: "/file/upload/chunk", Summary: "Progress of a chunked upload", Query: []string{"server", "uploadId"}, Response: UploadChunkResponse{}},
+	{Method: "POST", Path: "/file/upload/chunk", Summary: "Send one chunk of an upload as the raw body", Query: []string{"server", "path", "uploadId"}, Response: UploadChunkResponse{}},
+	{Method: "POST", Path: "/file/upload/finalize", Summary: "Finish a chunked upload", Request: FinalizeUploadRequest{}, Response: GenericResponse{}},
+	{Method: "GET", Path: "/file/download", Summary: "Download a file", Query: []string{"server", "path"}, Media: "application/octet-stream"},
+	{Method: "POST", Path: "/file/download/zip", Summary: "Download several files as a zip", Request: MultiDownloadRequest{}, Media: "application/zip"},
+	{Method: "GET", Path: "/file/archive", Summary: "Download a directory as a zip", Query: []string{"server", "path"}, Media: "application/zip"},
+	{Method: "POST", Path: "/file/extract", Summary: "Extract an archive in place", Request: ExtractRequest{}, Response: ExtractResponse{}},
+	{Method: "GET", Path: "/ws/file-tree", Summary: "WebSocket: live directory tree", Query: []string{"server", "path", "maxDepth"}},
+	{Method: "GET", Path: "/ws/console", Summary: "WebSocket: live console", Query: []string{"server", "tail"}},
+	{Method: "GET", Path: "/ws/dashboard", Summary: "WebSocket: live stats, status and console", Query: []string{"server", "channels"}},
+
+	{Method: "GET", Path: "/admin/server/diff", Summary: "Files a container changed outside its volume", Query: []string{"server"}, Response: FsDiffResponse{}, Admin: true},
+}
+
+// checkAPIOperations warns about documented endpoints that no route serves,
+// so the document can't silently drift from main.
+func checkAPIOperations() {
+	for _, op := range apiOperations {
+		req, _ := http.NewRequest(op.Method, op.Path, nil)
+		if _, pattern := http.DefaultServeMux.Handler(req); pattern != op.Path {
+			slog.Warn("OpenAPI document lists an unregistered endpoint", "method", op.Method, "path", op.Path)
+		}
+	}
+}
+
+var queryParamDescriptions = map[string]string{
+	"serverName": "Name of the server",
+	"userEmail":  "Owner of the server; taken from the token for per-user JWTs",
+	"path":       "Path relative to the server's volume",
+	"tail":       "Number of log lines to start with",
+	"since":      "Only logs from this long ago, a duration such as 10m",
+	"jobId":      "ID returned by /server/create",
+	"state":      "Only servers in this state, e.g. running",
+	"file":       "Backup file name as returned by /server/backup",
+	"uploadId":   "ID chosen by the client for the upload (or the X-Upload-Id header)",
+	"name":       "Glob matched against file names",
+	"contains":   "Text the file must contain",
+	"maxDepth":   "How many directory levels to include",
+	"channels":   "Comma-separated channels: stats, status, console",
+}
+
+// openAPISpec builds the OpenAPI 3 document from apiOperations.
+func openAPISpec() map[string]interface{} {
+	g := &schemaGen{schemas: map[string]interface{}{}}
+	errorResponse := map[string]interface{}{
+		"description": "Error",
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(GenericResponse{}))}},
+	}
+	paths := map[string]map[string]interface{}{}
+	for _, op := range apiOperations {
+		o := map[string]interface{}{
+			"summary":     op.Summary,
+			"operationId": operationId(op),
+		}
+		var params []interface{}
+		for _, q := range op.Query {
+			names := []string{q}
+			if q == "server" {
+				names = []string{"serverName", "userEmail"}
+			}
+			for _, name := range names {
+				params = append(params, map[string]interface{}{
+					"name":        name,
+					"in":          "query",
+					"description": queryParamDescriptions[name],
+					"schema":      map[string]string{"type": "string"},
+				})
+			}
+		}
+		if len(params) > 0 {
+			o["parameters"] = params
+		}
+		if op.Request != nil {
+			o["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Request))}},
+			}
+		}
+		ok := map[string]interface{}{"description": "OK"}
+		switch {
+		case op.Response != nil:
+			ok["content"] = map[string]interface{}{"application/json": map[string]interface{}{"schema": g.schema(reflect.TypeOf(op.Response))}}
+		case op.Media != "":
+			ok["content"] = map[string]interface{}{op.Media: map[string]interface{}{"schema": map[string]string{"type": "string", "format": "binary"}}}
+		case strings.HasPrefix(op.Path, "/ws/"):
+			ok = map[string]interface{}{"description": "Switching Protocols"}
+		}
+		code := "200"
+		if strings.HasPrefix(op.Path, "/ws/") {
+			code = "101"
+		}
+		o["responses"] = map[string]interface{}{code: ok, "default": errorResponse}
+		switch {
+		case op.Public:
+			o["security"] = []interface{}{}
+		case op.Admin:
+			o["security"] = []interface{}{map[string][]string{"adminToken": {}}}
+		}
+		if paths[op.Path] == nil {
+			paths[op.Path] = map[string]interface{}{}
+		}
+		paths[op.Path][strings.ToLower(op.Method)] = o
+	}
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info": map[string]interface{}{
+			"title":   "mcnode agent API",
+			"version": "1",
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"schemas": g.schemas,
+			"securitySchemes": map[string]interface{}{
+				"bearerAuth": map[string]string{"type": "http", "scheme": "bearer", "description": "HANDSHAKE_TOKEN or a per-user JWT"},
+				"adminToken": map[string]string{"type": "http", "scheme": "bearer", "description": "ADMIN_TOKEN"},
+			},
+		},
+		"security": []interface{}{map[string][]string{"bearerAuth": {}}},
+	}
+}
+
+// operationId names an operation after its method and path, e.g.
+// postServerBackupPrune.
+func operationId(op apiOperation) string {
+	id := strings.ToLower(op.Method)
+	for _, part := range strings.FieldsFunc(op.Path, func(r rune) bool { return r == '/' || r == '-' || r == '_' }) {
+		id += strings.ToUpper(part[:1]) + part[1:]
+	}
+	return id
+}
+
+// schemaGen turns Go types into OpenAPI schemas the way encoding/json would
+// encode them. Named structs go into components and are referenced.
+type schemaGen struct {
+	schemas map[string]interface{}
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func (g *schemaGen) schema(t reflect.Type) map[string]interface{} {
+	switch {
+	case t == timeType:
+		return map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return map[string]interface{}{}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		s := g.schema(t.Elem())
+		if _, isRef := s["$ref"]; !isRef {
+			s["nullable"] = true
+		}
+		return s
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return map[string]interface{}{"type": "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return map[string]interface{}{"type": "integer", "format": "int64"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string", "format": "byte"}
+		}
+		return map[string]interface{}{"type": "array", "items": g.schema(t.Elem())}
+	case reflect.Map:
+		return map[string]interface{}{"type": "object", "additionalProperties": g.schema(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return g.object(t)
+		}
+		name := t.Name()
+		if _, ok := g.schemas[name]; !ok {
+			g.schemas[name] = nil // placeholder, in case the type refers to itself
+			g.schemas[name] = g.object(t)
+		}
+		return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+	default:
+		return map[string]interface{}{}
+	}
+}
+
+func (g *schemaGen) object(t reflect.Type) map[string]interface{} {
+	props := map[string]interface{}{}
+	g.addFields(t, props)
+	return map[string]interface{}{"type": "object", "properties": props}
+}
+
+// addFields adds t's JSON fields to props, flattening embedded structs as
+// encoding/json does.
+func (g *schemaGen) addFields(t reflect.Type, props map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		ft := f.Type
+		if f.Anonymous && tag == "" {
+			if ft.Kind() == reflect.Pointer {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				g.addFields(ft, props)
+				continue
+			}
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		props[tag] = g.schema(ft)
+	}
+}
+
+var (
+	openAPIOnce sync.Once
+	openAPIJSON []byte
+)
+
+// openAPIHandler serves the OpenAPI document. It needs no token, like the
+// probes: it describes the API but holds nothing secret.
+func openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	openAPIOnce.Do(func() {
+		openAPIJSON, _ = json.MarshalIndent(openAPISpec(), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIJSON)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+)
+
+const opsFile = "ops.json"
+
+// OpEntry is one operator in ops.json.
+type OpEntry struct {
+	UUID                string `json:"uuid"`
+	Name                string `json:"name"`
+	Level               int    `json:"level"`
+	BypassesPlayerLimit bool   `json:"bypassesPlayerLimit"`
+}
+
+type OpsResponse struct {
+	Status  string    `json:"status"`
+	Message string    `json:"message"`
+	Ops     []OpEntry `json:"ops"`
+	// AppliedLive is set when the running server was sent the op or deop
+	// command too.
+	AppliedLive bool `json:"appliedLive"`
+}
+
+// opHandler makes a player an operator.
+func opHandler(w http.ResponseWriter, r *http.Request) {
+	updateOps(w, r, true)
+}
+
+// deopHandler takes operator status away from a player.
+func deopHandler(w http.ResponseWriter, r *http.Request) {
+	updateOps(w, r, false)
+}
+
+// updateOps adds the player to ops.json or removes them, so the change
+// survives restarts, then runs op or deop on a running server so it applies
+// at once. New operators get the server's op-permission-level (default 4).
+func updateOps(w http.ResponseWriter, r *http.Request, op bool) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	req, ok := decodePlayerRequest(w, r)
+	if !ok {
+		return
+	}
+	player := req.Player
+	if player == "" {
+		player = req.UUID
+	}
+	verb := "deop "
+	if op {
+		verb = "op "
+	}
+	if !allowCommand(w, r, "ops", req.ServerName, req.UserEmail, verb+player) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "ops")
+	if !ok {
+		return
+	}
+	defer unlock()
+	if _, ok := inspectServer(w, r, containerId); !ok {
+		return
+	}
+	ops := []OpEntry{}
+	if err := readPlayerFile(containerId, opsFile, &ops); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read ops: "+err.Error())
+		return
+	}
+
+	var msg, command string
+	if op {
+		if !resolvePlayer(w, r, containerId, &req) {
+			return
+		}
+		for _, e := range ops {
+			if samePlayer(req, e.Name, e.UUID) {
+				writeJSON(w, http.StatusOK, OpsResponse{Status: "ok", Message: e.Name + " is already an operator", Ops: ops})
+				return
+			}
+		}
+		lines, _ := readServerProperties(containerId)
+		level, err := strconv.Atoi(propertiesMap(lines)["op-permission-level"])
+		if err != nil || level < 1 || level > 4 {
+			level = 4
+		}
+		ops = append(ops, OpEntry{UUID: req.UUID, Name: req.Player, Level: level})
+		msg = "Made " + req.Player + " an operator"
+		command = "op " + req.Player
+	} else {
+		kept := ops[:0]
+		for _, e := range ops {
+			if samePlayer(req, e.Name, e.UUID) {
+				msg = e.Name + " is no longer an operator"
+				command = "deop " + e.Name
+				continue
+			}
+			kept = append(kept, e)
+		}
+		if msg == "" {
+			writeError(w, http.StatusNotFound, "Player is not an operator")
+			return
+		}
+		ops = kept
+	}
+	if err := writePlayerFile(containerId, opsFile, ops); err != nil {
+		auditCommand(r, "ops", req.ServerName, req.UserEmail, command, auditFailed, err.Error())
+		writeError(w, http.StatusInternalServerError, "Failed to write ops: "+err.Error())
+		return
+	}
+	auditCommand(r, "ops", req.ServerName, req.UserEmail, command, auditOK, msg)
+	writeJSON(w, http.StatusOK, OpsResponse{
+		Status:      "ok",
+		Message:     msg,
+		Ops:         ops,
+		AppliedLive: reloadIfRunning(r, containerId, command),
+	})
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// PlayerList is the parsed reply of the "list" console command.
+type PlayerList struct {
+	Online  int      `json:"online"`
+	Max     int      `json:"max"`
+	Players []string `json:"players"`
+}
+
+// listPattern matches both the modern "There are 2 of a max of 20 players
+// online: a, b" and the pre-1.13 "There are 2/20 players online:" replies.
+var listPattern = regexp.MustCompile(`There are (\d+)(?: of a max of |/)(\d+) players online:?(.*)`)
+
+func parsePlayerList(out string) (PlayerList, error) {
+	m := listPattern.FindStringSubmatch(strings.ReplaceAll(out, "\n", " "))
+	if m == nil {
+		return PlayerList{}, errors.New("unexpected list output: " + out)
+	}
+	online, _ := strconv.Atoi(m[1])
+	max, _ := strconv.Atoi(m[2])
+	players := []string{}
+	for _, name := range strings.FieldsFunc(m[3], func(r rune) bool { return r == ',' || r == ' ' }) {
+		players = append(players, name)
+	}
+	return PlayerList{Online: online, Max: max, Players: players}, nil
+}
+
+// listPlayers asks a running server who is online.
+func listPlayers(containerId string) (PlayerList, error) {
+	out, err := runRcon(containerId, "list")
+	if err != nil {
+		return PlayerList{}, errors.New(out)
+	}
+	return parsePlayerList(out)
+}
+
+type ServerPlayersResponse struct {
+	Status string `json:"status"`
+	PlayerList
+	// Ready is false when the server is stopped or doesn't answer RCON
+	// yet, such as while it loads the world; the list is then empty.
+	Ready bool `json:"ready"`
+}
+
+// serverPlayersHandler reports who is online. A server that is stopped or
+// still starting has nobody online rather than being an error; max then comes
+// from server.properties.
+func serverPlayersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	if info.State.Running {
+		list, err := listPlayers(containerId)
+		if err == nil {
+			writeJSON(w, http.StatusOK, ServerPlayersResponse{Status: "ok", PlayerList: list, Ready: true})
+			return
+		}
+		requestLogger(r).Debug("Player list unavailable", "server", containerId, "err", err)
+	}
+	lines, _ := readServerProperties(containerId)
+	max, _ := strconv.Atoi(propertiesMap(lines)["max-players"])
+	writeJSON(w, http.StatusOK, ServerPlayersResponse{Status: "ok", PlayerList: PlayerList{Max: max, Players: []string{}}})
+}
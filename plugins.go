@@ -0,0 +1,389 @@
+package main
+
+import (
+	"archive/zip"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// PluginInfo describes one jar found in a server's plugins/ or mods/ folder.
+type PluginInfo struct {
+	Name    string `json:"name"`
+	Version string `json:"version"`
+	File    string `json:"file"`
+	Dir     string `json:"dir"`
+	Loader  string `json:"loader,omitempty"`
+	// Enabled is false for jars renamed to *.jar.disabled, which the
+	// server doesn't load.
+	Enabled bool   `json:"enabled"`
+	Error   string `json:"error,omitempty"`
+}
+
+type PluginListResponse struct {
+	Status  string       `json:"status"`
+	Plugins []PluginInfo `json:"plugins"`
+}
+
+var pluginDirs = []string{"plugins", "mods"}
+
+// disabledSuffix is appended to a plugin jar's name to keep the server from
+// loading it.
+const disabledSuffix = ".disabled"
+
+// maxMetadataSize bounds how much of a single metadata entry we read from a jar.
+const maxMetadataSize = 1 << 20
+
+func listPluginsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	plugins := []PluginInfo{}
+	for _, dir := range pluginDirs {
+		dirPath, err := resolveServerPath(containerId, dir)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		entries, err := os.ReadDir(dirPath)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to read "+dir+": "+err.Error())
+			return
+		}
+		for _, e := range entries {
+			jar, enabled := pluginJarName(e.Name())
+			if e.IsDir() || jar == "" {
+				continue
+			}
+			info := readJarMetadata(filepath.Join(dirPath, e.Name()))
+			if info.Name == "" {
+				info.Name = strings.TrimSuffix(jar, filepath.Ext(jar))
+			}
+			info.File = e.Name()
+			info.Dir = dir
+			info.Enabled = enabled
+			plugins = append(plugins, info)
+		}
+	}
+
+	sort.Slice(plugins, func(i, j int) bool {
+		return strings.ToLower(plugins[i].Name) < strings.ToLower(plugins[j].Name)
+	})
+	writeJSON(w, http.StatusOK, PluginListResponse{Status: "ok", Plugins: plugins})
+}
+
+// pluginJarName returns the jar's name without any disabledSuffix and whether
+// it is enabled, or "" when the file isn't a plugin jar.
+func pluginJarName(file string) (jar string, enabled bool) {
+	jar = file
+	enabled = !strings.HasSuffix(strings.ToLower(jar), disabledSuffix)
+	if !enabled {
+		jar = jar[:len(jar)-len(disabledSuffix)]
+	}
+	if !strings.HasSuffix(strings.ToLower(jar), ".jar") {
+		return "", false
+	}
+	return jar, enabled
+}
+
+type TogglePluginRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// File is the jar as listed by /server/plugins, either a bare name in
+	// plugins/ or prefixed with its folder, e.g. "mods/jei.jar".
+	File    string `json:"file"`
+	Enabled bool   `json:"enabled"`
+}
+
+type TogglePluginResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+	File    string `json:"file"`
+	Dir     string `json:"dir"`
+	Enabled bool   `json:"enabled"`
+}
+
+// togglePluginHandler enables or disables a plugin jar by removing or adding
+// its disabledSuffix. The change takes effect when the server next starts.
+func togglePluginHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req TogglePluginRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.File == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and file are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	dir, file := path.Split(path.Clean(strings.TrimPrefix(req.File, "/")))
+	dir = strings.TrimSuffix(dir, "/")
+	if dir == "" {
+		dir = pluginDirs[0]
+	}
+	jar, enabled := pluginJarName(file)
+	if jar == "" {
+		writeError(w, http.StatusBadRequest, "file must be a .jar or .jar"+disabledSuffix)
+		return
+	}
+	inPluginDir := false
+	for _, d := range pluginDirs {
+		inPluginDir = inPluginDir || d == dir
+	}
+	if !inPluginDir {
+		writeError(w, http.StatusBadRequest, "file must be directly in "+strings.Join(pluginDirs, " or "))
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "plugin toggle")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	target := jar
+	if !req.Enabled {
+		target += disabledSuffix
+	}
+	state := "disabled"
+	if req.Enabled {
+		state = "enabled"
+	}
+	msg := "Plugin " + jar + " is already " + state
+	if enabled != req.Enabled {
+		if err := renamePath(containerId, dir+"/"+file, dir+"/"+target, false); err != nil {
+			writeFileOpError(w, err)
+			return
+		}
+		msg = "Plugin " + jar + " " + state + "; restart the server to apply"
+	} else {
+		p, err := resolveServerPath(containerId, dir+"/"+file)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		if _, err := os.Lstat(p); err != nil {
+			writeError(w, http.StatusNotFound, "Plugin not found")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, TogglePluginResponse{
+		Status:  "ok",
+		Message: msg,
+		File:    target,
+		Dir:     dir,
+		Enabled: req.Enabled,
+	})
+}
+
+// readJarMetadata extracts name and version from the first metadata file it
+// recognises. Problems are reported in Error rather than failing the listing.
+func readJarMetadata(path string) PluginInfo {
+	zr, err := zip.OpenReader(path)
+	if err != nil {
+		return PluginInfo{Error: "unreadable jar: " + err.Error()}
+	}
+	defer zr.Close()
+
+	files := make(map[string]*zip.File, len(zr.File))
+	for _, f := range zr.File {
+		files[f.Name] = f
+	}
+
+	manifest := map[string]string{}
+	if f, ok := files["META-INF/MANIFEST.MF"]; ok {
+		if data, err := readZipEntry(f); err == nil {
+			manifest = parseManifest(data)
+		}
+	}
+
+	var info PluginInfo
+	switch {
+	case files["plugin.yml"] != nil || files["paper-plugin.yml"] != nil:
+		f := files["paper-plugin.yml"]
+		if f == nil {
+			f = files["plugin.yml"]
+		}
+		info, err = parsePluginYml(f)
+	case files["fabric.mod.json"] != nil:
+		info, err = parseFabricModJson(files["fabric.mod.json"])
+	case files["META-INF/mods.toml"] != nil:
+		info, err = parseModsToml(files["META-INF/mods.toml"], "forge")
+	case files["META-INF/neoforge.mods.toml"] != nil:
+		info, err = parseModsToml(files["META-INF/neoforge.mods.toml"], "neoforge")
+	default:
+		info.Name = manifest["Implementation-Title"]
+		info.Version = manifest["Implementation-Version"]
+		if info.Name == "" {
+			err = errors.New("no plugin metadata found")
+		}
+	}
+	if err != nil {
+		info.Error = err.Error()
+	}
+	// Forge mods.toml commonly defers the version to the jar manifest.
+	if strings.HasPrefix(info.Version, "${") {
+		info.Version = manifest["Implementation-Version"]
+	}
+	return info
+}
+
+func readZipEntry(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(io.LimitReader(rc, maxMetadataSize))
+}
+
+// parsePluginYml reads the top-level name and version keys of a Bukkit/Paper
+// plugin.yml. Only flat scalar keys are needed, so no YAML library is used.
+func parsePluginYml(f *zip.File) (PluginInfo, error) {
+	info := PluginInfo{Loader: "bukkit"}
+	data, err := readZipEntry(f)
+	if err != nil {
+		return info, err
+	}
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for sc.Scan() {
+		line := sc.Text()
+		if line == "" || line[0] == ' ' || line[0] == '\t' || line[0] == '#' {
+			continue
+		}
+		key, value, ok := strings.Cut(line, ":")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "name":
+			info.Name = unquote(value)
+		case "version":
+			info.Version = unquote(value)
+		}
+	}
+	if info.Name == "" {
+		return info, errors.New(f.Name + " has no name")
+	}
+	return info, nil
+}
+
+func parseFabricModJson(f *zip.File) (PluginInfo, error) {
+	info := PluginInfo{Loader: "fabric"}
+	data, err := readZipEntry(f)
+	if err != nil {
+		return info, err
+	}
+	var meta struct {
+		ID      string `json:"id"`
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return info, errors.New("invalid fabric.mod.json: " + err.Error())
+	}
+	info.Name = meta.Name
+	if info.Name == "" {
+		info.Name = meta.ID
+	}
+	info.Version = meta.Version
+	return info, nil
+}
+
+// parseModsToml reads the first [[mods]] table of a Forge/NeoForge mods.toml.
+func parseModsToml(f *zip.File, loader string) (PluginInfo, error) {
+	info := PluginInfo{Loader: loader}
+	data, err := readZipEntry(f)
+	if err != nil {
+		return info, err
+	}
+	var modId string
+	inMods := false
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for sc.Scan() {
+		line := strings.TrimSpace(sc.Text())
+		if strings.HasPrefix(line, "[") {
+			if inMods && (modId != "" || info.Name != "") {
+				break
+			}
+			inMods = line == "[[mods]]"
+			continue
+		}
+		if !inMods {
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			continue
+		}
+		switch strings.TrimSpace(key) {
+		case "modId":
+			modId = unquote(value)
+		case "displayName":
+			info.Name = unquote(value)
+		case "version":
+			info.Version = unquote(value)
+		}
+	}
+	if info.Name == "" {
+		info.Name = modId
+	}
+	if info.Name == "" {
+		return info, errors.New(f.Name + " has no [[mods]] entry")
+	}
+	return info, nil
+}
+
+func parseManifest(data []byte) map[string]string {
+	m := map[string]string{}
+	sc := bufio.NewScanner(strings.NewReader(string(data)))
+	for sc.Scan() {
+		key, value, ok := strings.Cut(sc.Text(), ":")
+		if ok {
+			m[strings.TrimSpace(key)] = strings.TrimSpace(value)
+		}
+	}
+	return m
+}
+
+// unquote trims whitespace, trailing comments and surrounding quotes from a
+// YAML/TOML scalar.
+func unquote(s string) string {
+	s = strings.TrimSpace(s)
+	if len(s) >= 2 && (s[0] == '"' || s[0] == '\'') {
+		if end := strings.IndexByte(s[1:], s[0]); end >= 0 {
+			return s[1 : end+1]
+		}
+	}
+	if i := strings.Index(s, " #"); i >= 0 {
+		s = strings.TrimSpace(s[:i])
+	}
+	return s
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/go-connections/nat"
+)
+
+// portLabel records the host port a server's game port is published on, so
+// allocations can be read back from the containers themselves.
+const portLabel = "mcnode.port"
+
+// Game servers listen on 25565 inside the container, the proxy image on
+// 25577.
+const (
+	serverGamePort = 25565
+	proxyGamePort  = 25577
+)
+
+func gamePort(typeEnv string) nat.Port {
+	if isProxyType(typeEnv) {
+		return nat.Port(strconv.Itoa(proxyGamePort) + "/tcp")
+	}
+	return nat.Port(strconv.Itoa(serverGamePort) + "/tcp")
+}
+
+var (
+	errPortInUse  = errors.New("port is already assigned to another server")
+	errNoFreePort = errors.New("no free port left in PORT_RANGE")
+)
+
+// portAllocator hands out host ports from PORT_RANGE. Ports of existing
+// servers are read from their portLabel; ports of servers still being created
+// are held in reserved until the container exists. A deleted container's
+// port is free again as soon as it is gone.
+type portAllocator struct {
+	min, max int
+
+	mu       sync.Mutex
+	reserved map[int]string
+}
+
+var ports = &portAllocator{min: 25565, max: 25664, reserved: map[int]string{}}
+
+// configurePorts reads PORT_RANGE, e.g. 25565-25664, the host ports new
+// servers are given when they don't ask for one.
+func configurePorts() {
+	v := os.Getenv("PORT_RANGE")
+	if v == "" {
+		return
+	}
+	lo, hi, ok := strings.Cut(v, "-")
+	min, err1 := strconv.Atoi(strings.TrimSpace(lo))
+	max, err2 := strconv.Atoi(strings.TrimSpace(hi))
+	if !ok || err1 != nil || err2 != nil || min < 1 || max > 65535 || min > max {
+		fatal("Invalid PORT_RANGE: use <first>-<last>, e.g. 25565-25664", "value", v)
+	}
+	ports.min, ports.max = min, max
+}
+
+// assignedPorts maps the host ports of all managed containers to their names.
+func assignedPorts() (map[int]string, error) {
+	containers, err := dockerClient.ContainerList(context.Background(), container.ListOptions{
+		All:     true,
+		Filters: filters.NewArgs(filters.Arg("label", managedLabel)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	used := map[int]string{}
+	for _, c := range containers {
+		if p, err := strconv.Atoi(c.Labels[portLabel]); err == nil && len(c.Names) > 0 {
+			used[p] = strings.TrimPrefix(c.Names[0], "/")
+		}
+	}
+	return used, nil
+}
+
+// allocate reserves want for containerId, or the first free port of the range
+// when want is 0. The reservation lasts until release.
+func (a *portAllocator) allocate(containerId string, want int) (int, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	used, err := assignedPorts()
+	if err != nil {
+		return 0, err
+	}
+	taken := func(p int) bool {
+		if owner, ok := used[p]; ok && owner != containerId {
+			return true
+		}
+		owner, ok := a.reserved[p]
+		return ok && owner != containerId
+	}
+	if want != 0 {
+		if taken(want) {
+			return 0, errPortInUse
+		}
+		a.reserved[want] = containerId
+		return want, nil
+	}
+	for p := a.min; p <= a.max; p++ {
+		if taken(p) || !hostPortFree(p) {
+			continue
+		}
+		a.reserved[p] = containerId
+		return p, nil
+	}
+	return 0, errNoFreePort
+}
+
+func (a *portAllocator) release(port int) {
+	a.mu.Lock()
+	delete(a.reserved, port)
+	a.mu.Unlock()
+}
+
+// hostPortFree reports whether nothing else on the host listens on port, such
+// as a server that isn't managed by the agent.
+func hostPortFree(port int) bool {
+	l, err := net.Listen("tcp", ":"+strconv.Itoa(port))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// publishPort makes the plan publish its game port on hostPort.
+func (p *createPlan) publishPort(hostPort int) {
+	port := gamePort(p.TypeEnv)
+	p.Port = hostPort
+	p.Config.ExposedPorts = nat.PortSet{port: struct{}{}}
+	p.Config.Labels[portLabel] = strconv.Itoa(hostPort)
+	p.HostConfig.PortBindings = nat.PortMap{port: {{HostPort: strconv.Itoa(hostPort)}}}
+	p.Summary += fmt.Sprintf(", port %d", hostPort)
+}
+
+type ServerPortResponse struct {
+	Status string `json:"status"`
+	Port   int    `json:"port"`
+}
+
+// serverPortHandler returns the host port a server's game port is published on.
+func serverPortHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	env := map[string]string{}
+	for _, kv := range info.Config.Env {
+		if k, v, ok := strings.Cut(kv, "="); ok {
+			env[k] = v
+		}
+	}
+	for _, b := range info.HostConfig.PortBindings[gamePort(env["TYPE"])] {
+		if port, err := strconv.Atoi(b.HostPort); err == nil {
+			writeJSON(w, http.StatusOK, ServerPortResponse{Status: "ok", Port: port})
+			return
+		}
+	}
+	writeError(w, http.StatusNotFound, "Server has no published game port")
+}
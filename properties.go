@@ -0,0 +1,438 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+const serverPropertiesFile = "server.properties"
+
+// setServerProperty sets key in the server's server.properties, keeping every
+// other line as is. The key is appended if it isn't present yet.
+func setServerProperty(containerId, key, value string) error {
+	_, err := setServerProperties(containerId, map[string]string{key: value})
+	return err
+}
+
+// readServerProperties returns the lines of the server's server.properties,
+// or none when the server hasn't written it yet.
+func readServerProperties(containerId string) ([]string, error) {
+	path, err := resolveServerPath(containerId, serverPropertiesFile)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+	return strings.Split(strings.TrimRight(strings.ReplaceAll(string(data), "\r\n", "\n"), "\n"), "\n"), nil
+}
+
+// parsePropertyLine splits a key=value line. Comments and blank lines aren't
+// properties. Keys and values are unescaped as Java properties are.
+func parsePropertyLine(line string) (key, value string, ok bool) {
+	trimmed := strings.TrimSpace(line)
+	if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+		return "", "", false
+	}
+	for i := 0; i < len(trimmed); i++ {
+		switch trimmed[i] {
+		case '\\':
+			i++
+		case '=', ':':
+			return unescapeProperty(strings.TrimSpace(trimmed[:i])), unescapeProperty(strings.TrimSpace(trimmed[i+1:])), true
+		}
+	}
+	return unescapeProperty(trimmed), "", true
+}
+
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, "\\") {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i+1 == len(s) {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if n, err := strconv.ParseUint(s[i+1:min(i+5, len(s))], 16, 16); err == nil && i+5 <= len(s) {
+				b.WriteRune(rune(n))
+				i += 4
+				continue
+			}
+			b.WriteByte('u')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// escapePropertyValue escapes what Java properties can't hold literally.
+// Non-ASCII characters, such as the section sign in a coloured MOTD, become
+// \uXXXX, which every server version reads back correctly.
+func escapePropertyValue(s string) string {
+	var b strings.Builder
+	for _, c := range s {
+		switch {
+		case c == '\\':
+			b.WriteString("\\\\")
+		case c > 0x7e:
+			if c > 0xffff {
+				for _, u := range utf16.Encode([]rune{c}) {
+					fmt.Fprintf(&b, "\\u%04X", u)
+				}
+			} else {
+				fmt.Fprintf(&b, "\\u%04X", c)
+			}
+		default:
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+// propertiesMap returns the properties of lines; a key set twice keeps its
+// last value, as the server reads it.
+func propertiesMap(lines []string) map[string]string {
+	props := map[string]string{}
+	for _, line := range lines {
+		if k, v, ok := parsePropertyLine(line); ok {
+			props[k] = v
+		}
+	}
+	return props
+}
+
+// setServerProperties merges updates into the server's server.properties.
+// Lines of existing keys are rewritten in place, comments and the order of
+// everything else are kept, and new keys are appended in sorted order. It
+// returns the resulting properties.
+func setServerProperties(containerId string, updates map[string]string) (map[string]string, error) {
+	path, err := resolveServerPath(containerId, serverPropertiesFile)
+	if err != nil {
+		return nil, err
+	}
+	lines, err := readServerProperties(containerId)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	found := map[string]bool{}
+	for i, line := range lines {
+		k, _, ok := parsePropertyLine(line)
+		if v, update := updates[k]; ok && update {
+			lines[i] = k + "=" + escapePropertyValue(v)
+			found[k] = true
+		}
+	}
+	keys := make([]string, 0, len(updates))
+	for k := range updates {
+		if !found[k] {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		lines = append(lines, k+"="+escapePropertyValue(updates[k]))
+	}
+	if err := writeFileAtomic(path, []byte(strings.Join(lines, "\n")+"\n"), 0644); err != nil {
+		return nil, err
+	}
+	return propertiesMap(lines), nil
+}
+
+type MaxPlayersRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	MaxPlayers int    `json:"maxPlayers"`
+}
+
+type MaxPlayersResponse struct {
+	Status          string `json:"status"`
+	Message         string `json:"message"`
+	MaxPlayers      int    `json:"maxPlayers"`
+	AppliedLive     bool   `json:"appliedLive"`
+	RestartRequired bool   `json:"restartRequired"`
+}
+
+const maxPlayersLimit = 10000
+
+// liveMaxPlayersCommand returns the console command that changes max-players
+// without a restart, or "" if the software has no such mechanism. Vanilla,
+// Forge and Fabric have none; on plugin platforms operators can configure the
+// command their plugin provides via MAX_PLAYERS_LIVE_COMMAND, using {value}
+// as the placeholder.
+func liveMaxPlayersCommand(typeEnv string, maxPlayers int) string {
+	tmpl := envOr("MAX_PLAYERS_LIVE_COMMAND", "")
+	if tmpl == "" || (typeEnv != "PAPER" && typeEnv != "SPIGOT") {
+		return ""
+	}
+	return strings.ReplaceAll(tmpl, "{value}", strconv.Itoa(maxPlayers))
+}
+
+func maxPlayersHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req MaxPlayersRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if req.MaxPlayers < 1 || req.MaxPlayers > maxPlayersLimit {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("maxPlayers must be between 1 and %d", maxPlayersLimit))
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "max-players")
+	if !ok {
+		return
+	}
+	defer unlock()
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+
+	if err := setServerProperty(containerId, "max-players", strconv.Itoa(req.MaxPlayers)); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update server.properties: "+err.Error())
+		return
+	}
+
+	resp := MaxPlayersResponse{Status: "ok", MaxPlayers: req.MaxPlayers, RestartRequired: true}
+	if info.State.Running {
+		env, err := containerEnv(containerId)
+		if err == nil {
+			if cmd := liveMaxPlayersCommand(env["TYPE"], req.MaxPlayers); cmd != "" {
+				if _, err := runRcon(containerId, cmd); err == nil {
+					resp.AppliedLive = true
+					resp.RestartRequired = false
+				}
+			}
+		}
+	} else {
+		// Picked up on the next start anyway.
+		resp.RestartRequired = false
+	}
+
+	switch {
+	case resp.AppliedLive:
+		resp.Message = "max-players updated and applied live"
+	case resp.RestartRequired:
+		resp.Message = "max-players updated; restart the server to apply it"
+	default:
+		resp.Message = "max-players updated; it will apply when the server starts"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+type ServerPropertiesRequest struct {
+	ServerName string            `json:"serverName"`
+	UserEmail  string            `json:"userEmail"`
+	Properties map[string]string `json:"properties"`
+}
+
+type ServerPropertiesResponse struct {
+	Status     string            `json:"status"`
+	Message    string            `json:"message,omitempty"`
+	Properties map[string]string `json:"properties"`
+	// RestartRequired is set after an update of a running server, which
+	// only reads server.properties on start.
+	RestartRequired bool `json:"restartRequired,omitempty"`
+}
+
+var propertyKeyPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9._-]*$`)
+
+// managedProperties are kept as the image and the agent set them: the
+// console needs RCON, and the port is the one published for the container.
+var managedProperties = map[string]bool{
+	"server-port":   true,
+	"enable-rcon":   true,
+	"rcon.port":     true,
+	"rcon.password": true,
+}
+
+// Value rules for well-known keys. Keys not listed take any single-line value.
+var (
+	booleanProperties = map[string]bool{
+		"allow-flight": true, "allow-nether": true, "broadcast-console-to-ops": true,
+		"broadcast-rcon-to-ops": true, "enable-command-block": true, "enable-jmx-monitoring": true,
+		"enable-query": true, "enable-status": true, "enforce-secure-profile": true,
+		"enforce-whitelist": true, "force-gamemode": true, "generate-structures": true,
+		"hardcore": true, "hide-online-players": true, "online-mode": true,
+		"prevent-proxy-connections": true, "pvp": true, "require-resource-pack": true,
+		"spawn-animals": true, "spawn-monsters": true, "spawn-npcs": true,
+		"sync-chunk-writes": true, "use-native-transport": true, "white-list": true,
+	}
+	// integerProperties maps keys to their allowed range.
+	integerProperties = map[string][2]int{
+		"entity-broadcast-range-percentage": {10, 1000},
+		"function-permission-level":         {1, 4},
+		"max-chained-neighbor-updates":      {-1, 1 << 30},
+		"max-players":                       {1, maxPlayersLimit},
+		"max-tick-time":                     {-1, 1 << 30},
+		"max-world-size":                    {1, 29999984},
+		"network-compression-threshold":     {-1, 65535},
+		"op-permission-level":               {0, 4},
+		"player-idle-timeout":               {0, 1 << 30},
+		"query.port":                        {1, 65535},
+		"rate-limit":                        {0, 1 << 30},
+		"simulation-distance":               {3, 32},
+		"spawn-protection":                  {0, 1 << 30},
+		"view-distance":                     {3, 32},
+	}
+	enumProperties = map[string][]string{
+		"difficulty": {"peaceful", "easy", "normal", "hard"},
+		"gamemode":   {"survival", "creative", "adventure", "spectator"},
+	}
+)
+
+// validateProperty checks that key may be set to value.
+func validateProperty(key, value string) error {
+	if !propertyKeyPattern.MatchString(key) {
+		return fmt.Errorf("invalid property name %q", key)
+	}
+	if managedProperties[key] {
+		return fmt.Errorf("%s is managed by the agent and can't be changed", key)
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return fmt.Errorf("%s: value must be a single line", key)
+	}
+	if booleanProperties[key] && value != "true" && value != "false" {
+		return fmt.Errorf("%s must be true or false", key)
+	}
+	if bounds, ok := integerProperties[key]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < bounds[0] || n > bounds[1] {
+			return fmt.Errorf("%s must be a whole number between %d and %d", key, bounds[0], bounds[1])
+		}
+	}
+	if allowed, ok := enumProperties[key]; ok {
+		valid := false
+		for i, a := range allowed {
+			valid = valid || value == a || value == strconv.Itoa(i)
+		}
+		if !valid {
+			return fmt.Errorf("%s must be one of %s", key, strings.Join(allowed, ", "))
+		}
+	}
+	return nil
+}
+
+// serverPropertiesHandler reads (GET) or updates (PUT) server.properties as
+// key-value pairs. A PUT only changes the keys it names.
+func serverPropertiesHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		getServerProperties(w, r)
+	case http.MethodPut:
+		putServerProperties(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func getServerProperties(w http.ResponseWriter, r *http.Request) {
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	lines, err := readServerProperties(containerId)
+	if errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "server.properties not found; the server writes it on its first start")
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read server.properties: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ServerPropertiesResponse{Status: "ok", Properties: propertiesMap(lines)})
+}
+
+func putServerProperties(w http.ResponseWriter, r *http.Request) {
+	var req ServerPropertiesRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Properties) == 0 {
+		writeError(w, http.StatusBadRequest, "properties is required")
+		return
+	}
+	keys := make([]string, 0, len(req.Properties))
+	for k := range req.Properties {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		if err := validateProperty(k, req.Properties[k]); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "properties")
+	if !ok {
+		return
+	}
+	defer unlock()
+	if _, ok := inspectServer(w, r, containerId); !ok {
+		return
+	}
+
+	props, err := setServerProperties(containerId, req.Properties)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update server.properties: "+err.Error())
+		return
+	}
+	resp := ServerPropertiesResponse{Status: "ok", Properties: props, Message: "Updated " + strings.Join(keys, ", ")}
+	if isRunning(containerId) {
+		resp.RestartRequired = true
+		resp.Message += "; restart the server to apply"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
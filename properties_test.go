@@ -0,0 +1,30 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestMaxPlayersInspectErrors(t *testing.T) {
+	post := func() *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/server/max-players", strings.NewReader(`{"serverName":"lobby","userEmail":"alice@example.com","maxPlayers":20}`))
+		r.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		maxPlayersHandler(rec, r)
+		return rec
+	}
+
+	fakeDocker(t, map[string]types.ContainerJSON{})
+	if rec := post(); rec.Code != http.StatusNotFound {
+		t.Errorf("missing server: status = %d (%s), want %d", rec.Code, rec.Body, http.StatusNotFound)
+	}
+
+	unreachableDocker(t)
+	if rec := post(); rec.Code != http.StatusInternalServerError {
+		t.Errorf("Docker unreachable: status = %d (%s), want %d", rec.Code, rec.Body, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+)
+
+// maxFileSize is MAX_FILE_SIZE (default 100M), the largest file a write or
+// upload may produce.
+func maxFileSize() int64 {
+	return envSize("MAX_FILE_SIZE", 100<<20)
+}
+
+func errFileTooLarge() error {
+	return fileOpErrorf(http.StatusRequestEntityTooLarge, "File exceeds the maximum size of %s (MAX_FILE_SIZE)", formatMemory(maxFileSize()))
+}
+
+// diskQuota is the server's storage limit in bytes from its storage label,
+// or 0 when it has none. It fails when the container can't be inspected, as
+// the limit is unknown then.
+func diskQuota(containerId string) (int64, error) {
+	info, err := inspectContainer(context.Background(), containerId)
+	if err != nil {
+		return 0, err
+	}
+	quota, _ := strconv.ParseInt(info.Config.Labels[storageLabel], 10, 64)
+	return quota, nil
+}
+
+// quotaRoom is how many more bytes the server's volume may take, or -1 when
+// it has no quota. Its errors are fileOpErrors: a 404 when the server doesn't
+// exist, a 500 when its quota or usage couldn't be read, so a Docker failure
+// refuses the write instead of lifting the quota.
+func quotaRoom(containerId string) (int64, error) {
+	quota, err := diskQuota(containerId)
+	if err != nil {
+		if isNotFound(err) {
+			return 0, fileOpErrorf(http.StatusNotFound, "Server not found")
+		}
+		return 0, fileOpErrorf(http.StatusInternalServerError, "Failed to read the server's disk quota: %v", err)
+	}
+	if quota <= 0 {
+		return -1, nil
+	}
+	u, err := diskCache.usage(containerId)
+	if err != nil {
+		return 0, fileOpErrorf(http.StatusInternalServerError, "Failed to measure server volume: %v", err)
+	}
+	if u.bytes >= quota {
+		return 0, nil
+	}
+	return quota - u.bytes, nil
+}
+
+// checkDiskQuota fails with 507 when growing the server's volume by adding
+// bytes would take it over its quota.
+func checkDiskQuota(containerId string, adding int64) error {
+	room, err := quotaRoom(containerId)
+	if err != nil {
+		return err
+	}
+	if room >= 0 && adding > room {
+		return errQuotaExceeded(containerId)
+	}
+	return nil
+}
+
+func errQuotaExceeded(containerId string) error {
+	quota, err := diskQuota(containerId)
+	if err != nil {
+		return fileOpErrorf(http.StatusInsufficientStorage, "Server disk quota exceeded")
+	}
+	return fileOpErrorf(http.StatusInsufficientStorage, "Server disk quota of %s exceeded", formatMemory(quota))
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestCheckDiskQuota(t *testing.T) {
+	root := useVolumeRoot(t)
+	for _, id := range []string{"lobby--alice", "free--alice"} {
+		if err := os.MkdirAll(filepath.Join(root, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { diskCache.forget(id) })
+	}
+	if err := os.WriteFile(filepath.Join(root, "lobby--alice", "level.dat"), make([]byte, 600<<10), 0644); err != nil {
+		t.Fatal(err)
+	}
+	limited := ownedServer("alice", "25565")
+	limited.Config.Labels[storageLabel] = "1048576"
+	fakeDocker(t, map[string]types.ContainerJSON{
+		"lobby--alice": limited,
+		"free--alice":  ownedServer("alice", "25566"),
+	})
+
+	status := func(err error) int {
+		var opErr *fileOpError
+		if err == nil {
+			return 0
+		}
+		if !errors.As(err, &opErr) {
+			t.Fatalf("error %v is not a fileOpError", err)
+		}
+		return opErr.code
+	}
+	tests := []struct {
+		name   string
+		id     string
+		adding int64
+		want   int
+	}{
+		{"no quota", "free--alice", 1 << 30, 0},
+		{"within the quota", "lobby--alice", 400 << 10, 0},
+		{"over the quota", "lobby--alice", 500 << 10, http.StatusInsufficientStorage},
+		{"freeing space", "lobby--alice", -(100 << 10), 0},
+		{"missing server", "gone--alice", 1, http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if got := status(checkDiskQuota(tt.id, tt.adding)); got != tt.want {
+			t.Errorf("%s: status = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+
+	// Without Docker the quota is unknown, and the write is refused.
+	unreachableDocker(t)
+	if got := status(checkDiskQuota("free--alice", 1)); got != http.StatusInternalServerError {
+		t.Errorf("Docker unreachable: status = %d, want %d", got, http.StatusInternalServerError)
+	}
+}
+
+func TestCopyPathQuota(t *testing.T) {
+	root := useVolumeRoot(t)
+	t.Setenv("MAX_FILE_SIZE", "1M")
+	write := func(rel string, size int) {
+		t.Helper()
+		path := filepath.Join(root, rel)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, make([]byte, size), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("lobby--alice/world/region/r.0.0.mca", 300<<10)
+	write("lobby--alice/world/level.dat", 100<<10)
+	write("lobby--alice/backup/level.dat", 100<<10)
+	write("free--alice/mods/big.dat", 1<<20+1)
+	for _, id := range []string{"lobby--alice", "free--alice"} {
+		t.Cleanup(func() { diskCache.forget(id) })
+	}
+	limited := ownedServer("alice", "25565")
+	limited.Config.Labels[storageLabel] = "1048576"
+	fakeDocker(t, map[string]types.ContainerJSON{
+		"lobby--alice": limited,
+		"free--alice":  ownedServer("alice", "25566"),
+	})
+
+	tests := []struct {
+		name      string
+		id        string
+		from, to  string
+		overwrite bool
+		want      int
+	}{
+		{"tree within the quota", "lobby--alice", "world", "world-copy", false, 0},
+		// The volume now holds 900K of its 1M.
+		{"tree over the quota", "lobby--alice", "world", "world-copy2", false, http.StatusInsufficientStorage},
+		{"file over the quota", "lobby--alice", "world/region/r.0.0.mca", "r.mca", false, http.StatusInsufficientStorage},
+		{"replacing a file of the same size", "lobby--alice", "world/level.dat", "backup/level.dat", true, 0},
+		{"merging over the same files", "lobby--alice", "world", "world-copy", true, 0},
+		{"file over MAX_FILE_SIZE", "free--alice", "mods/big.dat", "big.dat", false, http.StatusRequestEntityTooLarge},
+		{"tree with a file over MAX_FILE_SIZE", "free--alice", "mods", "mods2", false, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		_, err := copyPath(tt.id, tt.from, tt.to, tt.overwrite)
+		var opErr *fileOpError
+		got := 0
+		if errors.As(err, &opErr) {
+			got = opErr.code
+		} else if err != nil {
+			t.Fatalf("%s: error %v is not a fileOpError", tt.name, err)
+		}
+		if got != tt.want {
+			t.Errorf("%s: status = %d (%v), want %d", tt.name, got, err, tt.want)
+		}
+	}
+	if _, err := os.Stat(filepath.Join(root, "lobby--alice", "world-copy2")); !os.IsNotExist(err) {
+		t.Errorf("refused copy left world-copy2 behind: %v", err)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// rateLimiter hands out one token bucket per client key.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+
+	mu      sync.Mutex
+	clients map[string]*rateClient
+}
+
+type rateClient struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// newRateLimiter allows each key perSecond requests with bursts of burst. A
+// limiter with perSecond <= 0 lets everything through.
+func newRateLimiter(perSecond float64, burst int) *rateLimiter {
+	if burst < 1 {
+		burst = 1
+	}
+	return &rateLimiter{limit: rate.Limit(perSecond), burst: burst, clients: map[string]*rateClient{}}
+}
+
+// allow takes a token from key's bucket. When the bucket is empty it returns
+// false and how long until the next token.
+func (l *rateLimiter) allow(key string) (bool, time.Duration) {
+	if l.limit <= 0 {
+		return true, 0
+	}
+	l.mu.Lock()
+	c, ok := l.clients[key]
+	if !ok {
+		c = &rateClient{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[key] = c
+	}
+	c.lastSeen = time.Now()
+	l.mu.Unlock()
+
+	res := c.limiter.Reserve()
+	if delay := res.Delay(); delay > 0 {
+		res.Cancel()
+		return false, delay
+	}
+	return true, 0
+}
+
+// startJanitor forgets clients that haven't been seen for ttl.
+func (l *rateLimiter) startJanitor(ttl time.Duration) {
+	go func() {
+		for range time.Tick(ttl / 4) {
+			cutoff := time.Now().Add(-ttl)
+			l.mu.Lock()
+			for key, c := range l.clients {
+				if c.lastSeen.Before(cutoff) {
+					delete(l.clients, key)
+				}
+			}
+			l.mu.Unlock()
+		}
+	}()
+}
+
+// Every request is limited per client IP, before authentication so guessing
+// tokens is throttled too. Endpoints that pull images, create containers or
+// copy whole volumes also draw from a much smaller bucket per caller.
+var (
+	requestLimiter   *rateLimiter
+	expensiveLimiter *rateLimiter
+)
+
+// configureRateLimits reads RATE_LIMIT/RATE_BURST for all requests and
+// RATE_LIMIT_EXPENSIVE/RATE_BURST_EXPENSIVE for the expensive endpoints, in
+// requests per second. 0 disables a limit.
+func configureRateLimits() {
+	requestLimiter = newRateLimiter(envFloat("RATE_LIMIT", 20), envInt("RATE_BURST", 40))
+	expensiveLimiter = newRateLimiter(envFloat("RATE_LIMIT_EXPENSIVE", 0.2), envInt("RATE_BURST_EXPENSIVE", 5))
+	requestLimiter.startJanitor(10 * time.Minute)
+	expensiveLimiter.startJanitor(10 * time.Minute)
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// writeRateLimited answers 429 with Retry-After in whole seconds.
+func writeRateLimited(w http.ResponseWriter, retry time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retry.Seconds()))))
+	writeError(w, http.StatusTooManyRequests, "Too many requests, retry later")
+}
+
+// limitRequests applies requestLimiter to every request by client IP.
+func limitRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ok, retry := requestLimiter.allow(clientIP(r)); !ok {
+			writeRateLimited(w, retry)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// expensive applies expensiveLimiter. It goes inside tokenMiddleware so the
+// bucket belongs to the authenticated user, or the client IP under the shared
+// token.
+func expensive(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := "ip:" + clientIP(r)
+		if id := identityFrom(r.Context()); id.Email != "" {
+			key = "user:" + extractUserId(id.Email)
+		}
+		if ok, retry := expensiveLimiter.allow(key); !ok {
+			writeRateLimited(w, retry)
+			return
+		}
+		next(w, r)
+	}
+}
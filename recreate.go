@@ -0,0 +1,232 @@
+package main
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types"
+)
+
+// RecreateServerRequest changes a server's software or resources. Fields left
+// empty keep the server's current value; Env, when given, replaces all custom
+// environment variables.
+type RecreateServerRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Software   string `json:"software,omitempty"`
+	// AcceptEula is only needed when turning a proxy into a game server;
+	// a server that accepted the EULA on create keeps it.
+	AcceptEula  bool              `json:"acceptEula,omitempty"`
+	RAM         string            `json:"ram,omitempty"`
+	Storage     string            `json:"storage,omitempty"`
+	CPU         string            `json:"cpu,omitempty"`
+	JavaVersion string            `json:"javaVersion,omitempty"`
+	Version     string            `json:"version,omitempty"`
+	Env         map[string]string `json:"env,omitempty"`
+	// RestartPolicy takes the same values as on create.
+	RestartPolicy string `json:"restartPolicy,omitempty"`
+	// KeepStopped leaves the new container stopped even if the old one was
+	// running.
+	KeepStopped bool `json:"keepStopped,omitempty"`
+	SkipBackup  bool `json:"skipBackup,omitempty"`
+}
+
+type RecreateServerResponse struct {
+	Status        string            `json:"status"`
+	Message       string            `json:"message"`
+	ServerID      string            `json:"serverId"`
+	Image         string            `json:"image"`
+	Software      string            `json:"software"`
+	Version       string            `json:"version"`
+	RAM           string            `json:"ram"`
+	Storage       string            `json:"storage,omitempty"`
+	CPU           string            `json:"cpu,omitempty"`
+	Port          int               `json:"port,omitempty"`
+	Env           map[string]string `json:"env,omitempty"`
+	RestartPolicy string            `json:"restartPolicy"`
+	Running       bool              `json:"running"`
+	PreBackupID   string            `json:"preBackupId,omitempty"`
+}
+
+// createRequestFrom turns a recreate request into the create request that
+// describes the new container, filling the fields left empty from the
+// current container.
+func createRequestFrom(req RecreateServerRequest, info types.ContainerJSON) CreateServerRequest {
+	env := map[string]string{}
+	custom := map[string]string{}
+	for _, kv := range info.Config.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		env[k] = v
+		if !reservedEnv[k] && !imageProvidedEnv(k) {
+			custom[k] = v
+		}
+	}
+	c := CreateServerRequest{
+		ServerName:       req.ServerName,
+		UserEmail:        req.UserEmail,
+		Software:         req.Software,
+		AcceptEula:       req.AcceptEula || env["EULA"] == "TRUE",
+		RAM:              req.RAM,
+		Storage:          req.Storage,
+		CPU:              req.CPU,
+		JavaVersion:      req.JavaVersion,
+		Version:          req.Version,
+		Env:              req.Env,
+		RestartPolicy:    req.RestartPolicy,
+		BandwidthIngress: info.Config.Labels[bandwidthIngressLabel],
+		BandwidthEgress:  info.Config.Labels[bandwidthEgressLabel],
+	}
+	if c.Software == "" {
+		for name, typeEnv := range softwareTypes {
+			if typeEnv == env["TYPE"] {
+				c.Software = name
+			}
+		}
+	}
+	if c.RAM == "" {
+		c.RAM = env["MEMORY"]
+	}
+	if c.RAM == "" {
+		c.RAM = defaultRAM
+	}
+	if bytes, err := strconv.ParseInt(info.Config.Labels[storageLabel], 10, 64); c.Storage == "" && err == nil {
+		c.Storage = formatMemory(bytes)
+	}
+	if c.CPU == "" && info.HostConfig.NanoCPUs > 0 {
+		c.CPU = strconv.FormatFloat(float64(info.HostConfig.NanoCPUs)/1e9, 'f', -1, 64)
+	}
+	if c.Version == "" {
+		c.Version = env["VERSION"]
+	}
+	if c.RestartPolicy == "" && info.HostConfig.RestartPolicy.Name != "" {
+		c.RestartPolicy = formatRestartPolicy(info.HostConfig.RestartPolicy)
+	}
+	if c.Env == nil {
+		c.Env = custom
+	}
+	if hc := info.Config.Healthcheck; hc != nil && len(hc.Test) == 1 && hc.Test[0] == "NONE" {
+		c.DisableHealthCheck = true
+	}
+	return c
+}
+
+// recreateServerHandler replaces a server's container with one built from new
+// software, version, memory, CPU or env settings, keeping its volume and port.
+// The new parameters are validated like a create. The server is stopped for
+// the swap and started again if it was running; if the new container can't
+// be created the old one is put back.
+func recreateServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req RecreateServerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "recreate")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	createReq := createRequestFrom(req, info)
+	plan, err := planCreate(createReq)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Keep the volume where it is, which may be a migrated location, and the
+	// port the players already connect to.
+	dataDir := plan.DataDir
+	if len(info.HostConfig.Binds) > 0 {
+		dataDir, _, _ = strings.Cut(info.HostConfig.Binds[0], ":")
+	}
+	plan.HostConfig.Binds = []string{dataDir + ":" + containerDataPath(plan.TypeEnv)}
+	if port, err := strconv.Atoi(info.Config.Labels[portLabel]); err == nil {
+		plan.publishPort(port)
+	}
+
+	if out, err := runDocker("pull", plan.Image); err != nil {
+		code, msg := classifyPullError(plan.Image, out)
+		writeError(w, code, msg)
+		return
+	}
+
+	preBackupId, err := backupBeforeDestructive(containerId, "recreate", req.SkipBackup)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to back up server before recreating: "+err.Error())
+		return
+	}
+
+	wasRunning := info.State.Running
+	if wasRunning {
+		if _, err := stopContainer(containerId, defaultStopTimeout); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to stop server: "+err.Error())
+			return
+		}
+	}
+	if err := removeContainer(containerId, false); err != nil {
+		if wasRunning {
+			startContainer(containerId)
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to remove old container: "+err.Error())
+		return
+	}
+	if createErr := createContainer(containerId, plan.Config, plan.HostConfig); createErr != nil {
+		if err := recreateContainer(containerId, info, dataDir); err != nil {
+			slog.Error("Recreate: failed to restore original container", "server", containerId, "err", err)
+		} else if wasRunning {
+			startContainer(containerId)
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to create new container, the old one was restored: "+createErr.Error())
+		return
+	}
+
+	msg := "Server recreated with " + plan.Summary
+	running := false
+	if wasRunning && !req.KeepStopped {
+		if err := startContainer(containerId); err != nil {
+			writeError(w, http.StatusInternalServerError, msg+" but it failed to start: "+err.Error())
+			return
+		}
+		running = true
+		msg += " and restarted"
+	}
+	writeJSON(w, http.StatusOK, RecreateServerResponse{
+		Status:        "ok",
+		Message:       msg,
+		ServerID:      containerId,
+		Image:         plan.Image,
+		Software:      strings.ToLower(plan.TypeEnv),
+		Version:       plan.Version,
+		RAM:           plan.RAM,
+		Storage:       createReq.Storage,
+		CPU:           createReq.CPU,
+		Port:          plan.Port,
+		Env:           createReq.Env,
+		Running:       running,
+		RestartPolicy: formatRestartPolicy(plan.HostConfig.RestartPolicy),
+		PreBackupID:   preBackupId,
+	})
+}
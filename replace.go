@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+type ReplaceRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// Paths lists files relative to the volume root. Glob selects more files:
+	// it is matched against the path relative to the root, or against just
+	// the file name when it contains no '/' (so "*.yml" matches everywhere).
+	Paths   []string `json:"paths,omitempty"`
+	Glob    string   `json:"glob,omitempty"`
+	Search  string   `json:"search"`
+	Replace string   `json:"replace"`
+	// Regex treats Search as a Go regular expression; Replace may then use
+	// $1-style references to its groups.
+	Regex      bool `json:"regex,omitempty"`
+	DryRun     bool `json:"dryRun,omitempty"`
+	SkipBackup bool `json:"skipBackup,omitempty"`
+}
+
+// ReplaceMatch is one changed line, for previewing a replacement.
+type ReplaceMatch struct {
+	Line   int    `json:"line"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+type ReplaceFileResult struct {
+	Path    string         `json:"path"`
+	Count   int            `json:"count"`
+	Matches []ReplaceMatch `json:"matches"`
+}
+
+type SkippedFile struct {
+	Path   string `json:"path"`
+	Reason string `json:"reason"`
+}
+
+type ReplaceResponse struct {
+	Status       string              `json:"status"`
+	Message      string              `json:"message"`
+	DryRun       bool                `json:"dryRun"`
+	TotalMatches int                 `json:"totalMatches"`
+	Files        []ReplaceFileResult `json:"files"`
+	Skipped      []SkippedFile       `json:"skipped,omitempty"`
+	PreBackupID  string              `json:"preBackupId,omitempty"`
+}
+
+const (
+	maxReplaceFiles    = 1000
+	maxReplaceFileSize = 1 << 20
+	// maxReplacePreview bounds how many changed lines are listed per file and
+	// how long each listed line may be.
+	maxReplacePreview  = 100
+	maxReplaceLineSize = 300
+)
+
+// pendingReplace is a file whose new content is ready to be written.
+type pendingReplace struct {
+	path     string
+	perm     os.FileMode
+	original []byte
+	updated  []byte
+}
+
+// replaceHandler runs a literal or regex search-and-replace over a set of text
+// files in a server's volume. A dry run only reports what would change; a
+// commit takes a backup and then writes every changed file, restoring the
+// originals if any write fails.
+func replaceHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req ReplaceRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.Search == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and search are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Paths) == 0 && req.Glob == "" {
+		writeError(w, http.StatusBadRequest, "paths or glob is required")
+		return
+	}
+	if req.Glob != "" {
+		if _, err := path.Match(req.Glob, ""); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid glob: "+err.Error())
+			return
+		}
+	}
+	replace := func(b []byte) ([]byte, int) {
+		return bytes.ReplaceAll(b, []byte(req.Search), []byte(req.Replace)), bytes.Count(b, []byte(req.Search))
+	}
+	if req.Regex {
+		re, err := regexp.Compile(req.Search)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid regex: "+err.Error())
+			return
+		}
+		replace = func(b []byte) ([]byte, int) {
+			return re.ReplaceAll(b, []byte(req.Replace)), len(re.FindAllIndex(b, -1))
+		}
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+
+	files, err := replaceTargets(containerId, req.Paths, req.Glob)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	// The files are read and replaced under the server lock, so nothing can
+	// write them in between. A dry run changes nothing and goes without it.
+	if !req.DryRun {
+		unlock, ok := lockServer(w, containerId, "replace")
+		if !ok {
+			return
+		}
+		defer unlock()
+	}
+
+	resp := ReplaceResponse{Status: "ok", DryRun: req.DryRun, Files: []ReplaceFileResult{}}
+	var pending []pendingReplace
+	root := getServerDataDir(containerId)
+	for _, p := range files {
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		info, err := os.Stat(p)
+		if err != nil {
+			resp.Skipped = append(resp.Skipped, SkippedFile{Path: rel, Reason: "not found"})
+			continue
+		}
+		if !info.Mode().IsRegular() {
+			resp.Skipped = append(resp.Skipped, SkippedFile{Path: rel, Reason: "not a regular file"})
+			continue
+		}
+		if info.Size() > maxReplaceFileSize {
+			resp.Skipped = append(resp.Skipped, SkippedFile{Path: rel, Reason: fmt.Sprintf("larger than %d bytes", maxReplaceFileSize)})
+			continue
+		}
+		data, err := os.ReadFile(p)
+		if err != nil {
+			resp.Skipped = append(resp.Skipped, SkippedFile{Path: rel, Reason: err.Error()})
+			continue
+		}
+		if bytes.IndexByte(data, 0) >= 0 {
+			resp.Skipped = append(resp.Skipped, SkippedFile{Path: rel, Reason: "binary file"})
+			continue
+		}
+		updated, count := replace(data)
+		if count == 0 {
+			continue
+		}
+		resp.TotalMatches += count
+		resp.Files = append(resp.Files, ReplaceFileResult{Path: rel, Count: count, Matches: previewReplace(data, updated)})
+		if !bytes.Equal(data, updated) {
+			pending = append(pending, pendingReplace{path: p, perm: info.Mode().Perm(), original: data, updated: updated})
+		}
+	}
+
+	if req.DryRun || len(pending) == 0 {
+		resp.Message = fmt.Sprintf("%d matches in %d files", resp.TotalMatches, len(resp.Files))
+		if !req.DryRun {
+			resp.Message += "; nothing to change"
+		}
+		writeJSON(w, http.StatusOK, resp)
+		return
+	}
+
+	resp.PreBackupID, err = backupBeforeDestructive(containerId, "replace", req.SkipBackup)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to back up server before replacing: "+err.Error())
+		return
+	}
+	if err := applyReplace(pending); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to apply replacement, no files were changed: "+err.Error())
+		return
+	}
+	diskCache.forget(containerId)
+	resp.Message = fmt.Sprintf("Replaced %d matches in %d files", resp.TotalMatches, len(pending))
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// replaceTargets resolves the requested paths and glob into a sorted,
+// de-duplicated list of absolute file paths inside the volume.
+func replaceTargets(containerId string, paths []string, glob string) ([]string, error) {
+	seen := map[string]bool{}
+	for _, rel := range paths {
+		p, err := resolveServerPath(containerId, rel)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", rel, err)
+		}
+		seen[p] = true
+	}
+	if glob != "" {
+		root := getServerDataDir(containerId)
+		nameOnly := !strings.Contains(glob, "/")
+		filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+			if err != nil || !d.Type().IsRegular() {
+				return nil
+			}
+			rel, _ := filepath.Rel(root, p)
+			subject := filepath.ToSlash(rel)
+			if nameOnly {
+				subject = d.Name()
+			}
+			if ok, _ := path.Match(glob, subject); ok {
+				seen[p] = true
+			}
+			return nil
+		})
+	}
+	if len(seen) > maxReplaceFiles {
+		return nil, fmt.Errorf("selection matches %d files; at most %d can be changed at once", len(seen), maxReplaceFiles)
+	}
+	files := make([]string, 0, len(seen))
+	for p := range seen {
+		files = append(files, p)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// previewReplace lists the lines that differ between before and after. Lines
+// are compared by position, so a replacement that adds or removes newlines
+// shows as changes from that point on.
+func previewReplace(before, after []byte) []ReplaceMatch {
+	a := strings.Split(string(before), "\n")
+	b := strings.Split(string(after), "\n")
+	var matches []ReplaceMatch
+	for i := 0; i < len(a) || i < len(b); i++ {
+		var x, y string
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x == y {
+			continue
+		}
+		if len(matches) == maxReplacePreview {
+			break
+		}
+		matches = append(matches, ReplaceMatch{Line: i + 1, Before: truncateLine(x), After: truncateLine(y)})
+	}
+	return matches
+}
+
+func truncateLine(s string) string {
+	if len(s) > maxReplaceLineSize {
+		return s[:maxReplaceLineSize] + "..."
+	}
+	return s
+}
+
+// applyReplace writes every pending file or none of them: all new contents
+// are staged in temporary files first, and if a rename fails the files
+// already replaced get their original content back.
+func applyReplace(pending []pendingReplace) error {
+	staged := make([]string, 0, len(pending))
+	defer func() {
+		for _, tmp := range staged {
+			os.Remove(tmp)
+		}
+	}()
+	for _, p := range pending {
+		tmp, err := os.CreateTemp(filepath.Dir(p.path), "."+filepath.Base(p.path)+".tmp-*")
+		if err != nil {
+			return err
+		}
+		staged = append(staged, tmp.Name())
+		_, err = tmp.Write(p.updated)
+		if cerr := tmp.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = os.Chmod(tmp.Name(), p.perm)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	for i, p := range pending {
+		if err := os.Rename(staged[i], p.path); err != nil {
+			for _, done := range pending[:i] {
+				writeFileAtomic(done.path, done.original, done.perm)
+			}
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// ResourcesRequest changes a server's container limits in place. Fields left
+// empty keep their current value.
+type ResourcesRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	// Memory is the container's hard memory limit, in the format of ram on
+	// create.
+	Memory string `json:"memory,omitempty"`
+	CPUs   string `json:"cpus,omitempty"`
+}
+
+type ResourcesResponse struct {
+	Status   string `json:"status"`
+	Message  string `json:"message"`
+	ServerID string `json:"serverId"`
+	Memory   string `json:"memory,omitempty"`
+	// CPUs is empty when the container has no CPU limit.
+	CPUs string `json:"cpus,omitempty"`
+	// RAM is the Java heap, which only /server/recreate can change.
+	RAM string `json:"ram,omitempty"`
+}
+
+// resourcesHandler updates a server's memory and CPU limits with docker
+// update, without stopping it. The Java heap is fixed when the container is
+// created, so memory may not drop below the heap plus the JVM overhead create
+// allows for it.
+func resourcesHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req ResourcesRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || (req.Memory == "" && req.CPUs == "") {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and memory or cpus are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	var memory int64
+	if req.Memory != "" {
+		m, err := parseMemory(req.Memory)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "memory: "+err.Error())
+			return
+		}
+		memory = m
+	}
+	var nanoCPUs int64
+	if req.CPUs != "" {
+		c, err := parseCPU(req.CPUs)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		nanoCPUs = int64(c * 1e9)
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "resources")
+	if !ok {
+		return
+	}
+	defer unlock()
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+
+	ram := ""
+	for _, kv := range info.Config.Env {
+		if v, ok := strings.CutPrefix(kv, "MEMORY="); ok {
+			ram = v
+		}
+	}
+	if heap, err := parseMemory(ram); err == nil && memory > 0 {
+		if min := containerMemoryMB(heap>>20) << 20; memory < min {
+			writeError(w, http.StatusBadRequest, fmt.Sprintf("memory must be at least %s for the server's %s Java heap; change ram with /server/recreate to go lower", formatMemory(min), ram))
+			return
+		}
+	}
+
+	var res container.Resources
+	if memory > 0 {
+		// Equal to Memory so the container can't spill into swap.
+		res.Memory, res.MemorySwap = memory, memory
+	}
+	res.NanoCPUs = nanoCPUs
+	if _, err := dockerClient.ContainerUpdate(r.Context(), containerId, container.UpdateConfig{Resources: res}); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to update resources: "+err.Error())
+		return
+	}
+
+	if memory == 0 {
+		memory = info.HostConfig.Memory
+	}
+	if nanoCPUs == 0 {
+		nanoCPUs = info.HostConfig.NanoCPUs
+	}
+	resp := ResourcesResponse{Status: "ok", ServerID: containerId, RAM: ram}
+	msg := "No memory limit"
+	if memory > 0 {
+		resp.Memory = formatMemory(memory)
+		msg = "Container memory limit " + resp.Memory
+	}
+	if nanoCPUs > 0 {
+		resp.CPUs = strconv.FormatFloat(float64(nanoCPUs)/1e9, 'f', -1, 64)
+		msg += ", " + resp.CPUs + " CPUs"
+	} else {
+		msg += ", no CPU limit"
+	}
+	resp.Message = msg + " applied"
+	if !isRunning(containerId) {
+		resp.Message += "; they take effect when the server starts"
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
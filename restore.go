@@ -0,0 +1,220 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// RestoreRequest names a backup, as returned by /server/backup, to restore a
+// server's volume from.
+type RestoreRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	BackupFile string `json:"backupFile"`
+	SkipBackup bool   `json:"skipBackup,omitempty"`
+}
+
+type RestoreResponse struct {
+	Status      string `json:"status"`
+	Message     string `json:"message"`
+	Files       int    `json:"files"`
+	PreBackupID string `json:"preBackupId,omitempty"`
+}
+
+// errUnsafeArchiveEntry is returned for archive entries that would be written
+// outside the destination directory.
+var errUnsafeArchiveEntry = errors.New("archive entry escapes the destination directory")
+
+// backupBelongsTo reports whether file is the name of one of containerId's
+// backups: <containerId>-<timestamp>[-<label>].tar.gz. Requiring the timestamp
+// right after the prefix keeps server "lobby" from matching the backups of a
+// server named "lobby--alice-2" of the same user.
+func backupBelongsTo(file, containerId string) bool {
+	rest := strings.TrimPrefix(file, containerId+"-")
+	if rest == file || !strings.HasSuffix(rest, backupExt) || len(rest) < len(backupTimeFormat) {
+		return false
+	}
+	_, err := time.Parse(backupTimeFormat, rest[:len(backupTimeFormat)])
+	return err == nil
+}
+
+// restoreServerHandler replaces a server's volume with the contents of one of
+// its backups. The server is stopped for the swap and started again if it was
+// running. The archive is extracted into a staging directory next to the
+// volume first, so a corrupt backup leaves the current data untouched.
+func restoreServerHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req RestoreRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.BackupFile == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and backupFile are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	if filepath.Base(req.BackupFile) != req.BackupFile || !backupBelongsTo(req.BackupFile, containerId) {
+		writeError(w, http.StatusBadRequest, "backupFile must be one of this server's backups")
+		return
+	}
+	archive := filepath.Join(getBackupsDir(), req.BackupFile)
+	if info, err := os.Stat(archive); err != nil || !info.Mode().IsRegular() {
+		writeError(w, http.StatusNotFound, "Backup not found")
+		return
+	}
+	dataDir := getServerDataDir(containerId)
+	if info, err := os.Stat(dataDir); err != nil || !info.IsDir() {
+		writeError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+	unlock, ok := lockServer(w, containerId, "restore")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	tmp, err := makeStagingDir(filepath.Dir(dataDir))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to prepare restore: "+err.Error())
+		return
+	}
+	defer os.RemoveAll(tmp)
+	staging := filepath.Join(tmp, "data")
+	files, err := extractBackup(archive, staging)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to extract backup, server data was not changed: "+err.Error())
+		return
+	}
+
+	preBackupId, err := backupBeforeDestructive(containerId, "restore", req.SkipBackup)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to back up server before restoring: "+err.Error())
+		return
+	}
+
+	wasRunning := isRunning(containerId)
+	if wasRunning {
+		if _, err := stopContainer(containerId, defaultStopTimeout); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to stop server: "+err.Error())
+			return
+		}
+	}
+	old := filepath.Join(tmp, "old")
+	if err := os.Rename(dataDir, old); err != nil {
+		if wasRunning {
+			startContainer(containerId)
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to restore backup: "+err.Error())
+		return
+	}
+	if err := os.Rename(staging, dataDir); err != nil {
+		os.Rename(old, dataDir)
+		if wasRunning {
+			startContainer(containerId)
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to restore backup: "+err.Error())
+		return
+	}
+	diskCache.forget(containerId)
+	if err := os.RemoveAll(tmp); err != nil {
+		slog.Warn("Restore: failed to remove old data", "server", containerId, "path", old, "err", err)
+	}
+
+	msg := fmt.Sprintf("Restored %d files from %s", files, req.BackupFile)
+	if wasRunning {
+		if err := startContainer(containerId); err != nil {
+			writeError(w, http.StatusInternalServerError, msg+" but the server failed to start: "+err.Error())
+			return
+		}
+		msg += " and server restarted"
+	}
+	writeJSON(w, http.StatusOK, RestoreResponse{Status: "ok", Message: msg, Files: files, PreBackupID: preBackupId})
+}
+
+// extractBackup unpacks a .tar.gz written by writeTarGz into dest, which must
+// not exist yet, and returns the number of files written.
+func extractBackup(archive, dest string) (int, error) {
+	f, err := os.Open(archive)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return 0, err
+	}
+	defer gz.Close()
+	if err := os.Mkdir(dest, 0755); err != nil {
+		return 0, err
+	}
+	return extractTar(tar.NewReader(gz), dest)
+}
+
+// extractTar writes the directories and regular files of an archive under
+// dest. Entries that would land outside dest ("zip slip") fail the whole
+// extraction; links and special files are skipped.
+func extractTar(tr *tar.Reader, dest string) (int, error) {
+	files := 0
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files, nil
+		}
+		if err != nil {
+			return files, err
+		}
+		name := filepath.FromSlash(hdr.Name)
+		if filepath.IsAbs(name) {
+			return files, errUnsafeArchiveEntry
+		}
+		target := filepath.Join(dest, name)
+		if rel, err := filepath.Rel(dest, target); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return files, errUnsafeArchiveEntry
+		}
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return files, err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return files, err
+			}
+			out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return files, err
+			}
+			_, err = io.Copy(out, tr)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return files, err
+			}
+			os.Chtimes(target, hdr.ModTime, hdr.ModTime)
+			files++
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestRestoreLeavesSiblingVolumes(t *testing.T) {
+	root := useVolumeRoot(t)
+	savedBackups := backupRootDir
+	backupRootDir = t.TempDir()
+	defer func() { backupRootDir = savedBackups }()
+	fakeDocker(t, map[string]types.ContainerJSON{})
+
+	write := func(path, content string) {
+		t.Helper()
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// Server "lobby" of the users "alice", "alice.old" and "alice.restore".
+	write(filepath.Join(root, "lobby--alice", "level.dat"), "current")
+	write(filepath.Join(root, "lobby--alice.old", "level.dat"), "alice.old's world")
+	write(filepath.Join(root, "lobby--alice.restore", "level.dat"), "alice.restore's world")
+
+	src := t.TempDir()
+	write(filepath.Join(src, "level.dat"), "restored")
+	backupFile := "lobby--alice-" + time.Now().UTC().Format(backupTimeFormat) + backupExt
+	f, err := os.Create(filepath.Join(backupRootDir, backupFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := writeTarGz(f, src); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	r := httptest.NewRequest(http.MethodPost, "/server/restore", strings.NewReader(`{"serverName":"lobby","userEmail":"alice@example.com","backupFile":"`+backupFile+`","skipBackup":true}`))
+	r.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	restoreServerHandler(rec, r)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("status = %d (%s), want %d", rec.Code, rec.Body, http.StatusOK)
+	}
+
+	for dir, want := range map[string]string{
+		"lobby--alice":         "restored",
+		"lobby--alice.old":     "alice.old's world",
+		"lobby--alice.restore": "alice.restore's world",
+	} {
+		got, err := os.ReadFile(filepath.Join(root, dir, "level.dat"))
+		if err != nil || string(got) != want {
+			t.Errorf("%s/level.dat = %q, %v; want %q", dir, got, err, want)
+		}
+	}
+	if entries, _ := os.ReadDir(filepath.Join(root, stagingDirName)); len(entries) != 0 {
+		t.Errorf("staging directory left behind %d entries", len(entries))
+	}
+}
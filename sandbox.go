@@ -0,0 +1,199 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+type TestStartRequest struct {
+	ServerName     string `json:"serverName"`
+	UserEmail      string `json:"userEmail"`
+	TimeoutSeconds int    `json:"timeoutSeconds,omitempty"`
+}
+
+type TestStartResponse struct {
+	Status          string   `json:"status"`
+	Message         string   `json:"message"`
+	Booted          bool     `json:"booted"`
+	ExitCode        *int     `json:"exitCode,omitempty"`
+	DurationSeconds float64  `json:"durationSeconds"`
+	LastLogs        []string `json:"lastLogs,omitempty"`
+}
+
+const (
+	defaultTestStartTimeout = 180
+	maxTestStartTimeout     = 600
+	sandboxLogLines         = 50
+)
+
+// readyPattern matches the line game servers ("Done (3.2s)!") and proxies
+// ("Listening on /0.0.0.0:25577") print once they accept players.
+var readyPattern = regexp.MustCompile(`Done \([0-9.]+s\)!|Listening on /`)
+
+// sandboxLabel marks throwaway test containers. They deliberately don't carry
+// managedLabel so the crash monitor ignores them.
+const sandboxLabel = "mcnode.sandbox"
+
+// testStartHandler boots a copy of a server in a disposable container to
+// check whether its current configuration starts, then removes the copy. The
+// real container and volume are never touched.
+func testStartHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req TestStartRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	timeout := req.TimeoutSeconds
+	if timeout == 0 {
+		timeout = defaultTestStartTimeout
+	}
+	if timeout < 10 || timeout > maxTestStartTimeout {
+		writeError(w, http.StatusBadRequest, fmt.Sprintf("timeoutSeconds must be between 10 and %d", maxTestStartTimeout))
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+
+	name := containerId + "-sandbox-" + newJobId()[:8]
+	sandboxDir := filepath.Join(filepath.Dir(mustAbs(getServerDataDir(containerId))), ".sandbox", name)
+	defer os.RemoveAll(sandboxDir)
+	if err := snapshotDir(getServerDataDir(containerId), sandboxDir); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to copy server volume: "+err.Error())
+		return
+	}
+	// A copied session.lock would make the sandbox refuse to load the world
+	// while the real server is running.
+	removeSessionLocks(sandboxDir)
+
+	config := &container.Config{Image: info.Config.Image, Labels: map[string]string{sandboxLabel: "true"}}
+	typeEnv := ""
+	for _, kv := range info.Config.Env {
+		k, v, _ := strings.Cut(kv, "=")
+		if k == "TYPE" {
+			typeEnv = v
+		}
+		if !imageProvidedEnv(k) {
+			config.Env = append(config.Env, kv)
+		}
+	}
+	hostConfig := &container.HostConfig{
+		Binds: []string{sandboxDir + ":" + containerDataPath(typeEnv)},
+		Resources: container.Resources{
+			Memory:     info.HostConfig.Memory,
+			MemorySwap: info.HostConfig.Memory,
+		},
+	}
+
+	started := time.Now()
+	if err := createContainer(name, config, hostConfig); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start sandbox: "+err.Error())
+		return
+	}
+	defer removeContainer(name, true)
+	if err := startContainer(name); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to start sandbox: "+err.Error())
+		return
+	}
+
+	resp := waitForBoot(name, time.Duration(timeout)*time.Second)
+	resp.DurationSeconds = time.Since(started).Round(100 * time.Millisecond).Seconds()
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// waitForBoot polls the sandbox until it reports ready, exits, or the timeout
+// is reached.
+func waitForBoot(name string, timeout time.Duration) TestStartResponse {
+	deadline := time.Now().Add(timeout)
+	for {
+		logs, _ := containerLogs(context.Background(), name, container.LogsOptions{Tail: strconv.Itoa(sandboxLogLines)})
+		if readyPattern.MatchString(logs) {
+			return TestStartResponse{Status: "ok", Message: "Server booted successfully", Booted: true, LastLogs: splitLines(logs)}
+		}
+		info, err := inspectContainer(context.Background(), name)
+		if err == nil && !info.State.Running {
+			code := info.State.ExitCode
+			return TestStartResponse{Status: "ok", Message: fmt.Sprintf("Server exited with code %d before it finished booting", code), ExitCode: &code, LastLogs: splitLines(logs)}
+		}
+		if time.Now().After(deadline) {
+			return TestStartResponse{Status: "ok", Message: "Server did not finish booting within " + timeout.String(), LastLogs: splitLines(logs)}
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// snapshotDir copies src to dst, using reflinks so copy-on-write filesystems
+// (btrfs, XFS) don't duplicate the data.
+func snapshotDir(src, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	out, err := exec.Command("cp", "-a", "--reflink=auto", src, dst).CombinedOutput()
+	if err != nil {
+		return errors.New(strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// removeSessionLocks deletes the world session.lock files under dir.
+func removeSessionLocks(dir string) {
+	filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() && info.Name() == "session.lock" {
+			os.Remove(path)
+		}
+		return nil
+	})
+}
+
+// imageProvidedEnv reports whether an env var comes from the image itself
+// (PATH, JAVA_HOME etc.) and shouldn't be copied onto a new container.
+func imageProvidedEnv(key string) bool {
+	return key == "PATH" || strings.HasPrefix(key, "JAVA_") || key == "LANG" || key == "HOME"
+}
+
+func splitLines(s string) []string {
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// mustAbs is filepath.Abs for paths built from the working directory, which
+// only fails if the working directory itself is gone.
+func mustAbs(path string) string {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return path
+	}
+	return abs
+}
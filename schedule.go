@@ -0,0 +1,334 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// scheduledBackupLabel marks the backups taken by a schedule. Retention only
+// ever deletes these, never manual or pre-operation backups.
+const scheduledBackupLabel = "scheduled"
+
+// BackupSchedule is a server's backup schedule as stored and reported.
+type BackupSchedule struct {
+	Cron string `json:"cron"`
+	// Timezone is the IANA zone Cron is read in, UTC when empty.
+	Timezone string `json:"timezone,omitempty"`
+	// Keep is how many scheduled backups are kept; older ones are deleted
+	// after each run. 0 keeps them all.
+	Keep       int        `json:"keep,omitempty"`
+	SkipSave   bool       `json:"skipSave,omitempty"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastBackup string     `json:"lastBackup,omitempty"`
+	LastError  string     `json:"lastError,omitempty"`
+}
+
+type BackupScheduleRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Cron       string `json:"cron"`
+	Timezone   string `json:"timezone,omitempty"`
+	Keep       int    `json:"keep,omitempty"`
+	SkipSave   bool   `json:"skipSave,omitempty"`
+}
+
+type BackupScheduleResponse struct {
+	Status   string          `json:"status"`
+	Message  string          `json:"message,omitempty"`
+	Schedule *BackupSchedule `json:"schedule,omitempty"`
+	NextRun  *time.Time      `json:"nextRun,omitempty"`
+}
+
+// maxScheduledKeep bounds retention so a typo can't mean "keep forever".
+const maxScheduledKeep = 1000
+
+type scheduledBackup struct {
+	BackupSchedule
+	spec    *cronSchedule
+	loc     *time.Location
+	next    time.Time
+	running bool
+}
+
+// backupScheduler runs the backup schedules of all servers. Schedules are
+// saved to schedules.json in the backup root on every change and loaded again at
+// startup.
+type backupScheduler struct {
+	mu        sync.Mutex
+	schedules map[string]*scheduledBackup
+}
+
+var backupSchedules = &backupScheduler{schedules: map[string]*scheduledBackup{}}
+
+func backupSchedulesFile() string {
+	return filepath.Join(getBackupsDir(), "schedules.json")
+}
+
+// newScheduledBackup checks a schedule's cron expression, timezone and
+// retention.
+func newScheduledBackup(s BackupSchedule) (*scheduledBackup, error) {
+	spec, err := parseCron(s.Cron)
+	if err != nil {
+		return nil, err
+	}
+	loc := time.UTC
+	if s.Timezone != "" {
+		if loc, err = time.LoadLocation(s.Timezone); err != nil {
+			return nil, errors.New("unknown timezone " + s.Timezone)
+		}
+	}
+	if s.Keep < 0 || s.Keep > maxScheduledKeep {
+		return nil, errors.New("keep must be between 0 (keep all) and 1000")
+	}
+	if spec.next(time.Now().In(loc)).IsZero() {
+		return nil, errors.New("cron expression never fires")
+	}
+	return &scheduledBackup{BackupSchedule: s, spec: spec, loc: loc}, nil
+}
+
+// start loads the saved schedules and runs them until the agent shuts down.
+func (b *backupScheduler) start() {
+	data, err := os.ReadFile(backupSchedulesFile())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("Failed to read backup schedules", "err", err)
+	}
+	saved := map[string]BackupSchedule{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &saved); err != nil {
+			slog.Warn("Ignoring invalid backup schedules file", "file", backupSchedulesFile(), "err", err)
+		}
+	}
+	now := time.Now()
+	b.mu.Lock()
+	for id, s := range saved {
+		sb, err := newScheduledBackup(s)
+		if err != nil {
+			slog.Warn("Ignoring invalid backup schedule", "server", id, "err", err)
+			continue
+		}
+		sb.next = sb.spec.next(now.In(sb.loc))
+		b.schedules[id] = sb
+	}
+	b.mu.Unlock()
+	if len(b.schedules) > 0 {
+		slog.Info("Backup schedules loaded", "count", len(b.schedules))
+	}
+
+	go func() {
+		ticker := time.NewTicker(15 * time.Second)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-shuttingDown.Done():
+				return
+			case now := <-ticker.C:
+				b.runDue(now)
+			}
+		}
+	}()
+}
+
+// runDue starts the backups whose time has come. A server whose previous
+// scheduled backup is still running is skipped until its next time.
+func (b *backupScheduler) runDue(now time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for id, sb := range b.schedules {
+		if sb.next.IsZero() || now.Before(sb.next) {
+			continue
+		}
+		sb.next = sb.spec.next(now.In(sb.loc))
+		if sb.running {
+			continue
+		}
+		sb.running = true
+		go b.run(id, sb)
+	}
+}
+
+// run takes one scheduled backup and applies the schedule's retention. A
+// server that is busy is retried a minute later; one that no longer exists
+// has its schedule dropped.
+func (b *backupScheduler) run(containerId string, sb *scheduledBackup) {
+	defer func() {
+		b.mu.Lock()
+		sb.running = false
+		b.mu.Unlock()
+	}()
+	if info, err := os.Stat(getServerDataDir(containerId)); err != nil || !info.IsDir() {
+		slog.Info("Dropping backup schedule of deleted server", "server", containerId)
+		b.remove(containerId)
+		return
+	}
+	unlock, held, ok := tryLockServer(containerId, "scheduled backup")
+	if !ok {
+		b.mu.Lock()
+		sb.LastError = "Skipped: server busy with " + held.Operation + ", retrying"
+		if retry := time.Now().Add(time.Minute); retry.Before(sb.next) {
+			sb.next = retry
+		}
+		b.mu.Unlock()
+		return
+	}
+	info, err := backupServer(containerId, scheduledBackupLabel, sb.SkipSave)
+	unlock()
+
+	var pruneErr error
+	if err == nil && sb.Keep > 0 {
+		_, _, pruneErr = pruneBackups(containerId, sb.Keep, func(bi BackupInfo) bool {
+			return bi.Label == scheduledBackupLabel
+		}, time.Time{})
+	}
+	now := time.Now().UTC()
+	b.mu.Lock()
+	sb.LastRun = &now
+	sb.LastError = ""
+	switch {
+	case err != nil:
+		sb.LastError = err.Error()
+		slog.Warn("Scheduled backup failed", "server", containerId, "err", err)
+	case pruneErr != nil:
+		sb.LastBackup = info.ID
+		sb.LastError = "Failed to delete old backups: " + pruneErr.Error()
+		slog.Warn("Scheduled backup: failed to delete old backups", "server", containerId, "err", pruneErr)
+	default:
+		sb.LastBackup = info.ID
+		slog.Info("Scheduled backup created", "server", containerId, "backup", info.ID)
+	}
+	b.mu.Unlock()
+	b.save()
+}
+
+// set replaces the server's schedule and returns its next run.
+func (b *backupScheduler) set(containerId string, sb *scheduledBackup) time.Time {
+	b.mu.Lock()
+	if old := b.schedules[containerId]; old != nil {
+		sb.LastRun, sb.LastBackup, sb.LastError = old.LastRun, old.LastBackup, old.LastError
+	}
+	sb.next = sb.spec.next(time.Now().In(sb.loc))
+	b.schedules[containerId] = sb
+	next := sb.next
+	b.mu.Unlock()
+	b.save()
+	return next
+}
+
+func (b *backupScheduler) remove(containerId string) bool {
+	b.mu.Lock()
+	_, ok := b.schedules[containerId]
+	delete(b.schedules, containerId)
+	b.mu.Unlock()
+	if ok {
+		b.save()
+	}
+	return ok
+}
+
+func (b *backupScheduler) get(containerId string) (BackupSchedule, time.Time, bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	sb, ok := b.schedules[containerId]
+	if !ok {
+		return BackupSchedule{}, time.Time{}, false
+	}
+	return sb.BackupSchedule, sb.next, true
+}
+
+// save writes all schedules to backupSchedulesFile.
+func (b *backupScheduler) save() {
+	b.mu.Lock()
+	saved := make(map[string]BackupSchedule, len(b.schedules))
+	for id, sb := range b.schedules {
+		saved[id] = sb.BackupSchedule
+	}
+	data, err := json.MarshalIndent(saved, "", "  ")
+	b.mu.Unlock()
+	if err == nil {
+		if err = os.MkdirAll(getBackupsDir(), 0755); err == nil {
+			err = writeFileAtomic(backupSchedulesFile(), data, 0600)
+		}
+	}
+	if err != nil {
+		slog.Error("Failed to save backup schedules", "err", err)
+	}
+}
+
+// backupScheduleHandler shows (GET), sets (POST) or removes (DELETE) a
+// server's backup schedule.
+func backupScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method == http.MethodGet {
+		containerId, ok := containerIdFromQuery(w, r)
+		if !ok {
+			return
+		}
+		s, next, ok := backupSchedules.get(containerId)
+		if !ok {
+			writeError(w, http.StatusNotFound, "Server has no backup schedule")
+			return
+		}
+		writeJSON(w, http.StatusOK, BackupScheduleResponse{Status: "ok", Schedule: &s, NextRun: nextRunPtr(next)})
+		return
+	}
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req BackupScheduleRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+
+	if r.Method == http.MethodDelete {
+		if !backupSchedules.remove(containerId) {
+			writeError(w, http.StatusNotFound, "Server has no backup schedule")
+			return
+		}
+		writeJSON(w, http.StatusOK, BackupScheduleResponse{Status: "ok", Message: "Backup schedule removed"})
+		return
+	}
+	if strings.TrimSpace(req.Cron) == "" {
+		writeError(w, http.StatusBadRequest, "cron is required")
+		return
+	}
+	sb, err := newScheduledBackup(BackupSchedule{Cron: strings.TrimSpace(req.Cron), Timezone: req.Timezone, Keep: req.Keep, SkipSave: req.SkipSave})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if info, err := os.Stat(getServerDataDir(containerId)); err != nil || !info.IsDir() {
+		writeError(w, http.StatusNotFound, "Server not found")
+		return
+	}
+	next := backupSchedules.set(containerId, sb)
+	s, _, _ := backupSchedules.get(containerId)
+	writeJSON(w, http.StatusOK, BackupScheduleResponse{Status: "ok", Message: "Backup schedule saved", Schedule: &s, NextRun: nextRunPtr(next)})
+}
+
+func nextRunPtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
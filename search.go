@@ -0,0 +1,134 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// SearchMatch is a file whose name matched, or with Line set, one line of it
+// containing the searched text.
+type SearchMatch struct {
+	Path    string `json:"path"`
+	Line    int    `json:"line,omitempty"`
+	Snippet string `json:"snippet,omitempty"`
+}
+
+type SearchResponse struct {
+	Status  string        `json:"status"`
+	Matches []SearchMatch `json:"matches"`
+	// Truncated is set when the search stopped at maxSearchMatches.
+	Truncated bool `json:"truncated"`
+}
+
+const (
+	maxSearchMatches = 500
+	// Files bigger than this are skipped when searching contents.
+	maxSearchFileSize = 2 << 20
+)
+
+// regionFileExts are the world's chunk storage files: large, binary and never
+// worth grepping.
+var regionFileExts = map[string]bool{".mca": true, ".mcr": true, ".mcc": true}
+
+// fileSearchHandler finds files under path whose name matches the glob in
+// name and, when contains is given, the lines in them containing that text.
+// The name glob follows /file/replace: without a '/' it is matched against
+// the file name, otherwise against the path relative to the volume root.
+// Binary, region and large files are never searched for content.
+func fileSearchHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	q := r.URL.Query()
+	name, contains := q.Get("name"), q.Get("contains")
+	if name == "" && contains == "" {
+		writeError(w, http.StatusBadRequest, "name or contains is required")
+		return
+	}
+	if name != "" {
+		if _, err := path.Match(name, ""); err != nil {
+			writeError(w, http.StatusBadRequest, "Invalid name glob: "+err.Error())
+			return
+		}
+	}
+	start, err := resolveServerPath(containerId, q.Get("path"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if info, err := os.Stat(start); errors.Is(err, os.ErrNotExist) {
+		writeError(w, http.StatusNotFound, "Directory not found")
+		return
+	} else if err != nil || !info.IsDir() {
+		writeError(w, http.StatusBadRequest, "path must be a directory")
+		return
+	}
+
+	root := getServerDataDir(containerId)
+	nameOnly := !strings.Contains(name, "/")
+	resp := SearchResponse{Status: "ok", Matches: []SearchMatch{}}
+	filepath.WalkDir(start, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || !d.Type().IsRegular() {
+			return nil
+		}
+		if r.Context().Err() != nil {
+			return filepath.SkipAll
+		}
+		rel, _ := filepath.Rel(root, p)
+		rel = filepath.ToSlash(rel)
+		if name != "" {
+			subject := rel
+			if nameOnly {
+				subject = d.Name()
+			}
+			if ok, _ := path.Match(name, subject); !ok {
+				return nil
+			}
+		}
+		if contains == "" {
+			resp.Matches = append(resp.Matches, SearchMatch{Path: rel})
+		} else if !regionFileExts[strings.ToLower(filepath.Ext(p))] {
+			resp.Matches = append(resp.Matches, searchFile(p, rel, contains, maxSearchMatches-len(resp.Matches))...)
+		}
+		if len(resp.Matches) >= maxSearchMatches {
+			resp.Truncated = true
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// searchFile returns up to limit lines of the file at p containing text. Large
+// and binary files yield nothing.
+func searchFile(p, rel, text string, limit int) []SearchMatch {
+	info, err := os.Stat(p)
+	if err != nil || info.Size() > maxSearchFileSize {
+		return nil
+	}
+	data, err := os.ReadFile(p)
+	if err != nil || bytes.IndexByte(data, 0) >= 0 || !bytes.Contains(data, []byte(text)) {
+		return nil
+	}
+	var matches []SearchMatch
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, maxSearchFileSize)
+	for line := 1; sc.Scan() && len(matches) < limit; line++ {
+		if strings.Contains(sc.Text(), text) {
+			matches = append(matches, SearchMatch{Path: rel, Line: line, Snippet: truncateLine(strings.TrimSpace(sc.Text()))})
+		}
+	}
+	return matches
+}
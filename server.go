@@ -0,0 +1,416 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/docker/docker/api/types"
+)
+
+// GenericResponse is the JSON shape returned by most endpoints.
+type GenericResponse struct {
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// RequestID is set on errors; it matches the request's log line.
+	RequestID string `json:"requestId,omitempty"`
+}
+
+var errPathEscape = errors.New("path escapes server directory")
+
+// bodyError is a request body decodeJSONBody refused, with the status to
+// answer it with.
+type bodyError struct {
+	code int
+	msg  string
+}
+
+func (e *bodyError) Error() string { return e.msg }
+
+// decodeJSONBody decodes the request body into v. The body must be sent as
+// application/json (415 otherwise) and may only have fields v knows, spelt
+// exactly as documented, so a typo is an error rather than a field silently
+// left at its default.
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	return decodeJSONBodyLimit(r, v, maxJSONBody())
+}
+
+// maxJSONBody is MAX_JSON_BODY (default 1M), the largest JSON body an
+// endpoint accepts unless it carries file contents.
+func maxJSONBody() int64 {
+	return envSize("MAX_JSON_BODY", 1<<20)
+}
+
+// decodeJSONBodyLimit is decodeJSONBody for bodies of up to limit bytes;
+// larger ones are refused with 413 without being read in full.
+func decodeJSONBodyLimit(r *http.Request, v interface{}, limit int64) error {
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType != "application/json" {
+		return &bodyError{http.StatusUnsupportedMediaType, "Content-Type must be application/json"}
+	}
+	data, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, limit))
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return &bodyError{http.StatusRequestEntityTooLarge, "Request body exceeds " + humanBytes(limit)}
+	}
+	if err != nil {
+		return &bodyError{http.StatusBadRequest, "Failed to read request body"}
+	}
+	if len(bytes.TrimSpace(data)) == 0 {
+		return &bodyError{http.StatusBadRequest, "Request body is empty"}
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	err = dec.Decode(v)
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case err == nil:
+		return misspeltField(data, v)
+	case errors.As(err, &syntaxErr):
+		return &bodyError{http.StatusBadRequest, fmt.Sprintf("Invalid request body: malformed JSON at offset %d", syntaxErr.Offset)}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return &bodyError{http.StatusBadRequest, "Invalid request body: malformed JSON, the body ends early"}
+	case errors.As(err, &typeErr) && typeErr.Field != "":
+		return &bodyError{http.StatusBadRequest, fmt.Sprintf("Invalid request body: %s must be %s", typeErr.Field, typeErr.Type)}
+	case strings.HasPrefix(err.Error(), "json: unknown field "):
+		return &bodyError{http.StatusBadRequest, "Invalid request body: unknown field " + strings.TrimPrefix(err.Error(), "json: unknown field ")}
+	default:
+		return &bodyError{http.StatusBadRequest, "Invalid request body"}
+	}
+}
+
+// misspeltField catches what DisallowUnknownFields lets through: encoding/json
+// matches names case-insensitively, so "servername" would fill serverName.
+// Top-level keys of data must match a field of v exactly.
+func misspeltField(data []byte, v interface{}) error {
+	t := reflect.TypeOf(v)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return nil
+	}
+	var keys map[string]json.RawMessage
+	if json.Unmarshal(data, &keys) != nil {
+		return nil
+	}
+	names := jsonFieldNames(t)
+	for key := range keys {
+		if names[key] {
+			continue
+		}
+		for name := range names {
+			if strings.EqualFold(name, key) {
+				return &bodyError{http.StatusBadRequest, fmt.Sprintf("Invalid request body: unknown field %q, did you mean %q?", key, name)}
+			}
+		}
+	}
+	return nil
+}
+
+// jsonFieldNames returns the JSON names of a struct's fields, including those
+// of embedded structs.
+func jsonFieldNames(t reflect.Type) map[string]bool {
+	names := map[string]bool{}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if tag == "-" || (!f.IsExported() && !f.Anonymous) {
+			continue
+		}
+		if f.Anonymous && tag == "" && f.Type.Kind() == reflect.Struct {
+			for name := range jsonFieldNames(f.Type) {
+				names[name] = true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = f.Name
+		}
+		names[tag] = true
+	}
+	return names
+}
+
+// writeBodyError answers a request whose body decodeJSONBody refused.
+func writeBodyError(w http.ResponseWriter, err error) {
+	var be *bodyError
+	if errors.As(err, &be) {
+		writeError(w, be.code, be.msg)
+		return
+	}
+	writeError(w, http.StatusBadRequest, "Invalid request body")
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// writeError sends message as a JSON GenericResponse with status "error", so
+// clients can decode every response body the same way.
+// The request ID is included so a client's report can be matched to the log.
+func writeError(w http.ResponseWriter, code int, message string) {
+	resp := GenericResponse{Status: "error", Message: message}
+	for lw := w; lw != nil; {
+		if l, ok := lw.(*loggingResponseWriter); ok {
+			l.errorMsg = message
+			resp.RequestID = l.requestId
+			break
+		}
+		u, ok := lw.(interface{ Unwrap() http.ResponseWriter })
+		if !ok {
+			break
+		}
+		lw = u.Unwrap()
+	}
+	writeJSON(w, code, resp)
+}
+
+// containerIdFromQuery reads serverName and userEmail from the query string
+// and returns the matching container ID. It writes a 400 and returns false
+// when either is missing, or a 403 when the caller doesn't own the server.
+func containerIdFromQuery(w http.ResponseWriter, r *http.Request) (string, bool) {
+	serverName := r.URL.Query().Get("serverName")
+	userEmail := ownerEmail(r, r.URL.Query().Get("userEmail"))
+	if serverName == "" || userEmail == "" {
+		writeError(w, http.StatusBadRequest, "serverName and userEmail are required")
+		return "", false
+	}
+	if err := validateServerName(serverName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return "", false
+	}
+	if !authorizeOwner(w, r, userEmail) {
+		return "", false
+	}
+	return buildContainerId(serverName, userEmail), true
+}
+
+// inspectServer inspects the server's container for a handler. It writes a
+// 404 when the container doesn't exist, a 500 when Docker couldn't be asked,
+// and returns false in both cases.
+func inspectServer(w http.ResponseWriter, r *http.Request, containerId string) (types.ContainerJSON, bool) {
+	info, err := inspectContainer(r.Context(), containerId)
+	if err != nil {
+		if isNotFound(err) {
+			writeError(w, http.StatusNotFound, "Server not found")
+		} else {
+			writeError(w, http.StatusInternalServerError, "Failed to inspect server: "+err.Error())
+		}
+		return types.ContainerJSON{}, false
+	}
+	return info, true
+}
+
+// extractUserId returns the local part of an email, sanitized for use in a
+// container name.
+func extractUserId(email string) string {
+	if i := strings.Index(email, "@"); i >= 0 {
+		email = email[:i]
+	}
+	return sanitizeDockerName(email)
+}
+
+// sanitizeDockerName lowercases name and drops every character Docker doesn't
+// accept in a container name.
+func sanitizeDockerName(name string) string {
+	var b strings.Builder
+	for _, c := range strings.ToLower(name) {
+		if (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || c == '-' || c == '_' || c == '.' {
+			b.WriteRune(c)
+		}
+	}
+	return b.String()
+}
+
+const (
+	minServerNameLength = 3
+	maxServerNameLength = 32
+)
+
+// validateServerName rejects names that can't safely become a container name
+// and volume directory. The name is also echoed into paths and RCON commands,
+// so only letters, digits, spaces, '-', '_' and '.' are allowed.
+func validateServerName(name string) error {
+	if n := utf8.RuneCountInString(name); n < minServerNameLength || n > maxServerNameLength {
+		return fmt.Errorf("serverName must be between %d and %d characters", minServerNameLength, maxServerNameLength)
+	}
+	alnum := false
+	for _, c := range name {
+		switch {
+		case unicode.IsLetter(c) || unicode.IsDigit(c):
+			alnum = true
+		case c == ' ' || c == '-' || c == '_' || c == '.':
+		default:
+			return fmt.Errorf("serverName contains %q: only letters, digits, spaces, '-', '_' and '.' are allowed", c)
+		}
+	}
+	if !alnum {
+		return errors.New("serverName must contain at least one letter or digit")
+	}
+	if strings.Trim(sanitizeDockerName(name), "-_.") == "" {
+		return errors.New("serverName must contain at least one ASCII letter or digit")
+	}
+	return nil
+}
+
+// containerIdSeparator joins the server name and the userId in a container
+// ID. The server part never contains it (see serverIdPart), so an ID splits at
+// its first occurrence and no server name can spell out another user's ID.
+const containerIdSeparator = "--"
+
+// buildContainerId names a user's server container as
+// <server-name>--<userId>. The same name is used for its volume directory.
+func buildContainerId(serverName, userEmail string) string {
+	return serverIdPart(serverName) + containerIdSeparator + extractUserId(userEmail)
+}
+
+// serverIdPart is the server name as it appears in a container ID: sanitized,
+// with runs of '-' collapsed and trailing ones dropped, so it can't contain or
+// run into containerIdSeparator.
+func serverIdPart(serverName string) string {
+	s := sanitizeDockerName(serverName)
+	for strings.Contains(s, "--") {
+		s = strings.ReplaceAll(s, "--", "-")
+	}
+	return strings.TrimRight(s, "-")
+}
+
+// getServerDataDir is the host directory mounted at /data in the container.
+// A migrated server's volume lives on another disk and serverVolumeLink
+// points at it, so the link is resolved here.
+func getServerDataDir(containerId string) string {
+	link := serverVolumeLink(containerId)
+	if dir, err := filepath.EvalSymlinks(link); err == nil {
+		return dir
+	}
+	return link
+}
+
+// serverVolumeLink is where a server's volume is found under the volume root:
+// the directory itself, or a symlink to it after a migration.
+func serverVolumeLink(containerId string) string {
+	return filepath.Join(volumeRoot(), containerId)
+}
+
+// volumeRootDir is the absolute directory holding every server's volume. It is
+// set once at startup by configureVolumeRoot.
+var volumeRootDir = mustAbs("volume")
+
+// volumeRoot is the directory server volumes are created in.
+func volumeRoot() string {
+	return volumeRootDir
+}
+
+// configureVolumeRoot reads VOLUME_ROOT (default ./volume) as an absolute path,
+// so it no longer depends on the working directory later on, and creates it.
+func configureVolumeRoot() {
+	volumeRootDir = mustAbs(envOr("VOLUME_ROOT", "volume"))
+	if err := os.MkdirAll(volumeRootDir, 0755); err != nil {
+		fatal("Failed to create volume root", "path", volumeRootDir, "err", err)
+	}
+}
+
+// stagingDirName is the directory, next to the volumes it serves, that holds
+// half-built and set-aside volume data. It can't be a container ID, as those
+// always contain containerIdSeparator.
+const stagingDirName = ".staging"
+
+// makeStagingDir creates a private, empty directory under dir's staging
+// directory, on the same filesystem as the volumes in dir so its contents
+// can be renamed into place. Paths built from a container ID plus a suffix
+// would be the volume of some other user's server.
+func makeStagingDir(dir string) (string, error) {
+	root := filepath.Join(dir, stagingDirName)
+	if err := os.MkdirAll(root, 0700); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(root, "tmp-")
+}
+
+// resolveServerPath joins rel onto the server's data directory and refuses
+// any result that would land outside of it. The server itself can create
+// symlinks in its volume, so the path is checked after following them: the
+// directories leading to it are resolved, and a symlink at the path itself
+// must point back inside the volume. Use resolveServerEntry for operations
+// that act on a symlink rather than follow it.
+func resolveServerPath(containerId, rel string) (string, error) {
+	return resolveInVolume(containerId, rel, true)
+}
+
+// resolveServerEntry is resolveServerPath for deleting and renaming, which act
+// on the directory entry itself, so a symlink there isn't followed and may
+// point anywhere.
+func resolveServerEntry(containerId, rel string) (string, error) {
+	return resolveInVolume(containerId, rel, false)
+}
+
+func resolveInVolume(containerId, rel string, followFinal bool) (string, error) {
+	base, err := realPath(getServerDataDir(containerId))
+	if err != nil {
+		return "", err
+	}
+	full := filepath.Join(base, filepath.FromSlash(rel))
+	if !withinDir(base, full) {
+		return "", errPathEscape
+	}
+	if full == base {
+		return base, nil
+	}
+	parent, err := realPath(filepath.Dir(full))
+	if err != nil {
+		return "", err
+	}
+	if !withinDir(base, parent) {
+		return "", errPathEscape
+	}
+	full = filepath.Join(parent, filepath.Base(full))
+	if info, err := os.Lstat(full); followFinal && err == nil && info.Mode()&fs.ModeSymlink != 0 {
+		target, err := filepath.EvalSymlinks(full)
+		if err != nil || !withinDir(base, target) {
+			return "", errPathEscape
+		}
+	}
+	return full, nil
+}
+
+// withinDir reports whether path is dir or lies below it, comparing the paths
+// as text.
+func withinDir(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// realPath resolves the symlinks in the part of path that exists and appends
+// the rest unchanged. A dangling symlink on the way is an escape, as whatever
+// is created through it lands wherever it points.
+func realPath(path string) (string, error) {
+	rest := ""
+	for p := path; ; p = filepath.Dir(p) {
+		real, err := filepath.EvalSymlinks(p)
+		if err == nil {
+			return filepath.Join(real, rest), nil
+		}
+		if _, lerr := os.Lstat(p); lerr == nil || !errors.Is(err, os.ErrNotExist) {
+			return "", errPathEscape
+		}
+		if filepath.Dir(p) == p {
+			return path, nil
+		}
+		rest = filepath.Join(filepath.Base(p), rest)
+	}
+}
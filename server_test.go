@@ -0,0 +1,209 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecodeJSONBodyLimit(t *testing.T) {
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		limit       int64
+		wantCode    int
+	}{
+		{"valid", "application/json", `{"serverName":"lobby","userEmail":"alice@example.com"}`, 1024, 0},
+		{"charset parameter", "application/json; charset=utf-8", `{"serverName":"lobby"}`, 1024, 0},
+		{"exactly the limit", "application/json", `{"serverName":"lobby"}`, int64(len(`{"serverName":"lobby"}`)), 0},
+		{"no content type", "", `{"serverName":"lobby"}`, 1024, http.StatusUnsupportedMediaType},
+		{"form content type", "application/x-www-form-urlencoded", `{"serverName":"lobby"}`, 1024, http.StatusUnsupportedMediaType},
+		{"text content type", "text/plain", `{"serverName":"lobby"}`, 1024, http.StatusUnsupportedMediaType},
+		{"unknown field", "application/json", `{"serverName":"lobby","serverNmae":"x"}`, 1024, http.StatusBadRequest},
+		{"field in the wrong case", "application/json", `{"servername":"lobby"}`, 1024, http.StatusBadRequest},
+		{"wrong type", "application/json", `{"serverName":42}`, 1024, http.StatusBadRequest},
+		{"malformed", "application/json", `{"serverName":`, 1024, http.StatusBadRequest},
+		{"empty", "application/json", "  ", 1024, http.StatusBadRequest},
+		{"oversized", "application/json", `{"serverName":"` + strings.Repeat("a", 2048) + `"}`, 1024, http.StatusRequestEntityTooLarge},
+		{"one byte over", "application/json", `{"serverName":"lobby"}`, int64(len(`{"serverName":"lobby"}`)) - 1, http.StatusRequestEntityTooLarge},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/server/stop", strings.NewReader(tt.body))
+			if tt.contentType != "" {
+				r.Header.Set("Content-Type", tt.contentType)
+			}
+			var req StopServerRequest
+			err := decodeJSONBodyLimit(r, &req, tt.limit)
+			if tt.wantCode == 0 {
+				if err != nil {
+					t.Fatalf("decodeJSONBodyLimit() error = %v", err)
+				}
+				if req.ServerName != "lobby" {
+					t.Errorf("serverName = %q, want %q", req.ServerName, "lobby")
+				}
+				return
+			}
+			var bodyErr *bodyError
+			if !errors.As(err, &bodyErr) {
+				t.Fatalf("decodeJSONBodyLimit() error = %v, want a bodyError", err)
+			}
+			if bodyErr.code != tt.wantCode {
+				t.Errorf("status = %d (%s), want %d", bodyErr.code, bodyErr.msg, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestValidateServerName(t *testing.T) {
+	for _, name := range []string{"lobby", "My Server", "survival-2", "mod_pack.v2", "abc", strings.Repeat("a", 32), "Überwelt"} {
+		if err := validateServerName(name); err != nil {
+			t.Errorf("validateServerName(%q) error = %v", name, err)
+		}
+	}
+
+	for _, name := range []string{
+		"",
+		"ab",
+		strings.Repeat("a", 33),
+		"../../",
+		"../../etc",
+		"lobby/../../x",
+		`..\..\x`,
+		"   ",
+		"\t\t\t",
+		"---",
+		"...",
+		"; rm -rf /",
+		"lobby; rm -rf",
+		"$(reboot)",
+		"`id`",
+		"a|b|c",
+		"lobby\nop bob",
+		"lobby\x00",
+		"ÜÜÜ",
+	} {
+		if err := validateServerName(name); err == nil {
+			t.Errorf("validateServerName(%q) accepted", name)
+		}
+	}
+}
+
+// useVolumeRoot points the volume root at a temporary directory for the rest
+// of the test and returns it.
+func useVolumeRoot(t *testing.T) string {
+	t.Helper()
+	saved := volumeRootDir
+	volumeRootDir = t.TempDir()
+	t.Cleanup(func() { volumeRootDir = saved })
+	return volumeRootDir
+}
+
+func TestListDirHandler(t *testing.T) {
+	root := useVolumeRoot(t)
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	vol := filepath.Join(root, "lobby--alice")
+	for _, dir := range []string{"world", "Plugins", "logs", "world/region"} {
+		if err := os.MkdirAll(filepath.Join(vol, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, file := range []string{"server.properties", "Banned-players.json", "eula.txt", "world/level.dat"} {
+		if err := os.WriteFile(filepath.Join(vol, file), []byte("x"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{"escape": outside, "escape-file": filepath.Join(outside, "secret.txt"), "worlds": "world", "dangling": filepath.Join(outside, "missing")} {
+		if err := os.Symlink(target, filepath.Join(vol, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	list := func(path string) *httptest.ResponseRecorder {
+		q := url.Values{"serverName": {"lobby"}, "userEmail": {"alice@example.com"}, "path": {path}}
+		rec := httptest.NewRecorder()
+		listDirHandler(rec, httptest.NewRequest(http.MethodGet, "/file_manager/list?"+q.Encode(), nil))
+		return rec
+	}
+
+	tests := []struct {
+		name string
+		path string
+		want int
+	}{
+		{"root", "", http.StatusOK},
+		{"dot", ".", http.StatusOK},
+		{"subdirectory", "world", http.StatusOK},
+		{"symlink within the volume", "worlds", http.StatusOK},
+		{"parent", "../", http.StatusBadRequest},
+		{"parent without slash", "..", http.StatusBadRequest},
+		{"other server", "../lobby--bob", http.StatusBadRequest},
+		{"up and out", "a/../../x", http.StatusBadRequest},
+		{"deep escape", "world/../../../../etc", http.StatusBadRequest},
+		// Absolute paths are taken relative to the volume.
+		{"absolute", "/etc", http.StatusNotFound},
+		{"absolute within the volume", "/world", http.StatusOK},
+		{"symlink outside the volume", "escape", http.StatusBadRequest},
+		{"through a symlink outside the volume", "escape/", http.StatusBadRequest},
+		{"dangling symlink", "dangling", http.StatusBadRequest},
+		{"file", "server.properties", http.StatusBadRequest},
+		{"missing", "nope", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if rec := list(tt.path); rec.Code != tt.want {
+			t.Errorf("%s: list %q status = %d (%s), want %d", tt.name, tt.path, rec.Code, strings.TrimSpace(rec.Body.String()), tt.want)
+		}
+	}
+
+	rec := list("")
+	var entries []FileEntry
+	if err := json.Unmarshal(rec.Body.Bytes(), &entries); err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name)
+	}
+	// Directories first, each group ordered by name ignoring case; symlinks
+	// are listed as files.
+	want := []string{"logs", "Plugins", "world", "Banned-players.json", "dangling", "escape", "escape-file", "eula.txt", "server.properties", "worlds"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("entries = %v, want %v", names, want)
+	}
+}
+
+func TestResolveServerEntry(t *testing.T) {
+	root := useVolumeRoot(t)
+	outside := t.TempDir()
+	vol := filepath.Join(root, "lobby--alice")
+	if err := os.MkdirAll(vol, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(vol, "escape")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The entry itself may be a symlink pointing anywhere; going through it
+	// may not.
+	if got, err := resolveServerEntry("lobby--alice", "escape"); err != nil || got != filepath.Join(vol, "escape") {
+		t.Errorf("resolveServerEntry(escape) = %q, %v; want the link itself", got, err)
+	}
+	for _, rel := range []string{"escape/x", "../lobby--bob", "a/../../x"} {
+		if got, err := resolveServerEntry("lobby--alice", rel); !errors.Is(err, errPathEscape) {
+			t.Errorf("resolveServerEntry(%q) = %q, %v; want errPathEscape", rel, got, err)
+		}
+	}
+	if _, err := resolveServerPath("lobby--alice", "escape"); !errors.Is(err, errPathEscape) {
+		t.Errorf("resolveServerPath(escape) error = %v, want errPathEscape", err)
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/docker/docker/api/types/container"
+)
+
+// IOStat is a pair of cumulative byte counters, e.g. network rx/tx or block
+// device read/write.
+type IOStat struct {
+	In  int64 `json:"in"`
+	Out int64 `json:"out"`
+}
+
+type ServerStats struct {
+	CPUPercent float64 `json:"cpuPercent"`
+	MemUsage   int64   `json:"memUsage"`
+	MemLimit   int64   `json:"memLimit"`
+	MemPercent float64 `json:"memPercent"`
+	NetIO      IOStat  `json:"netIO"`
+	BlockIO    IOStat  `json:"blockIO"`
+}
+
+type ServerStatsResponse struct {
+	Status string      `json:"status"`
+	Stats  ServerStats `json:"stats"`
+}
+
+// statsFromDocker computes the figures `docker stats` shows from one raw
+// sample: CPU usage is the container's share of the host CPU time between the
+// sample and the previous one, scaled by the number of CPUs, and memory usage
+// excludes the reclaimable page cache.
+func statsFromDocker(raw container.StatsResponse) ServerStats {
+	var st ServerStats
+	cpuDelta := float64(raw.CPUStats.CPUUsage.TotalUsage) - float64(raw.PreCPUStats.CPUUsage.TotalUsage)
+	systemDelta := float64(raw.CPUStats.SystemUsage) - float64(raw.PreCPUStats.SystemUsage)
+	cpus := float64(raw.CPUStats.OnlineCPUs)
+	if cpus == 0 {
+		cpus = float64(len(raw.CPUStats.CPUUsage.PercpuUsage))
+	}
+	if cpuDelta > 0 && systemDelta > 0 {
+		st.CPUPercent = cpuDelta / systemDelta * cpus * 100
+	}
+
+	usage := raw.MemoryStats.Usage
+	// cgroup v1 reports total_inactive_file, v2 inactive_file.
+	cache, ok := raw.MemoryStats.Stats["total_inactive_file"]
+	if !ok {
+		cache = raw.MemoryStats.Stats["inactive_file"]
+	}
+	if cache < usage {
+		usage -= cache
+	}
+	st.MemUsage = int64(usage)
+	st.MemLimit = int64(raw.MemoryStats.Limit)
+	if st.MemLimit > 0 {
+		st.MemPercent = float64(st.MemUsage) / float64(st.MemLimit) * 100
+	}
+
+	for _, n := range raw.Networks {
+		st.NetIO.In += int64(n.RxBytes)
+		st.NetIO.Out += int64(n.TxBytes)
+	}
+	for _, e := range raw.BlkioStats.IoServiceBytesRecursive {
+		switch strings.ToLower(e.Op) {
+		case "read":
+			st.BlockIO.In += int64(e.Value)
+		case "write":
+			st.BlockIO.Out += int64(e.Value)
+		}
+	}
+	return st
+}
+
+func serverStatsHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	if !info.State.Running {
+		writeError(w, http.StatusNotFound, "Server is not running")
+		return
+	}
+
+	stats, err := readStats(containerId)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read stats: "+err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, ServerStatsResponse{Status: "ok", Stats: stats})
+}
+
+// readStats takes one stats sample of a running container. Docker waits for
+// a second sample to compute CPU usage from, so this takes about a second.
+func readStats(containerId string) (ServerStats, error) {
+	resp, err := dockerClient.ContainerStats(context.Background(), containerId, false)
+	if err != nil {
+		return ServerStats{}, err
+	}
+	defer resp.Body.Close()
+	var raw container.StatsResponse
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return ServerStats{}, err
+	}
+	return statsFromDocker(raw), nil
+}
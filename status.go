@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/docker/docker/api/types"
+)
+
+// ServerStatus is a server's container state as reported by Docker.
+type ServerStatus struct {
+	// State is Docker's state: created, running, paused, restarting,
+	// exited or dead.
+	State   string `json:"state"`
+	Running bool   `json:"running"`
+	// ExitCode is the code of the last exit; 0 while the server has never
+	// stopped.
+	ExitCode int `json:"exitCode"`
+	// CreatedAt is when the container was created, which is when the server
+	// was created or last recreated.
+	CreatedAt string `json:"createdAt,omitempty"`
+	// StartedAt is set while the server is running.
+	StartedAt string `json:"startedAt"`
+	// Health is the image's health check result (starting, healthy or
+	// unhealthy), empty when the image has none.
+	Health string `json:"health,omitempty"`
+}
+
+type ServerStatusResponse struct {
+	Status string `json:"status"`
+	ServerStatus
+	Uptime        string `json:"uptime"`
+	UptimeSeconds int64  `json:"uptimeSeconds"`
+
+	Bandwidth *BandwidthStatus `json:"bandwidth,omitempty"`
+}
+
+// serverStatus extracts the status fields from an inspected container.
+func serverStatus(info types.ContainerJSON) ServerStatus {
+	st := ServerStatus{
+		State:    info.State.Status,
+		Running:  info.State.Running,
+		ExitCode: info.State.ExitCode,
+	}
+	if info.State.Health != nil {
+		st.Health = info.State.Health.Status
+	}
+	if t, err := time.Parse(time.RFC3339Nano, info.Created); err == nil {
+		st.CreatedAt = t.UTC().Format(time.RFC3339)
+	}
+	if st.Running {
+		// Docker reports StartedAt in UTC, so this is independent of the
+		// host's local timezone.
+		if t, err := time.Parse(time.RFC3339Nano, info.State.StartedAt); err == nil {
+			st.StartedAt = t.UTC().Format(time.RFC3339)
+		}
+	}
+	return st
+}
+
+// formatUptime renders d using its two largest units, e.g. "3 days 4 hours".
+func formatUptime(d time.Duration) string {
+	days := int64(d / (24 * time.Hour))
+	hours := int64(d/time.Hour) % 24
+	minutes := int64(d/time.Minute) % 60
+	switch {
+	case days > 0 && hours > 0:
+		return plural(days, "day") + " " + plural(hours, "hour")
+	case days > 0:
+		return plural(days, "day")
+	case hours > 0 && minutes > 0:
+		return plural(hours, "hour") + " " + plural(minutes, "minute")
+	case hours > 0:
+		return plural(hours, "hour")
+	case minutes > 0:
+		return plural(minutes, "minute")
+	default:
+		return plural(int64(d/time.Second), "second")
+	}
+}
+
+func plural(n int64, unit string) string {
+	if n == 1 {
+		return fmt.Sprintf("1 %s", unit)
+	}
+	return fmt.Sprintf("%d %ss", n, unit)
+}
+
+func serverStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+
+	info, ok := inspectServer(w, r, containerId)
+	if !ok {
+		return
+	}
+	resp := ServerStatusResponse{Status: "ok", ServerStatus: serverStatus(info)}
+	resp.Bandwidth = bandwidthStatus(containerId, info.Config.Labels[bandwidthIngressLabel], info.Config.Labels[bandwidthEgressLabel])
+	if t, err := time.Parse(time.RFC3339, resp.StartedAt); err == nil {
+		up := time.Since(t)
+		if up < 0 {
+			up = 0
+		}
+		resp.UptimeSeconds = int64(up / time.Second)
+		resp.Uptime = formatUptime(up)
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
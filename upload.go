@@ -0,0 +1,363 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// UploadChunkResponse reports how far an upload has got. On a 409 Received
+// is the index of the next chunk the agent expects, so a client can resume.
+type UploadChunkResponse struct {
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	UploadID string `json:"uploadId"`
+	Received int    `json:"received"`
+	Total    int    `json:"total"`
+	Size     int64  `json:"size"`
+	Complete bool   `json:"complete"`
+}
+
+type FinalizeUploadRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	UploadID   string `json:"uploadId"`
+	Overwrite  bool   `json:"overwrite"`
+}
+
+// maxUploadChunkSize bounds a single chunk; files of any size can be sent as
+// many chunks.
+const maxUploadChunkSize = 64 << 20
+
+var uploadIdPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+// uploadSession is one chunked upload in progress. Chunks are appended in
+// order to tmp, next to the destination so finalizing is a rename. Only
+// requests for containerId, the server it was started for, can see it.
+type uploadSession struct {
+	mu          sync.Mutex
+	containerId string
+	rel         string
+	dest        string
+	tmp         string
+	total       int
+	received    int
+	size        int64
+	updatedAt   time.Time
+	// expired is set once the janitor has removed the partial file.
+	expired bool
+}
+
+type uploadStore struct {
+	mu       sync.Mutex
+	sessions map[string]*uploadSession
+}
+
+var uploads = &uploadStore{sessions: map[string]*uploadSession{}}
+
+func (s *uploadStore) get(id string) *uploadSession {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.sessions[id]
+}
+
+// getOrCreate returns the session for id, creating it with fn if it doesn't
+// exist yet.
+func (s *uploadStore) getOrCreate(id string, fn func() (*uploadSession, error)) (*uploadSession, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if sess, ok := s.sessions[id]; ok {
+		return sess, nil
+	}
+	sess, err := fn()
+	if err != nil {
+		return nil, err
+	}
+	s.sessions[id] = sess
+	return sess, nil
+}
+
+func (s *uploadStore) remove(id string) {
+	s.mu.Lock()
+	delete(s.sessions, id)
+	s.mu.Unlock()
+}
+
+// startJanitor drops uploads that haven't received a chunk for ttl, along
+// with their partial files.
+func (s *uploadStore) startJanitor(ttl time.Duration) {
+	go func() {
+		for range time.Tick(ttl / 4) {
+			cutoff := time.Now().Add(-ttl)
+			s.mu.Lock()
+			sessions := make(map[string]*uploadSession, len(s.sessions))
+			for id, sess := range s.sessions {
+				sessions[id] = sess
+			}
+			s.mu.Unlock()
+
+			// Handlers take a session's lock before the store's, so only
+			// one lock is held at a time here.
+			for id, sess := range sessions {
+				sess.mu.Lock()
+				stale := !sess.expired && sess.updatedAt.Before(cutoff)
+				if stale {
+					sess.expired = true
+					os.Remove(sess.tmp)
+				}
+				sess.mu.Unlock()
+				if stale {
+					s.remove(id)
+				}
+			}
+		}
+	}()
+}
+
+// uploadChunkHandler receives one chunk of a file upload. The client picks an
+// upload ID and sends chunks 0..X-Total-Chunks-1 in order as raw request
+// bodies; the first chunk names the destination with the path query
+// parameter. Re-sending a chunk that was already stored is a no-op, so a
+// client can retry after a dropped connection. GET reports the progress.
+func uploadChunkHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	containerId, ok := containerIdFromQuery(w, r)
+	if !ok {
+		return
+	}
+	id := r.Header.Get("X-Upload-Id")
+	if id == "" {
+		id = r.URL.Query().Get("uploadId")
+	}
+	if !uploadIdPattern.MatchString(id) {
+		writeError(w, http.StatusBadRequest, "X-Upload-Id must be 1-64 letters, digits, '-' or '_'")
+		return
+	}
+	if r.Method == http.MethodGet {
+		sess := uploads.get(id)
+		if sess == nil || sess.containerId != containerId {
+			writeError(w, http.StatusNotFound, "Upload not found")
+			return
+		}
+		sess.mu.Lock()
+		defer sess.mu.Unlock()
+		if sess.expired {
+			writeError(w, http.StatusNotFound, "Upload not found")
+			return
+		}
+		writeJSON(w, http.StatusOK, sess.progress(id, "ok", ""))
+		return
+	}
+
+	index, err := strconv.Atoi(r.Header.Get("X-Chunk-Index"))
+	if err != nil || index < 0 {
+		writeError(w, http.StatusBadRequest, "X-Chunk-Index must be a non-negative integer")
+		return
+	}
+	total, err := strconv.Atoi(r.Header.Get("X-Total-Chunks"))
+	if err != nil || total < 1 || index >= total {
+		writeError(w, http.StatusBadRequest, "X-Total-Chunks must be a positive integer greater than X-Chunk-Index")
+		return
+	}
+
+	sess, err := uploads.getOrCreate(id, func() (*uploadSession, error) {
+		return newUploadSession(containerId, r.URL.Query().Get("path"), id, total)
+	})
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	// Upload IDs are global, so one picked by, or leaked to, another user
+	// must not reach this session.
+	if sess.containerId != containerId {
+		writeError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.expired {
+		writeError(w, http.StatusNotFound, "Upload expired, start again")
+		return
+	}
+	if total != sess.total {
+		writeJSON(w, http.StatusConflict, sess.progress(id, "error", fmt.Sprintf("X-Total-Chunks changed from %d", sess.total)))
+		return
+	}
+	if index < sess.received {
+		writeJSON(w, http.StatusOK, sess.progress(id, "ok", "Chunk already received"))
+		return
+	}
+	if index > sess.received {
+		writeJSON(w, http.StatusConflict, sess.progress(id, "error", fmt.Sprintf("Expected chunk %d", sess.received)))
+		return
+	}
+
+	// The chunk may be cut short by the chunk size, MAX_FILE_SIZE or the
+	// server's disk quota, whichever leaves the least room.
+	limit, tooLarge := int64(maxUploadChunkSize), fileOpErrorf(http.StatusRequestEntityTooLarge, "Chunks may be at most %d bytes", maxUploadChunkSize)
+	if rest := maxFileSize() - sess.size; rest < limit {
+		limit, tooLarge = rest, errFileTooLarge()
+	}
+	room, err := quotaRoom(containerId)
+	if err != nil {
+		writeFileOpError(w, err)
+		return
+	}
+	if room >= 0 && room < limit {
+		limit, tooLarge = room, errQuotaExceeded(containerId)
+	}
+	before := sess.size
+	if err := sess.appendChunk(http.MaxBytesReader(w, r.Body, limit)); err != nil {
+		var maxBytes *http.MaxBytesError
+		if errors.As(err, &maxBytes) {
+			writeFileOpError(w, tooLarge)
+			return
+		}
+		writeError(w, http.StatusInternalServerError, "Failed to store chunk: "+err.Error())
+		return
+	}
+	// The partial file was created with the first chunk's session.
+	var newFiles int64
+	if index == 0 {
+		newFiles = 1
+	}
+	diskCache.grew(containerId, sess.size-before, newFiles)
+	writeJSON(w, http.StatusOK, sess.progress(id, "ok", ""))
+}
+
+func newUploadSession(containerId, rel, id string, total int) (*uploadSession, error) {
+	if rel == "" {
+		return nil, errors.New("path is required with the first chunk")
+	}
+	dest, err := resolveServerPath(containerId, rel)
+	if err != nil {
+		return nil, err
+	}
+	if dest == getServerDataDir(containerId) {
+		return nil, errors.New("Cannot upload onto the server root directory")
+	}
+	if info, err := os.Stat(dest); err == nil && info.IsDir() {
+		return nil, errors.New("path is a directory")
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return nil, err
+	}
+	tmp := filepath.Join(filepath.Dir(dest), "."+filepath.Base(dest)+".upload-"+id)
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, err
+	}
+	f.Close()
+	return &uploadSession{containerId: containerId, rel: rel, dest: dest, tmp: tmp, total: total, updatedAt: time.Now()}, nil
+}
+
+// appendChunk adds body to the partial file. A chunk that fails halfway is
+// cut off again, so the client can simply resend it.
+func (s *uploadSession) appendChunk(body io.Reader) error {
+	f, err := os.OpenFile(s.tmp, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(f, body)
+	if err == nil {
+		err = f.Close()
+	} else {
+		f.Truncate(s.size)
+		f.Close()
+	}
+	if err != nil {
+		return err
+	}
+	s.size += n
+	s.received++
+	s.updatedAt = time.Now()
+	return nil
+}
+
+func (s *uploadSession) progress(id, status, message string) UploadChunkResponse {
+	return UploadChunkResponse{
+		Status:   status,
+		Message:  message,
+		UploadID: id,
+		Received: s.received,
+		Total:    s.total,
+		Size:     s.size,
+		Complete: s.received == s.total,
+	}
+}
+
+// finalizeUploadHandler moves a completed upload into place with a rename,
+// so the destination is never seen half-written.
+func finalizeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+		return
+	}
+	var req FinalizeUploadRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	if req.ServerName == "" || req.UserEmail == "" || req.UploadID == "" {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and uploadId are required")
+		return
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if !authorizeOwner(w, r, req.UserEmail) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	sess := uploads.get(req.UploadID)
+	if sess == nil || sess.containerId != containerId {
+		writeError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+	unlock, ok := lockServer(w, containerId, "file upload")
+	if !ok {
+		return
+	}
+	defer unlock()
+
+	sess.mu.Lock()
+	defer sess.mu.Unlock()
+	if sess.expired {
+		writeError(w, http.StatusNotFound, "Upload not found")
+		return
+	}
+	if sess.received != sess.total {
+		writeJSON(w, http.StatusConflict, sess.progress(req.UploadID, "error", fmt.Sprintf("Upload incomplete: %d of %d chunks received", sess.received, sess.total)))
+		return
+	}
+	if info, err := os.Lstat(sess.dest); err == nil {
+		if info.IsDir() {
+			writeError(w, http.StatusConflict, "Destination is a directory")
+			return
+		}
+		if !req.Overwrite {
+			writeError(w, http.StatusConflict, "Destination already exists")
+			return
+		}
+	}
+	if err := os.Rename(sess.tmp, sess.dest); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to move upload into place: "+err.Error())
+		return
+	}
+	sess.expired = true
+	uploads.remove(req.UploadID)
+	writeJSON(w, http.StatusOK, GenericResponse{Status: "ok", Message: fmt.Sprintf("Uploaded %s (%d bytes)", sess.rel, sess.size)})
+}
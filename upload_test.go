@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types"
+)
+
+func TestUploadSessionOwner(t *testing.T) {
+	root := useVolumeRoot(t)
+	for _, id := range []string{"lobby--alice", "lobby--bob"} {
+		if err := os.MkdirAll(filepath.Join(root, id), 0755); err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { diskCache.forget(id) })
+	}
+	fakeDocker(t, map[string]types.ContainerJSON{
+		"lobby--alice": ownedServer("alice", "25565"),
+		"lobby--bob":   ownedServer("bob", "25566"),
+	})
+	const uploadId = "upload-owner-test"
+	t.Cleanup(func() { uploads.remove(uploadId) })
+
+	chunk := func(user string, index int, body string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/file/upload/chunk?serverName=lobby&userEmail="+user+"@example.com&path=world.zip", strings.NewReader(body))
+		r.Header.Set("X-Upload-Id", uploadId)
+		r.Header.Set("X-Chunk-Index", strconv.Itoa(index))
+		r.Header.Set("X-Total-Chunks", "2")
+		rec := httptest.NewRecorder()
+		uploadChunkHandler(rec, r)
+		return rec
+	}
+	finalize := func(user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/file/upload/finalize", strings.NewReader(`{"serverName":"lobby","userEmail":"`+user+`@example.com","uploadId":"`+uploadId+`"}`))
+		r.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		finalizeUploadHandler(rec, r)
+		return rec
+	}
+	progress := func(user string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/file/upload/chunk?serverName=lobby&userEmail="+user+"@example.com", nil)
+		r.Header.Set("X-Upload-Id", uploadId)
+		rec := httptest.NewRecorder()
+		uploadChunkHandler(rec, r)
+		return rec
+	}
+
+	if rec := chunk("alice", 0, "alice "); rec.Code != http.StatusOK {
+		t.Fatalf("alice's first chunk: status = %d (%s)", rec.Code, rec.Body)
+	}
+	for name, rec := range map[string]*httptest.ResponseRecorder{
+		"first chunk": chunk("bob", 0, "bob "),
+		"next chunk":  chunk("bob", 1, "bob"),
+		"progress":    progress("bob"),
+		"finalize":    finalize("bob"),
+	} {
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("bob's %s on alice's upload: status = %d (%s), want %d", name, rec.Code, rec.Body, http.StatusNotFound)
+		}
+	}
+
+	if rec := chunk("alice", 1, "world"); rec.Code != http.StatusOK {
+		t.Fatalf("alice's last chunk: status = %d (%s)", rec.Code, rec.Body)
+	}
+	if rec := finalize("alice"); rec.Code != http.StatusOK {
+		t.Fatalf("alice's finalize: status = %d (%s)", rec.Code, rec.Body)
+	}
+	if got, err := os.ReadFile(filepath.Join(root, "lobby--alice", "world.zip")); err != nil || string(got) != "alice world" {
+		t.Errorf("alice's upload = %q, %v; want %q", got, err, "alice world")
+	}
+	if _, err := os.Stat(filepath.Join(root, "lobby--bob", "world.zip")); !os.IsNotExist(err) {
+		t.Errorf("bob's volume got a file: %v", err)
+	}
+}
@@ -0,0 +1,310 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const whitelistFile = "whitelist.json"
+
+// WhitelistEntry is one player in whitelist.json.
+type WhitelistEntry struct {
+	UUID string `json:"uuid"`
+	Name string `json:"name"`
+}
+
+// PlayerRequest names a player by name, uuid or both.
+type PlayerRequest struct {
+	ServerName string `json:"serverName"`
+	UserEmail  string `json:"userEmail"`
+	Player     string `json:"player"`
+	UUID       string `json:"uuid,omitempty"`
+}
+
+type WhitelistResponse struct {
+	Status    string           `json:"status"`
+	Message   string           `json:"message,omitempty"`
+	Whitelist []WhitelistEntry `json:"whitelist"`
+	// Reloaded is set when the running server was told to reload the list.
+	Reloaded bool `json:"reloaded,omitempty"`
+}
+
+var (
+	// playerNamePattern is the character set Mojang allows in Java
+	// Edition names.
+	playerNamePattern = regexp.MustCompile(`^[A-Za-z0-9_]{3,16}$`)
+	uuidPattern       = regexp.MustCompile(`^[0-9a-fA-F]{8}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{4}-?[0-9a-fA-F]{12}$`)
+)
+
+// mojangProfileURL looks up an online-mode player's UUID by name.
+const mojangProfileURL = "https://api.mojang.com/users/profiles/minecraft/"
+
+var mojangClient = &http.Client{Timeout: 10 * time.Second}
+
+var errUnknownPlayer = errors.New("no Minecraft account has that name")
+
+// formatUUID returns a UUID in the dashed lower-case form the server writes.
+func formatUUID(id string) string {
+	id = strings.ToLower(strings.ReplaceAll(id, "-", ""))
+	return id[:8] + "-" + id[8:12] + "-" + id[12:16] + "-" + id[16:20] + "-" + id[20:]
+}
+
+// offlineUUID is the UUID an offline-mode server gives name: a version 3
+// UUID of "OfflinePlayer:<name>".
+func offlineUUID(name string) string {
+	sum := md5.Sum([]byte("OfflinePlayer:" + name))
+	sum[6] = sum[6]&0x0f | 0x30
+	sum[8] = sum[8]&0x3f | 0x80
+	return formatUUID(hex.EncodeToString(sum[:]))
+}
+
+// lookupPlayer returns the UUID and correctly cased name of a Mojang account.
+func lookupPlayer(ctx context.Context, name string) (string, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, mojangProfileURL+name, nil)
+	if err != nil {
+		return "", "", err
+	}
+	resp, err := mojangClient.Do(req)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusNoContent {
+		return "", "", errUnknownPlayer
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("Mojang API answered %s", resp.Status)
+	}
+	var profile struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&profile); err != nil || !uuidPattern.MatchString(profile.ID) {
+		return "", "", errors.New("unexpected reply from the Mojang API")
+	}
+	return formatUUID(profile.ID), profile.Name, nil
+}
+
+// decodePlayerRequest reads and checks a request naming a player, writing a
+// 400 when it is incomplete or the name or uuid is malformed.
+func decodePlayerRequest(w http.ResponseWriter, r *http.Request) (PlayerRequest, bool) {
+	var req PlayerRequest
+	if err := decodeJSONBody(r, &req); err != nil {
+		writeBodyError(w, err)
+		return req, false
+	}
+	req.UserEmail = ownerEmail(r, req.UserEmail)
+	req.Player = strings.TrimSpace(req.Player)
+	req.UUID = strings.TrimSpace(req.UUID)
+	if req.ServerName == "" || req.UserEmail == "" || (req.Player == "" && req.UUID == "") {
+		writeError(w, http.StatusBadRequest, "serverName, userEmail and player or uuid are required")
+		return req, false
+	}
+	if err := validateServerName(req.ServerName); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return req, false
+	}
+	if req.Player != "" && !playerNamePattern.MatchString(req.Player) {
+		writeError(w, http.StatusBadRequest, "player must be 3-16 letters, digits or underscores")
+		return req, false
+	}
+	if req.UUID != "" {
+		if !uuidPattern.MatchString(req.UUID) {
+			writeError(w, http.StatusBadRequest, "uuid is not a valid UUID")
+			return req, false
+		}
+		req.UUID = formatUUID(req.UUID)
+	}
+	return req, authorizeOwner(w, r, req.UserEmail)
+}
+
+// resolvePlayer fills in the UUID, and the name's casing, of the player a
+// request adds. Offline-mode servers derive the UUID from the name; others
+// look it up with Mojang. It writes the error response itself.
+func resolvePlayer(w http.ResponseWriter, r *http.Request, containerId string, req *PlayerRequest) bool {
+	if req.UUID != "" && req.Player != "" {
+		return true
+	}
+	if req.Player == "" {
+		writeError(w, http.StatusBadRequest, "player is required to add a player")
+		return false
+	}
+	lines, _ := readServerProperties(containerId)
+	if propertiesMap(lines)["online-mode"] == "false" {
+		req.UUID = offlineUUID(req.Player)
+		return true
+	}
+	uuid, name, err := lookupPlayer(r.Context(), req.Player)
+	if errors.Is(err, errUnknownPlayer) {
+		writeError(w, http.StatusNotFound, "Player "+req.Player+" not found: "+err.Error())
+		return false
+	}
+	if err != nil {
+		writeError(w, http.StatusBadGateway, "Failed to look up player "+req.Player+": "+err.Error())
+		return false
+	}
+	req.UUID, req.Player = uuid, name
+	return true
+}
+
+// readPlayerFile decodes one of the server's player lists, such as
+// whitelist.json, into v. A missing file is an empty list.
+func readPlayerFile(containerId, file string, v interface{}) error {
+	path, err := resolveServerPath(containerId, file)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) || (err == nil && len(strings.TrimSpace(string(data))) == 0) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("invalid %s: %v", file, err)
+	}
+	return nil
+}
+
+// writePlayerFile writes v to one of the server's player lists, indented as
+// the server writes them.
+func writePlayerFile(containerId, file string, v interface{}) error {
+	path, err := resolveServerPath(containerId, file)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(data, '\n'), 0644)
+}
+
+// samePlayer reports whether the entry with name and uuid is the player req
+// names: by UUID when the request has one, by name otherwise.
+func samePlayer(req PlayerRequest, name, uuid string) bool {
+	if req.UUID != "" && uuid != "" {
+		return strings.EqualFold(strings.ReplaceAll(uuid, "-", ""), strings.ReplaceAll(req.UUID, "-", ""))
+	}
+	return strings.EqualFold(name, req.Player)
+}
+
+// reloadIfRunning runs command on the server if it is running, so it picks
+// up a changed player list, and reports whether it did.
+func reloadIfRunning(r *http.Request, containerId, command string) bool {
+	if !isRunning(containerId) {
+		return false
+	}
+	if out, err := runRcon(containerId, command); err != nil {
+		requestLogger(r).Warn("Failed to reload player list", "server", containerId, "command", command, "err", out)
+		return false
+	}
+	return true
+}
+
+// whitelistHandler lists whitelist.json (GET), adds a player to it (POST) or
+// removes one (DELETE). Changes are written to the file and a running server
+// is told to reload it.
+func whitelistHandler(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		containerId, ok := containerIdFromQuery(w, r)
+		if !ok {
+			return
+		}
+		list := []WhitelistEntry{}
+		if err := readPlayerFile(containerId, whitelistFile, &list); err != nil {
+			writeError(w, http.StatusInternalServerError, "Failed to read whitelist: "+err.Error())
+			return
+		}
+		writeJSON(w, http.StatusOK, WhitelistResponse{Status: "ok", Whitelist: list})
+	case http.MethodPost, http.MethodDelete:
+		updateWhitelist(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "Method not allowed")
+	}
+}
+
+func updateWhitelist(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodePlayerRequest(w, r)
+	if !ok {
+		return
+	}
+	command := "whitelist remove "
+	if r.Method == http.MethodPost {
+		command = "whitelist add "
+	}
+	if req.Player != "" {
+		command += req.Player
+	} else {
+		command += req.UUID
+	}
+	if !allowCommand(w, r, "whitelist", req.ServerName, req.UserEmail, command) {
+		return
+	}
+	containerId := buildContainerId(req.ServerName, req.UserEmail)
+	unlock, ok := lockServer(w, containerId, "whitelist")
+	if !ok {
+		return
+	}
+	defer unlock()
+	if _, ok := inspectServer(w, r, containerId); !ok {
+		return
+	}
+	list := []WhitelistEntry{}
+	if err := readPlayerFile(containerId, whitelistFile, &list); err != nil {
+		writeError(w, http.StatusInternalServerError, "Failed to read whitelist: "+err.Error())
+		return
+	}
+
+	var msg string
+	if r.Method == http.MethodPost {
+		if !resolvePlayer(w, r, containerId, &req) {
+			return
+		}
+		for _, e := range list {
+			if samePlayer(req, e.Name, e.UUID) {
+				writeJSON(w, http.StatusOK, WhitelistResponse{Status: "ok", Message: e.Name + " is already whitelisted", Whitelist: list})
+				return
+			}
+		}
+		list = append(list, WhitelistEntry{UUID: req.UUID, Name: req.Player})
+		msg = "Added " + req.Player + " to the whitelist"
+	} else {
+		kept := list[:0]
+		for _, e := range list {
+			if samePlayer(req, e.Name, e.UUID) {
+				msg = "Removed " + e.Name + " from the whitelist"
+				continue
+			}
+			kept = append(kept, e)
+		}
+		if msg == "" {
+			writeError(w, http.StatusNotFound, "Player is not whitelisted")
+			return
+		}
+		list = kept
+	}
+	if err := writePlayerFile(containerId, whitelistFile, list); err != nil {
+		auditCommand(r, "whitelist", req.ServerName, req.UserEmail, command, auditFailed, err.Error())
+		writeError(w, http.StatusInternalServerError, "Failed to write whitelist: "+err.Error())
+		return
+	}
+	auditCommand(r, "whitelist", req.ServerName, req.UserEmail, command, auditOK, msg)
+	writeJSON(w, http.StatusOK, WhitelistResponse{
+		Status:    "ok",
+		Message:   msg,
+		Whitelist: list,
+		Reloaded:  reloadIfRunning(r, containerId, "whitelist reload"),
+	})
+}
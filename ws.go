@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+var upgrader = websocket.Upgrader{
+	CheckOrigin: checkOrigin,
+}
+
+// allowedOrigins are the browser origins, as scheme://host[:port], that may
+// open WebSockets. "*" in ALLOWED_ORIGINS allows any origin and is meant for
+// local development only.
+var (
+	allowedOrigins = map[string]bool{}
+	allowAnyOrigin bool
+)
+
+// configureAllowedOrigins reads the comma-separated ALLOWED_ORIGINS. When it
+// is unset only same-host origins are accepted.
+func configureAllowedOrigins() {
+	for _, o := range strings.Split(os.Getenv("ALLOWED_ORIGINS"), ",") {
+		o = strings.TrimSpace(o)
+		switch {
+		case o == "":
+		case o == "*":
+			allowAnyOrigin = true
+		default:
+			allowedOrigins[normalizeOrigin(o)] = true
+		}
+	}
+	if allowAnyOrigin {
+		slog.Warn("ALLOWED_ORIGINS=* lets any website call the API and open WebSockets with a user's credentials; use it for development only")
+	}
+}
+
+func normalizeOrigin(o string) string {
+	return strings.ToLower(strings.TrimSuffix(o, "/"))
+}
+
+// checkOrigin guards against cross-site WebSocket hijacking: a page on another
+// site must not be able to open a console with the credentials of a browser
+// that visits it. Requests without Origin don't come from a browser and are
+// left to the token check.
+func checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" || allowAnyOrigin {
+		return true
+	}
+	if allowedOrigins[normalizeOrigin(origin)] {
+		return true
+	}
+	if len(allowedOrigins) == 0 {
+		if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, r.Host) {
+			return true
+		}
+	}
+	requestLogger(r).Warn("WebSocket origin rejected", "origin", origin)
+	return false
+}
+
+const (
+	// wsWriteTimeout bounds a single WebSocket write so a stalled client
+	// can't hold a handler forever.
+	wsWriteTimeout = 10 * time.Second
+	// wsPingInterval is how often idle connections are pinged, well inside
+	// the idle timeouts of common proxies. A client that hasn't answered
+	// within wsPongWait is considered gone.
+	wsPingInterval = 30 * time.Second
+	wsPongWait     = 45 * time.Second
+)
+
+// startHeartbeat pings conn every wsPingInterval until stop is closed. Each
+// pong extends the read deadline, so a connection whose peer vanished makes
+// the handler's next read fail instead of hanging forever.
+func startHeartbeat(conn *websocket.Conn, stop <-chan struct{}) {
+	conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(wsPongWait))
+	})
+	go func() {
+		ticker := time.NewTicker(wsPingInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(wsWriteTimeout)); err != nil {
+					conn.Close()
+					return
+				}
+			}
+		}
+	}()
+}
+
+// trackSession registers conn as an open session and closes it with a "going
+// away" frame when the agent shuts down, which ends the handler's read loop.
+// The returned func must be called once the handler has cleaned up.
+func trackSession(conn *websocket.Conn) func() {
+	wsSessions.Add(1)
+	stop := context.AfterFunc(shuttingDown, func() {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseGoingAway, "agent shutting down"), time.Now().Add(time.Second))
+		conn.Close()
+	})
+	return func() {
+		stop()
+		wsSessions.Done()
+	}
+}